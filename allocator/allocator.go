@@ -1,6 +1,11 @@
 package allocator
 
-import "unsafe"
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"unsafe"
+)
 
 // SlabConfig ...
 type SlabConfig struct {
@@ -15,6 +20,9 @@ type Config struct {
 	Slabs        []SlabConfig
 }
 
+// RelocateFunc is called after the content of an element had been moved from *from* to *to*
+type RelocateFunc func(from uint32, to uint32)
+
 // Allocator ...
 type Allocator struct {
 	buddy Buddy
@@ -25,6 +33,11 @@ type Allocator struct {
 	slabSizeList []uint32
 
 	memoryUsage uint64
+
+	numLargeItems uint64
+	largeBytes    uint64
+
+	relocate RelocateFunc
 }
 
 func findMinSizeLog(slabs []SlabConfig) uint32 {
@@ -46,29 +59,41 @@ func allocateData(minSizeLog uint32, sizeMultiple uint32) []uint64 {
 	return make([]uint64, sizeMultiple<<(minSizeLog-3))
 }
 
-func allocatorValidateConfig(conf Config) {
+func allocatorValidateConfig(conf Config) error {
 	if conf.MemLimit <= 0 {
-		panic("MemLimit must > 0")
+		return newConfigError("MemLimit", "MemLimit must > 0")
 	}
 	if conf.LRUEntrySize == 0 {
-		panic("LRUEntrySize must > 0")
+		return newConfigError("LRUEntrySize", "LRUEntrySize must > 0")
 	}
 	if len(conf.Slabs) == 0 {
-		panic("Slabs list must not empty")
+		return newConfigError("Slabs", "Slabs list must not empty")
 	}
-	for _, s := range conf.Slabs {
+	for i, s := range conf.Slabs {
 		if s.ElemSize == 0 {
-			panic("ElemSize must > 0")
+			return newConfigError(fmt.Sprintf("Slabs[%d].ElemSize", i), "ElemSize must > 0")
 		}
 		if s.ChunkSizeLog == 0 {
-			panic("ChunkSizeLog must > 0")
+			return newConfigError(fmt.Sprintf("Slabs[%d].ChunkSizeLog", i), "ChunkSizeLog must > 0")
 		}
 	}
+	return nil
 }
 
-// New ...
+// New panics if the config is invalid, see TryNew
 func New(conf Config) *Allocator {
-	allocatorValidateConfig(conf)
+	a, err := TryNew(conf)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// TryNew returns a *ConfigError if the config is invalid
+func TryNew(conf Config) (*Allocator, error) {
+	if err := allocatorValidateConfig(conf); err != nil {
+		return nil, err
+	}
 
 	minSizeLog := findMinSizeLog(conf.Slabs)
 	sizeMultiple := findSizeMultiple(minSizeLog, conf.MemLimit)
@@ -94,7 +119,7 @@ func New(conf Config) *Allocator {
 
 	result.memoryUsage = 0
 
-	return result
+	return result, nil
 }
 
 func findSlabIndex(sizes []uint32, value uint32) int {
@@ -116,8 +141,35 @@ func (a *Allocator) GetMemUsage() uint64 {
 	return a.memoryUsage
 }
 
-// Allocate ...
+// largeSizeLog is the size log of the buddy block for sizes larger than the largest slab
+func (a *Allocator) largeSizeLog(size uint32) uint32 {
+	sizeLog := uint32(bits.Len32(size - 1))
+	if sizeLog < a.buddy.minSize {
+		return a.buddy.minSize
+	}
+	return sizeLog
+}
+
+func (a *Allocator) isLarge(size uint32) bool {
+	return size > a.GetMaxSlabSize()
+}
+
+// Allocate allocates from the slab of *size*.
+// Sizes larger than the largest slab are allocated directly from the buddy,
+// rounded up to a power of 2 and at least the min chunk size
 func (a *Allocator) Allocate(size uint32) (uint32, bool) {
+	if a.isLarge(size) {
+		sizeLog := a.largeSizeLog(size)
+		addr, ok := a.buddy.Allocate(sizeLog)
+		if !ok {
+			return 0, false
+		}
+		a.memoryUsage += 1 << sizeLog
+		a.numLargeItems++
+		a.largeBytes += 1 << sizeLog
+		return addr, true
+	}
+
 	index := findSlabIndex(a.slabSizeList, size)
 	slab := a.slabs[index]
 
@@ -129,9 +181,25 @@ func (a *Allocator) Allocate(size uint32) (uint32, bool) {
 	return addr, ok
 }
 
+// SetRelocateFunc registers the function that will be called whenever Deallocate moves an element
+func (a *Allocator) SetRelocateFunc(fn RelocateFunc) {
+	a.relocate = fn
+}
+
 // Deallocate can require move the item from *movedAddr* to *addr*
 // Can NOT access the *movedAddr*, the content already in the *addr*
+// The relocate function is called before returning if the move happened
 func (a *Allocator) Deallocate(addr uint32, size uint32) (movedAddr uint32, needMove bool) {
+	if a.isLarge(size) {
+		sizeLog := a.largeSizeLog(size)
+		a.buddy.poison(addr, 1<<sizeLog)
+		a.buddy.Deallocate(addr, sizeLog)
+		a.memoryUsage -= 1 << sizeLog
+		a.numLargeItems--
+		a.largeBytes -= 1 << sizeLog
+		return 0, false
+	}
+
 	index := findSlabIndex(a.slabSizeList, size)
 	slab := a.slabs[index]
 
@@ -141,20 +209,63 @@ func (a *Allocator) Deallocate(addr uint32, size uint32) (movedAddr uint32, need
 
 	a.memoryUsage = a.memoryUsage - prevUsage + nextUsage
 
+	if needMove && a.relocate != nil {
+		a.relocate(movedAddr, addr)
+	}
 	return
 }
 
+// NumSlabs returns the number of slab classes
+func (a *Allocator) NumSlabs() int {
+	return len(a.slabs)
+}
+
+// GetSlab returns the slab class at *index*
+func (a *Allocator) GetSlab(index int) *Slab {
+	return a.slabs[index]
+}
+
+// GetSlabIndex returns the index of the slab class of *size*, returns false for sizes larger than the largest slab
+func (a *Allocator) GetSlabIndex(size uint32) (int, bool) {
+	if a.isLarge(size) {
+		return 0, false
+	}
+	return findSlabIndex(a.slabSizeList, size), true
+}
+
 // GetLRUSlab ...
 func (a *Allocator) GetLRUSlab() *RealSlab {
 	return a.lruSlab
 }
 
+// CreateRealSlab creates a RealSlab sharing the memory of the allocator, with the same chunk size as the LRU slab
+func (a *Allocator) CreateRealSlab(elemSize uint32) *RealSlab {
+	return NewRealSlab(&a.buddy, elemSize, a.lruSlab.chunkSizeLog)
+}
+
 // ToRealAddr ...
 func (a *Allocator) ToRealAddr(addr uint32) unsafe.Pointer {
 	return a.buddy.ToRealAddr(addr)
 }
 
-// GetSlabSize ...
+// GetMaxSlabSize returns the element size of the largest slab
+func (a *Allocator) GetMaxSlabSize() uint32 {
+	return a.slabSizeList[len(a.slabSizeList)-1]
+}
+
+// GetMaxSize returns the max size that can be allocated, the size of the largest buddy block
+func (a *Allocator) GetMaxSize() uint32 {
+	if a.buddy.maxSize >= 32 {
+		return math.MaxUint32
+	}
+	return 1 << a.buddy.maxSize
+}
+
+// GetSlabSize returns the number of bytes used for allocating *size*,
+// the element size of its slab or the size of the buddy block for large sizes
 func (a *Allocator) GetSlabSize(size uint32) uint32 {
+	if a.isLarge(size) {
+		return 1 << a.largeSizeLog(size)
+	}
 	return a.slabSizeList[findSlabIndex(a.slabSizeList, size)]
 }
@@ -1,7 +1,9 @@
 package allocator
 
 import (
+	"errors"
 	"github.com/stretchr/testify/assert"
+	"math/rand"
 	"testing"
 )
 
@@ -113,22 +115,26 @@ func TestAllocatorValidateConfig(t *testing.T) {
 	table := []struct {
 		name     string
 		conf     Config
-		panicStr string
+		field    string
+		errorStr string
 	}{
 		{
 			name:     "zero-mem",
-			panicStr: "MemLimit must > 0",
+			field:    "MemLimit",
+			errorStr: "invalid config: MemLimit must > 0",
 		},
 		{
 			name:     "negative-mem",
-			panicStr: "MemLimit must > 0",
+			field:    "MemLimit",
+			errorStr: "invalid config: MemLimit must > 0",
 			conf: Config{
 				MemLimit: -1,
 			},
 		},
 		{
 			name:     "zero-lru-entry-size",
-			panicStr: "LRUEntrySize must > 0",
+			field:    "LRUEntrySize",
+			errorStr: "invalid config: LRUEntrySize must > 0",
 			conf: Config{
 				MemLimit:     1,
 				LRUEntrySize: 0,
@@ -136,7 +142,8 @@ func TestAllocatorValidateConfig(t *testing.T) {
 		},
 		{
 			name:     "slab-config-empty",
-			panicStr: "Slabs list must not empty",
+			field:    "Slabs",
+			errorStr: "invalid config: Slabs list must not empty",
 			conf: Config{
 				MemLimit:     1,
 				LRUEntrySize: 8,
@@ -144,7 +151,8 @@ func TestAllocatorValidateConfig(t *testing.T) {
 		},
 		{
 			name:     "slab-elem-size",
-			panicStr: "ElemSize must > 0",
+			field:    "Slabs[0].ElemSize",
+			errorStr: "invalid config: ElemSize must > 0",
 			conf: Config{
 				MemLimit:     1,
 				LRUEntrySize: 8,
@@ -155,7 +163,8 @@ func TestAllocatorValidateConfig(t *testing.T) {
 		},
 		{
 			name:     "slab-chunk-size-log",
-			panicStr: "ChunkSizeLog must > 0",
+			field:    "Slabs[0].ChunkSizeLog",
+			errorStr: "invalid config: ChunkSizeLog must > 0",
 			conf: Config{
 				MemLimit:     1,
 				LRUEntrySize: 8,
@@ -170,15 +179,10 @@ func TestAllocatorValidateConfig(t *testing.T) {
 
 	for _, e := range table {
 		t.Run(e.name, func(t *testing.T) {
-			defer func() {
-				v := recover()
-				if v != nil {
-					assert.Equal(t, v.(string), e.panicStr)
-				} else {
-					assert.Fail(t, "must panic")
-				}
-			}()
-			allocatorValidateConfig(e.conf)
+			err := allocatorValidateConfig(e.conf)
+			assert.True(t, errors.Is(err, ErrInvalidConfig))
+			assert.Equal(t, e.errorStr, err.Error())
+			assert.Equal(t, e.field, err.(*ConfigError).Field)
 		})
 	}
 }
@@ -226,6 +230,11 @@ func TestAllocatorNew(t *testing.T) {
 	assert.Equal(t, uint32(12), alloc.lruSlab.chunkSizeLog)
 	assert.NotNil(t, alloc.GetLRUSlab())
 
+	timerSlab := alloc.CreateRealSlab(32)
+	assert.Equal(t, uint32(32), timerSlab.elemSize)
+	assert.Equal(t, uint32(12), timerSlab.chunkSizeLog)
+	assert.Same(t, &alloc.buddy, timerSlab.buddy)
+
 	assert.Equal(t, alloc.buddy.ToRealAddr(123), alloc.ToRealAddr(123))
 }
 
@@ -313,6 +322,62 @@ func TestAllocator_GetSlabSize(t *testing.T) {
 	assert.Equal(t, uint32(48), a.GetSlabSize(48))
 	assert.Equal(t, uint32(96), a.GetSlabSize(49))
 	assert.Equal(t, uint32(128), a.GetSlabSize(97))
+	assert.Equal(t, uint32(128), a.GetMaxSlabSize())
+
+	// large sizes use the buddy blocks, at least the min chunk size
+	assert.Equal(t, uint32(1<<12), a.GetSlabSize(129))
+	assert.Equal(t, uint32(1<<12), a.GetSlabSize(1<<12))
+	assert.Equal(t, uint32(1<<13), a.GetSlabSize(1<<12+1))
+	assert.Equal(t, uint32(1<<16), a.GetMaxSize())
+
+	assert.Equal(t, 3, a.NumSlabs())
+	assert.Equal(t, uint32(96), a.GetSlab(1).GetElemSize())
+
+	index, ok := a.GetSlabIndex(49)
+	assert.True(t, ok)
+	assert.Equal(t, 1, index)
+
+	_, ok = a.GetSlabIndex(129)
+	assert.False(t, ok)
+}
+
+func TestAllocator_Allocate_Large(t *testing.T) {
+	conf := Config{
+		MemLimit:     4 << 12,
+		LRUEntrySize: 16,
+		Slabs: []SlabConfig{
+			{
+				ElemSize:     48,
+				ChunkSizeLog: 12,
+			},
+		},
+	}
+	a := New(conf)
+	assert.Equal(t, uint32(4<<12), a.GetMaxSize())
+
+	p1, ok := a.Allocate(5000)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(2<<12), a.GetMemUsage())
+
+	p2, ok := a.Allocate(100)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3<<12), a.GetMemUsage())
+	assert.NotEqual(t, p1, p2)
+
+	// the LRU slab and the block of p2 are occupied
+	_, ok = a.Allocate(5000)
+	assert.False(t, ok)
+	_, ok = a.Allocate(4<<12 + 1)
+	assert.False(t, ok)
+
+	movedAddr, needMove := a.Deallocate(p1, 5000)
+	assert.Equal(t, uint32(0), movedAddr)
+	assert.False(t, needMove)
+	assert.Equal(t, uint64(1<<12), a.GetMemUsage())
+
+	p3, ok := a.Allocate(6000)
+	assert.True(t, ok)
+	assert.Equal(t, p1, p3)
 }
 
 func TestAllocator_Allocate_Deallocate(t *testing.T) {
@@ -366,3 +431,140 @@ func TestAllocator_Allocate_Deallocate(t *testing.T) {
 	assert.Equal(t, uint32(0), movedAddr)
 	assert.Equal(t, uint64(0), a.GetMemUsage())
 }
+
+func TestAllocator_Relocate_Func(t *testing.T) {
+	conf := Config{
+		MemLimit:     17 << 12,
+		LRUEntrySize: 16,
+		Slabs: []SlabConfig{
+			{
+				ElemSize:     88,
+				ChunkSizeLog: 12,
+			},
+		},
+	}
+	a := New(conf)
+
+	type move struct {
+		from uint32
+		to   uint32
+	}
+	var moves []move
+	a.SetRelocateFunc(func(from uint32, to uint32) {
+		moves = append(moves, move{from: from, to: to})
+	})
+
+	p1, _ := a.Allocate(80)
+	p2, _ := a.Allocate(80)
+	p3, _ := a.Allocate(80)
+
+	a.Deallocate(p3, 80)
+	assert.Equal(t, []move(nil), moves)
+
+	a.Deallocate(p1, 80)
+	assert.Equal(t, []move{{from: p2, to: p1}}, moves)
+}
+
+func TestAllocator_Relocate_Stress(t *testing.T) {
+	conf := Config{
+		MemLimit:     64 << 12,
+		LRUEntrySize: 16,
+		Slabs: []SlabConfig{
+			{
+				ElemSize:     16,
+				ChunkSizeLog: 12,
+			},
+			{
+				ElemSize:     64,
+				ChunkSizeLog: 12,
+			},
+			{
+				ElemSize:     256,
+				ChunkSizeLog: 13,
+			},
+		},
+	}
+	a := New(conf)
+
+	type object struct {
+		id   uint64
+		size uint32
+	}
+
+	addrs := map[uint64]uint32{}
+	var objects []object
+
+	readID := func(addr uint32) uint64 {
+		return *(*uint64)(a.ToRealAddr(addr))
+	}
+
+	a.SetRelocateFunc(func(from uint32, to uint32) {
+		id := readID(to)
+		assert.Equal(t, from, addrs[id])
+		addrs[id] = to
+	})
+
+	r := rand.New(rand.NewSource(1234))
+	nextID := uint64(0)
+	for i := 0; i < 100000; i++ {
+		if len(objects) == 0 || r.Intn(3) != 0 {
+			size := uint32(8 + r.Intn(248))
+			addr, ok := a.Allocate(size)
+			if !ok {
+				continue
+			}
+			nextID++
+			*(*uint64)(a.ToRealAddr(addr)) = nextID
+			addrs[nextID] = addr
+			objects = append(objects, object{id: nextID, size: size})
+			continue
+		}
+
+		index := r.Intn(len(objects))
+		obj := objects[index]
+		objects[index] = objects[len(objects)-1]
+		objects = objects[:len(objects)-1]
+
+		a.Deallocate(addrs[obj.id], obj.size)
+		delete(addrs, obj.id)
+
+		if i%1000 == 0 {
+			for _, o := range objects {
+				assert.Equal(t, o.id, readID(addrs[o.id]))
+			}
+		}
+	}
+
+	for _, o := range objects {
+		assert.Equal(t, o.id, readID(addrs[o.id]))
+		a.Deallocate(addrs[o.id], o.size)
+	}
+	assert.Equal(t, uint64(0), a.GetMemUsage())
+}
+
+func TestTryNew(t *testing.T) {
+	a, err := TryNew(Config{})
+	assert.Nil(t, a)
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	a, err = TryNew(Config{
+		MemLimit:     1 << 12,
+		LRUEntrySize: 16,
+		Slabs: []SlabConfig{
+			{
+				ElemSize:     64,
+				ChunkSizeLog: 12,
+			},
+		},
+	})
+	assert.Equal(t, nil, err)
+	assert.NotNil(t, a)
+}
+
+func TestNew_Panic(t *testing.T) {
+	defer func() {
+		v := recover()
+		assert.True(t, errors.Is(v.(error), ErrInvalidConfig))
+	}()
+	New(Config{})
+}
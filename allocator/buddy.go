@@ -2,6 +2,7 @@ package allocator
 
 import (
 	"math"
+	"reflect"
 	"unsafe"
 )
 
@@ -9,6 +10,9 @@ const (
 	buddyNullPtr uint32 = math.MaxUint32
 )
 
+// PoisonByte fills the freed memory when PoisonEnabled
+const PoisonByte = 0xdd
+
 // Buddy ...
 type Buddy struct {
 	minSize      uint32
@@ -143,11 +147,40 @@ func (b *Buddy) contentOfList(order uint32) []uint32 {
 	return result
 }
 
+// freeBlockCounts returns the number of free blocks of every size, from the min size to the max size
+func (b *Buddy) freeBlockCounts() []uint32 {
+	result := make([]uint32, len(b.buckets))
+	for offset, addr := range b.buckets {
+		for addr != buddyNullPtr {
+			result[offset]++
+			addr = (*buddyListHead)(b.ToRealAddr(addr)).next
+		}
+	}
+	return result
+}
+
 // ToRealAddr ...
 func (b *Buddy) ToRealAddr(addr uint32) unsafe.Pointer {
 	return unsafe.Pointer(uintptr(b.data) + uintptr(addr))
 }
 
+// poison fills *size* bytes at *addr* with PoisonByte, only when built with the espresso_debug tag
+func (b *Buddy) poison(addr uint32, size uint32) {
+	if !PoisonEnabled {
+		return
+	}
+
+	var data []byte
+	p := (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	p.Data = uintptr(b.ToRealAddr(addr))
+	p.Len = int(size)
+	p.Cap = int(size)
+
+	for i := range data {
+		data[i] = PoisonByte
+	}
+}
+
 // Allocate ...
 func (b *Buddy) Allocate(sizeLog uint32) (uint32, bool) {
 	offset := sizeLog - b.minSize
@@ -161,7 +194,8 @@ func (b *Buddy) Allocate(sizeLog uint32) (uint32, bool) {
 
 	addrIndex := b.buckets[emptyOffset]
 	header := (*buddyListHead)(unsafe.Pointer(uintptr(b.data) + uintptr(addrIndex)))
-	b.buckets[emptyOffset] = header.next
+	// the next block must NOT keep pointing to the allocated block
+	buddyRemoveListHead(b.data, &b.buckets[emptyOffset], header)
 	b.clearBit(addrIndex)
 
 	if emptyOffset == offset {
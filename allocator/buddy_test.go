@@ -489,6 +489,30 @@ func TestBuddy_Allocate_Deallocate_Not_Align2(t *testing.T) {
 	assert.Equal(t, []uint64{1, 0, 0, 0, 1, 0}, b.bitset)
 }
 
+func TestBuddy_Allocate_Head_Then_Merge(t *testing.T) {
+	data := make([]uint64, 1<<14)
+	var b Buddy
+	BuddyInit(&b, 12, 32, unsafe.Pointer(&data[0]))
+
+	p1, _ := b.Allocate(12)
+	b.Allocate(12)
+	p3, _ := b.Allocate(12)
+	b.Deallocate(p1, 12)
+	assert.Equal(t, []uint32{0, 3 << 12}, b.contentOfList(12))
+
+	// allocate the head of a list having more than one block
+	p4, _ := b.Allocate(12)
+	assert.Equal(t, p1, p4)
+	*(*uint32)(b.ToRealAddr(p4)) = 1234
+
+	// merging removes the remaining block from the list
+	b.Deallocate(p3, 12)
+	assert.Equal(t, uint32(1234), *(*uint32)(b.ToRealAddr(p4)))
+	assert.Equal(t, []uint32(nil), b.contentOfList(12))
+	assert.Equal(t, []uint32{2 << 12}, b.contentOfList(13))
+	assert.Equal(t, []uint32{0, 1, 1, 1, 1, 0}, b.freeBlockCounts())
+}
+
 func BenchmarkBuddy_Allocate(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		data := make([]uint64, 1<<17)
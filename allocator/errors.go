@@ -0,0 +1,28 @@
+package allocator
+
+import "errors"
+
+// ErrInvalidConfig is matched by errors.Is for all *ConfigError
+var ErrInvalidConfig = errors.New("invalid config")
+
+// ConfigError describes the invalid field of a config
+type ConfigError struct {
+	Field   string
+	Message string
+}
+
+func newConfigError(field string, message string) error {
+	return &ConfigError{
+		Field:   field,
+		Message: message,
+	}
+}
+
+func (e *ConfigError) Error() string {
+	return ErrInvalidConfig.Error() + ": " + e.Message
+}
+
+// Is ...
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
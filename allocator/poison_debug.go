@@ -0,0 +1,8 @@
+//go:build espresso_debug
+// +build espresso_debug
+
+package allocator
+
+// PoisonEnabled is true when built with the espresso_debug tag,
+// freed memory is filled with PoisonByte for catching use-after-free
+const PoisonEnabled = true
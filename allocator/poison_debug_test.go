@@ -0,0 +1,63 @@
+//go:build espresso_debug
+// +build espresso_debug
+
+package allocator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"unsafe"
+)
+
+func poisoned(size int) []byte {
+	result := make([]byte, size)
+	for i := range result {
+		result[i] = PoisonByte
+	}
+	return result
+}
+
+func TestSlab_Deallocate_Poison(t *testing.T) {
+	data := make([]uint64, 1<<17)
+	var buddy Buddy
+	BuddyInit(&buddy, 12, 1<<8, unsafe.Pointer(&data[0]))
+
+	slab := NewSlab(&buddy, 128, 12)
+	p1, _ := slab.Allocate()
+	p2, _ := slab.Allocate()
+	p3, _ := slab.Allocate()
+	slab.SetElem(p1, []byte{1, 2, 3})
+	slab.SetElem(p3, []byte{4, 5, 6})
+
+	// p3 is moved to p1, the old slot of p3 is poisoned
+	movedAddr, needMove := slab.Deallocate(p1)
+	assert.True(t, needMove)
+	assert.Equal(t, p3, movedAddr)
+	assert.Equal(t, []byte{4, 5, 6}, slab.GetElem(p1)[:3])
+	assert.Equal(t, poisoned(128), slab.GetElem(p3))
+
+	_, needMove = slab.Deallocate(p2)
+	assert.False(t, needMove)
+	assert.Equal(t, poisoned(128), slab.GetElem(p2))
+}
+
+func TestAllocator_Deallocate_Large_Poison(t *testing.T) {
+	a := New(Config{
+		MemLimit:     4 << 12,
+		LRUEntrySize: 16,
+		Slabs: []SlabConfig{
+			{
+				ElemSize:     48,
+				ChunkSizeLog: 12,
+			},
+		},
+	})
+
+	addr, ok := a.Allocate(5000)
+	assert.True(t, ok)
+	a.Deallocate(addr, 5000)
+
+	// the list head of the buddy is written at the start of the freed block
+	block := (*[8192]byte)(a.ToRealAddr(addr))
+	assert.Equal(t, poisoned(8192-64), block[64:])
+}
@@ -78,6 +78,7 @@ func (s *RealSlab) Allocate() (uint32, bool) {
 // Deallocate ...
 func (s *RealSlab) Deallocate(addr uint32) {
 	s.memoryUsage -= uint64(s.elemSize)
+	s.buddy.poison(addr, s.elemSize)
 	list := (*realSlabListHead)(s.buddy.ToRealAddr(addr))
 	list.next = s.freeList
 	s.freeList = addr
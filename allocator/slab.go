@@ -14,6 +14,7 @@ type Slab struct {
 	numElemPerChunk uint32
 	unusedBytes     uint64
 	memoryUsage     uint64
+	numChunks       uint32
 
 	currentChunkAddr uint32
 	freeListIndex    uint32
@@ -59,6 +60,7 @@ func (s *Slab) Allocate() (uint32, bool) {
 		}
 		s.currentChunkAddr = chunkAddr
 		s.memoryUsage += s.unusedBytes
+		s.numChunks++
 	}
 
 	result := s.currentChunkAddr + s.freeListIndex*s.elemSize
@@ -83,6 +85,7 @@ func (s *Slab) putBackChunkToBuddyIfFree() {
 		s.buddy.Deallocate(s.currentChunkAddr, s.chunkSizeLog)
 		s.currentChunkAddr = buddyNullPtr
 		s.memoryUsage -= s.unusedBytes
+		s.numChunks--
 	}
 }
 
@@ -100,12 +103,14 @@ func (s *Slab) Deallocate(addr uint32) (uint32, bool) {
 	movedAddr := s.currentChunkAddr + (s.freeListIndex-1)*s.elemSize
 	if movedAddr == addr {
 		s.freeListIndex--
+		s.buddy.poison(addr, s.elemSize)
 		s.putBackChunkToBuddyIfFree()
 		return 0, false
 	}
 
 	s.freeListIndex--
 	s.copyData(addr, movedAddr)
+	s.buddy.poison(movedAddr, s.elemSize)
 	s.putBackChunkToBuddyIfFree()
 
 	return movedAddr, true
@@ -115,3 +120,35 @@ func (s *Slab) Deallocate(addr uint32) (uint32, bool) {
 func (s *Slab) GetMemUsage() uint64 {
 	return s.memoryUsage
 }
+
+// GetElemSize ...
+func (s *Slab) GetElemSize() uint32 {
+	return s.elemSize
+}
+
+// GetChunkSizeLog returns the size log of the chunks allocated from the buddy
+func (s *Slab) GetChunkSizeLog() uint32 {
+	return s.chunkSizeLog
+}
+
+// GetNumChunks returns the number of chunks allocated from the buddy
+func (s *Slab) GetNumChunks() uint32 {
+	return s.numChunks
+}
+
+// GetNumElems returns the number of allocated elements
+func (s *Slab) GetNumElems() uint32 {
+	if s.currentChunkAddr == buddyNullPtr {
+		return s.numChunks * s.numElemPerChunk
+	}
+	return (s.numChunks-1)*s.numElemPerChunk + s.freeListIndex
+}
+
+// NumElemsToFreeChunk returns the number of elements must be deallocated before a chunk is put back to the buddy.
+// Elements are compacted, so any elements can be deallocated
+func (s *Slab) NumElemsToFreeChunk() uint32 {
+	if s.currentChunkAddr == buddyNullPtr {
+		return s.numElemPerChunk
+	}
+	return s.freeListIndex
+}
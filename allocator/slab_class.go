@@ -0,0 +1,145 @@
+package allocator
+
+import (
+	"math"
+	"math/bits"
+)
+
+const (
+	// slabClassAlignment is the alignment of generated element sizes
+	slabClassAlignment = 8
+	// minChunkSizeLog is the min chunk size of generated slab classes
+	minChunkSizeLog = 12
+	// minElemsPerChunk is the min number of elements in a chunk of generated slab classes
+	minElemsPerChunk = 8
+)
+
+// SlabClassConfig is used for generating the slab classes of an allocator Config, similar to memcached.
+// Element sizes start at MinItemSize and grow by GrowthFactor until reaching MaxItemSize
+type SlabClassConfig struct {
+	MemLimit     int
+	LRUEntrySize uint32
+
+	MinItemSize  uint32
+	MaxItemSize  uint32
+	GrowthFactor float64
+}
+
+// SlabClassReport describes the internal fragmentation of a slab class
+type SlabClassReport struct {
+	ElemSize      uint32
+	ChunkSizeLog  uint32
+	ElemsPerChunk uint32
+
+	// MinItemSize is the smallest item size stored in this class
+	MinItemSize uint32
+	// ChunkWaste is the number of unused bytes at the end of every chunk
+	ChunkWaste uint32
+
+	// MaxFragmentation is the wasted fraction of a chunk when all items have the size MinItemSize
+	MaxFragmentation float64
+	// ExpectedFragmentation is the wasted fraction of a chunk
+	// when item sizes are uniformly distributed between MinItemSize and ElemSize
+	ExpectedFragmentation float64
+}
+
+func alignSlabClassSize(size uint32) uint32 {
+	return (size + slabClassAlignment - 1) &^ (slabClassAlignment - 1)
+}
+
+func slabClassChunkSizeLog(elemSize uint32) uint32 {
+	sizeLog := uint32(bits.Len64(uint64(elemSize)*minElemsPerChunk - 1))
+	if sizeLog < minChunkSizeLog {
+		return minChunkSizeLog
+	}
+	return sizeLog
+}
+
+func validateSlabClassConfig(conf SlabClassConfig) error {
+	if conf.MemLimit <= 0 {
+		return newConfigError("MemLimit", "MemLimit must > 0")
+	}
+	if conf.MinItemSize == 0 {
+		return newConfigError("MinItemSize", "MinItemSize must > 0")
+	}
+	if conf.MaxItemSize < conf.MinItemSize {
+		return newConfigError("MaxItemSize", "MaxItemSize must >= MinItemSize")
+	}
+	if conf.MaxItemSize > math.MaxUint32-slabClassAlignment {
+		return newConfigError("MaxItemSize", "MaxItemSize is too large")
+	}
+	if !(conf.GrowthFactor > 1) {
+		return newConfigError("GrowthFactor", "GrowthFactor must > 1")
+	}
+
+	chunkSize := uint64(1) << slabClassChunkSizeLog(alignSlabClassSize(conf.MaxItemSize))
+	if chunkSize > uint64(conf.MemLimit) {
+		return newConfigError("MaxItemSize", "chunk size of MaxItemSize must <= MemLimit")
+	}
+	return nil
+}
+
+// GenerateConfig generates the slab classes of an allocator Config.
+// Element sizes are aligned to 8 bytes, every chunk has at least 8 elements and is at least 4KB
+func GenerateConfig(conf SlabClassConfig) (Config, error) {
+	if err := validateSlabClassConfig(conf); err != nil {
+		return Config{}, err
+	}
+
+	maxSize := alignSlabClassSize(conf.MaxItemSize)
+
+	var slabs []SlabConfig
+	size := alignSlabClassSize(conf.MinItemSize)
+	for size < maxSize {
+		slabs = append(slabs, SlabConfig{
+			ElemSize:     size,
+			ChunkSizeLog: slabClassChunkSizeLog(size),
+		})
+
+		next := alignSlabClassSize(uint32(math.Min(float64(size)*conf.GrowthFactor, float64(maxSize))))
+		if next <= size {
+			next = size + slabClassAlignment
+		}
+		size = next
+	}
+	slabs = append(slabs, SlabConfig{
+		ElemSize:     maxSize,
+		ChunkSizeLog: slabClassChunkSizeLog(maxSize),
+	})
+
+	return Config{
+		MemLimit:     conf.MemLimit,
+		LRUEntrySize: conf.LRUEntrySize,
+		Slabs:        slabs,
+	}, nil
+}
+
+// FragmentationReport computes the internal fragmentation of every slab class of the config
+func FragmentationReport(conf Config) []SlabClassReport {
+	result := make([]SlabClassReport, 0, len(conf.Slabs))
+
+	minItemSize := uint32(1)
+	for _, s := range conf.Slabs {
+		chunkSize := uint64(1) << s.ChunkSizeLog
+		elemsPerChunk := chunkSize / uint64(s.ElemSize)
+		chunkWaste := chunkSize - elemsPerChunk*uint64(s.ElemSize)
+
+		maxWaste := elemsPerChunk*uint64(s.ElemSize-minItemSize) + chunkWaste
+		expectedWaste := float64(elemsPerChunk)*float64(s.ElemSize-minItemSize)/2 + float64(chunkWaste)
+
+		result = append(result, SlabClassReport{
+			ElemSize:      s.ElemSize,
+			ChunkSizeLog:  s.ChunkSizeLog,
+			ElemsPerChunk: uint32(elemsPerChunk),
+
+			MinItemSize: minItemSize,
+			ChunkWaste:  uint32(chunkWaste),
+
+			MaxFragmentation:      float64(maxWaste) / float64(chunkSize),
+			ExpectedFragmentation: expectedWaste / float64(chunkSize),
+		})
+
+		minItemSize = s.ElemSize + 1
+	}
+	return result
+}
@@ -0,0 +1,186 @@
+package allocator
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestGenerateConfig(t *testing.T) {
+	table := []struct {
+		name     string
+		conf     SlabClassConfig
+		expected []SlabConfig
+	}{
+		{
+			name: "normal",
+			conf: SlabClassConfig{
+				MemLimit:     1 << 20,
+				LRUEntrySize: 24,
+				MinItemSize:  48,
+				MaxItemSize:  1024,
+				GrowthFactor: 1.25,
+			},
+			expected: []SlabConfig{
+				{ElemSize: 48, ChunkSizeLog: 12},
+				{ElemSize: 64, ChunkSizeLog: 12},
+				{ElemSize: 80, ChunkSizeLog: 12},
+				{ElemSize: 104, ChunkSizeLog: 12},
+				{ElemSize: 136, ChunkSizeLog: 12},
+				{ElemSize: 176, ChunkSizeLog: 12},
+				{ElemSize: 224, ChunkSizeLog: 12},
+				{ElemSize: 280, ChunkSizeLog: 12},
+				{ElemSize: 352, ChunkSizeLog: 12},
+				{ElemSize: 440, ChunkSizeLog: 12},
+				{ElemSize: 552, ChunkSizeLog: 13},
+				{ElemSize: 696, ChunkSizeLog: 13},
+				{ElemSize: 872, ChunkSizeLog: 13},
+				{ElemSize: 1024, ChunkSizeLog: 13},
+			},
+		},
+		{
+			name: "small-growth-factor",
+			conf: SlabClassConfig{
+				MemLimit:     1 << 20,
+				MinItemSize:  10,
+				MaxItemSize:  40,
+				GrowthFactor: 1.01,
+			},
+			expected: []SlabConfig{
+				{ElemSize: 16, ChunkSizeLog: 12},
+				{ElemSize: 24, ChunkSizeLog: 12},
+				{ElemSize: 32, ChunkSizeLog: 12},
+				{ElemSize: 40, ChunkSizeLog: 12},
+			},
+		},
+		{
+			name: "single-class",
+			conf: SlabClassConfig{
+				MemLimit:     1 << 20,
+				MinItemSize:  100,
+				MaxItemSize:  100,
+				GrowthFactor: 2,
+			},
+			expected: []SlabConfig{
+				{ElemSize: 104, ChunkSizeLog: 12},
+			},
+		},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			conf, err := GenerateConfig(e.conf)
+			assert.Equal(t, nil, err)
+			assert.Equal(t, Config{
+				MemLimit:     e.conf.MemLimit,
+				LRUEntrySize: e.conf.LRUEntrySize,
+				Slabs:        e.expected,
+			}, conf)
+		})
+	}
+}
+
+func TestGenerateConfig_Validate(t *testing.T) {
+	newConf := func() SlabClassConfig {
+		return SlabClassConfig{
+			MemLimit:     1 << 20,
+			LRUEntrySize: 24,
+			MinItemSize:  48,
+			MaxItemSize:  1024,
+			GrowthFactor: 1.25,
+		}
+	}
+
+	table := []struct {
+		name   string
+		modify func(conf *SlabClassConfig)
+		field  string
+	}{
+		{
+			name:   "mem-limit",
+			modify: func(conf *SlabClassConfig) { conf.MemLimit = 0 },
+			field:  "MemLimit",
+		},
+		{
+			name:   "min-item-size",
+			modify: func(conf *SlabClassConfig) { conf.MinItemSize = 0 },
+			field:  "MinItemSize",
+		},
+		{
+			name:   "max-item-size",
+			modify: func(conf *SlabClassConfig) { conf.MaxItemSize = 47 },
+			field:  "MaxItemSize",
+		},
+		{
+			name:   "growth-factor",
+			modify: func(conf *SlabClassConfig) { conf.GrowthFactor = 1 },
+			field:  "GrowthFactor",
+		},
+		{
+			name:   "chunk-exceed-mem-limit",
+			modify: func(conf *SlabClassConfig) { conf.MemLimit = 1 << 12 },
+			field:  "MaxItemSize",
+		},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			conf := newConf()
+			e.modify(&conf)
+
+			_, err := GenerateConfig(conf)
+			assert.True(t, errors.Is(err, ErrInvalidConfig))
+			assert.Equal(t, e.field, err.(*ConfigError).Field)
+		})
+	}
+}
+
+func TestGenerateConfig_Allocator(t *testing.T) {
+	conf, err := GenerateConfig(SlabClassConfig{
+		MemLimit:     1 << 20,
+		LRUEntrySize: 24,
+		MinItemSize:  48,
+		MaxItemSize:  1024,
+		GrowthFactor: 1.25,
+	})
+	assert.Equal(t, nil, err)
+
+	a, err := TryNew(conf)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint32(1024), a.GetMaxSlabSize())
+
+	for size := uint32(1); size <= 1024; size += 7 {
+		_, ok := a.Allocate(size)
+		assert.True(t, ok)
+	}
+}
+
+func TestFragmentationReport(t *testing.T) {
+	report := FragmentationReport(Config{
+		Slabs: []SlabConfig{
+			{ElemSize: 48, ChunkSizeLog: 12},
+			{ElemSize: 96, ChunkSizeLog: 12},
+		},
+	})
+
+	assert.Equal(t, []SlabClassReport{
+		{
+			ElemSize:              48,
+			ChunkSizeLog:          12,
+			ElemsPerChunk:         85,
+			MinItemSize:           1,
+			ChunkWaste:            16,
+			MaxFragmentation:      4011.0 / 4096,
+			ExpectedFragmentation: 2013.5 / 4096,
+		},
+		{
+			ElemSize:              96,
+			ChunkSizeLog:          12,
+			ElemsPerChunk:         42,
+			MinItemSize:           49,
+			ChunkWaste:            64,
+			MaxFragmentation:      2038.0 / 4096,
+			ExpectedFragmentation: 1051.0 / 4096,
+		},
+	}, report)
+}
@@ -261,3 +261,32 @@ func BenchmarkSlab_Allocate_Deallocate_Not_Interact_Buddy(b *testing.B) {
 		slab.Deallocate(p1)
 	}
 }
+
+func TestSlab_Num_Chunks(t *testing.T) {
+	data := make([]uint64, 1<<17)
+	var buddy Buddy
+	BuddyInit(&buddy, 12, 1<<8, unsafe.Pointer(&data[0]))
+
+	slab := NewSlab(&buddy, 1024, 12)
+	assert.Equal(t, uint32(1024), slab.GetElemSize())
+	assert.Equal(t, uint32(0), slab.GetNumChunks())
+	assert.Equal(t, uint32(4), slab.NumElemsToFreeChunk())
+
+	var addrs []uint32
+	for i := 0; i < 5; i++ {
+		addr, ok := slab.Allocate()
+		assert.True(t, ok)
+		addrs = append(addrs, addr)
+	}
+	assert.Equal(t, uint32(2), slab.GetNumChunks())
+	assert.Equal(t, uint32(1), slab.NumElemsToFreeChunk())
+
+	// any element can be deallocated, the last one is moved into its place
+	slab.Deallocate(addrs[0])
+	assert.Equal(t, uint32(1), slab.GetNumChunks())
+	assert.Equal(t, uint32(4), slab.NumElemsToFreeChunk())
+
+	slab.Deallocate(addrs[1])
+	assert.Equal(t, uint32(1), slab.GetNumChunks())
+	assert.Equal(t, uint32(3), slab.NumElemsToFreeChunk())
+}
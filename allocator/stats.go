@@ -0,0 +1,86 @@
+package allocator
+
+// SlabStats ...
+type SlabStats struct {
+	ElemSize     uint32
+	ChunkSizeLog uint32
+	NumItems     uint32
+	NumChunks    uint32
+
+	// ItemBytes is the number of bytes used by the allocated elements
+	ItemBytes uint64
+	// WastedBytes is the number of bytes of the chunks NOT used by any element
+	WastedBytes uint64
+}
+
+// Stats is a snapshot of the allocator
+type Stats struct {
+	// TotalBytes is the size of the memory managed by the buddy allocator
+	TotalBytes uint64
+	// MemUsage is the same as GetMemUsage, the memory used by the slabs and the large items
+	MemUsage uint64
+
+	Slabs []SlabStats
+
+	// LargeItems is the number of items larger than the largest slab, allocated directly from the buddy
+	LargeItems uint64
+	LargeBytes uint64
+
+	// MinBlockSizeLog is the size log of the smallest buddy block
+	MinBlockSizeLog uint32
+	// FreeBlocks is the number of free buddy blocks of every size, FreeBlocks[i] has the size 1 << (MinBlockSizeLog + i)
+	FreeBlocks []uint32
+	FreeBytes  uint64
+	// Fragmentation is the fraction of the free memory outside of the largest free block, zero if no memory is free
+	Fragmentation float64
+}
+
+// Stats returns a snapshot of the allocator
+func (a *Allocator) Stats() Stats {
+	slabs := make([]SlabStats, 0, len(a.slabs))
+	for _, s := range a.slabs {
+		numItems := s.GetNumElems()
+		itemBytes := uint64(numItems) * uint64(s.elemSize)
+
+		slabs = append(slabs, SlabStats{
+			ElemSize:     s.elemSize,
+			ChunkSizeLog: s.chunkSizeLog,
+			NumItems:     numItems,
+			NumChunks:    s.numChunks,
+
+			ItemBytes:   itemBytes,
+			WastedBytes: uint64(s.numChunks)<<s.chunkSizeLog - itemBytes,
+		})
+	}
+
+	freeBlocks := a.buddy.freeBlockCounts()
+	freeBytes := uint64(0)
+	largestFreeBlock := uint64(0)
+	for i, count := range freeBlocks {
+		blockSize := uint64(1) << (a.buddy.minSize + uint32(i))
+		freeBytes += uint64(count) * blockSize
+		if count > 0 {
+			largestFreeBlock = blockSize
+		}
+	}
+
+	fragmentation := 0.0
+	if freeBytes > 0 {
+		fragmentation = 1 - float64(largestFreeBlock)/float64(freeBytes)
+	}
+
+	return Stats{
+		TotalBytes: uint64(a.buddy.sizeMultiple) << a.buddy.minSize,
+		MemUsage:   a.memoryUsage,
+
+		Slabs: slabs,
+
+		LargeItems: a.numLargeItems,
+		LargeBytes: a.largeBytes,
+
+		MinBlockSizeLog: a.buddy.minSize,
+		FreeBlocks:      freeBlocks,
+		FreeBytes:       freeBytes,
+		Fragmentation:   fragmentation,
+	}
+}
@@ -0,0 +1,67 @@
+package allocator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestAllocator_Stats(t *testing.T) {
+	a := New(Config{
+		MemLimit:     8 << 12,
+		LRUEntrySize: 16,
+		Slabs: []SlabConfig{
+			{
+				ElemSize:     48,
+				ChunkSizeLog: 12,
+			},
+			{
+				ElemSize:     96,
+				ChunkSizeLog: 12,
+			},
+		},
+	})
+
+	for i := 0; i < 100; i++ {
+		_, ok := a.Allocate(40)
+		assert.True(t, ok)
+	}
+	largeAddr, ok := a.Allocate(5000)
+	assert.True(t, ok)
+
+	assert.Equal(t, Stats{
+		TotalBytes: 8 << 12,
+		MemUsage:   100*48 + 2*16 + 8192,
+
+		Slabs: []SlabStats{
+			{
+				ElemSize:     48,
+				ChunkSizeLog: 12,
+				NumItems:     100,
+				NumChunks:    2,
+				ItemBytes:    100 * 48,
+				WastedBytes:  2<<12 - 100*48,
+			},
+			{
+				ElemSize:     96,
+				ChunkSizeLog: 12,
+			},
+		},
+
+		LargeItems: 1,
+		LargeBytes: 8192,
+
+		MinBlockSizeLog: 12,
+		FreeBlocks:      []uint32{0, 0, 1, 0},
+		FreeBytes:       4 << 12,
+		Fragmentation:   0,
+	}, a.Stats())
+
+	a.Deallocate(largeAddr, 5000)
+
+	stats := a.Stats()
+	assert.Equal(t, uint64(0), stats.LargeItems)
+	assert.Equal(t, uint64(0), stats.LargeBytes)
+	assert.Equal(t, []uint32{0, 1, 1, 0}, stats.FreeBlocks)
+	assert.Equal(t, uint64(6<<12), stats.FreeBytes)
+	assert.InDelta(t, 1.0/3, stats.Fragmentation, 1e-9)
+}
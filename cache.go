@@ -0,0 +1,298 @@
+package espresso
+
+import (
+	"math/bits"
+	"sync"
+	"time"
+)
+
+// CacheConfig ...
+type CacheConfig struct {
+	// NumPartitions is the number of independent shards, must be a power of 2
+	NumPartitions int
+
+	// PartitionConfig is the config of every shard,
+	// AllocatorConfig.MemLimit is the memory limit of a single shard
+	PartitionConfig PartitionConfig
+
+	// Hasher is used for hashing keys, default to DefaultHasher
+	Hasher Hasher
+
+	// LoadTTL is the TTL of the values loaded by GetOrLoad, zero means no expiration
+	LoadTTL time.Duration
+	// LoadErrorTTL is the duration the errors of loaders are returned by GetOrLoad without calling the loaders again,
+	// zero means the errors are NOT cached
+	LoadErrorTTL time.Duration
+}
+
+type cachePartition struct {
+	mut       sync.Mutex
+	partition *Partition
+}
+
+// Cache is a sharded cache that is safe for concurrent use.
+// Every key is routed to a single Partition, guarded by its own lock.
+type Cache struct {
+	hasher     Hasher
+	shift      uint32
+	partitions []cachePartition
+
+	clock        Clock
+	loadTTL      time.Duration
+	loadErrorTTL time.Duration
+	loads        loadGroup
+}
+
+func validateCacheConfig(conf CacheConfig) error {
+	if conf.NumPartitions <= 0 {
+		return newConfigError("NumPartitions", "NumPartitions must > 0")
+	}
+	if conf.NumPartitions&(conf.NumPartitions-1) != 0 {
+		return newConfigError("NumPartitions", "NumPartitions must be a power of 2")
+	}
+	if conf.LoadTTL < 0 {
+		return newConfigError("LoadTTL", "LoadTTL must >= 0")
+	}
+	if conf.LoadErrorTTL < 0 {
+		return newConfigError("LoadErrorTTL", "LoadErrorTTL must >= 0")
+	}
+	return nil
+}
+
+// NewCache panics if the config is invalid, see TryNewCache
+func NewCache(conf CacheConfig) *Cache {
+	c, err := TryNewCache(conf)
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// TryNewCache returns an error matching ErrInvalidConfig if the config is invalid
+func TryNewCache(conf CacheConfig) (*Cache, error) {
+	if err := validateCacheConfig(conf); err != nil {
+		return nil, err
+	}
+
+	partitions := make([]cachePartition, conf.NumPartitions)
+	for i := range partitions {
+		p, err := TryNewPartition(conf.PartitionConfig)
+		if err != nil {
+			return nil, err
+		}
+		partitions[i].partition = p
+	}
+
+	hasher := conf.Hasher
+	if hasher == nil {
+		hasher = DefaultHasher
+	}
+
+	clock := conf.PartitionConfig.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	return &Cache{
+		hasher:     hasher,
+		shift:      uint32(64 - bits.TrailingZeros(uint(conf.NumPartitions))),
+		partitions: partitions,
+
+		clock:        clock,
+		loadTTL:      conf.LoadTTL,
+		loadErrorTTL: conf.LoadErrorTTL,
+	}, nil
+}
+
+func (c *Cache) getPartition(hash uint64) *cachePartition {
+	// use the high bits for choosing partition, the low bits are used inside the partition
+	if c.shift >= 64 {
+		return &c.partitions[0]
+	}
+	return &c.partitions[hash>>c.shift]
+}
+
+func cloneBytes(data []byte) []byte {
+	if data == nil {
+		return nil
+	}
+	result := make([]byte, len(data))
+	copy(result, data)
+	return result
+}
+
+// LeaseGet gets the value of the key, or grants a lease to the caller for setting the value.
+// Returns ErrNoSpace or ErrValueTooLarge if the lease entry can NOT fit into the partition
+func (c *Cache) LeaseGet(key []byte) (LeaseGetResult, error) {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	result, err := cp.partition.leaseGet(hash, key)
+	result.Value = cloneBytes(result.Value)
+	cp.mut.Unlock()
+
+	return result, err
+}
+
+// LeaseSet sets the value of the key with the lease granted by LeaseGet.
+// The value is only stored if the lease is still held by the caller, otherwise ErrLeaseMismatch is returned.
+// The value expires after *ttl*, zero or negative *ttl* means no expiration.
+// Returns ErrNoSpace or ErrValueTooLarge if the value can NOT fit into the partition
+func (c *Cache) LeaseSet(
+	key []byte, leaseID uint64, version uint64, value []byte, ttl time.Duration,
+) (LeaseSetStatus, error) {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	status, err := cp.partition.leaseSet(hash, key, leaseID, version, value, ttl)
+	cp.mut.Unlock()
+
+	return status, err
+}
+
+// Set sets the value of the key without a lease, outstanding leases of the key are revoked.
+// The value expires after *ttl*, zero or negative *ttl* means no expiration.
+// Returns ErrNoSpace or ErrValueTooLarge if the value can NOT fit into the partition
+func (c *Cache) Set(key []byte, value []byte, ttl time.Duration) error {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	err := cp.partition.set(hash, key, value, ttl)
+	cp.mut.Unlock()
+
+	return err
+}
+
+// Get copies the value of the key into *dst*, reusing its capacity, and returns the result.
+// A nil *dst* allocates a new slice if the value is not empty
+func (c *Cache) Get(key []byte, dst []byte) ([]byte, bool) {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	value, ok := cp.partition.getValue(hash, key)
+	if ok {
+		dst = append(dst[:0], value...)
+	}
+	cp.mut.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+	return dst, true
+}
+
+// View calls *fn* with the value of the key without copying, returns false without calling *fn* if not found.
+// The value is stable only for the duration of *fn*, the partition of the key is locked while *fn* is running.
+// *fn* must NOT retain the value or call any method of the cache
+func (c *Cache) View(key []byte, fn func(value []byte)) bool {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	defer cp.mut.Unlock()
+
+	value, ok := cp.partition.getValue(hash, key)
+	if !ok {
+		return false
+	}
+	fn(value)
+	return true
+}
+
+// UpdateFunc receives a copy of the current value of the key, *found* is false if the key has no valid value.
+// Returns the new value with its TTL, or *ok* = false for leaving the key unchanged
+type UpdateFunc func(value []byte, found bool) (newValue []byte, ttl time.Duration, ok bool)
+
+// Update replaces the value of the key with the result of *fn* atomically, outstanding leases are revoked like Set.
+// *fn* is called with the partition of the key locked, it must NOT call any method of the cache.
+// Returns false if *fn* left the key unchanged, and ErrNoSpace or ErrValueTooLarge if the new value can NOT fit
+func (c *Cache) Update(key []byte, fn UpdateFunc) (bool, error) {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	defer cp.mut.Unlock()
+
+	value, found := cp.partition.getValue(hash, key)
+	newValue, ttl, ok := fn(cloneBytes(value), found)
+	if !ok {
+		return false, nil
+	}
+
+	if err := cp.partition.set(hash, key, newValue, ttl); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Delete deletes the key from the cache, returns false if the key not existed
+func (c *Cache) Delete(key []byte) bool {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	ok := cp.partition.remove(hash, key)
+	cp.mut.Unlock()
+
+	return ok
+}
+
+// CondFunc is called with the value of the key, *found* is false if the key only has a lease or a stale value.
+// The value is stable only for the duration of the call, it must NOT be retained
+type CondFunc func(value []byte, found bool) bool
+
+// DeleteIf deletes the key if *fn* returns true, *fn* is called with the partition of the key locked
+// so the check and the deletion are atomic. *fn* must NOT call any method of the cache.
+// Returns false without calling *fn* if the key not existed
+func (c *Cache) DeleteIf(key []byte, fn CondFunc) bool {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	ok := cp.partition.removeIf(hash, key, fn)
+	cp.mut.Unlock()
+
+	return ok
+}
+
+func (c *Cache) releaseLease(key []byte, leaseID uint64) bool {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	ok := cp.partition.releaseLease(hash, key, leaseID)
+	cp.mut.Unlock()
+
+	return ok
+}
+
+// Invalidate marks the value of the key as stale and revokes outstanding leases.
+// The next LeaseGet will be granted a new lease together with the stale value
+func (c *Cache) Invalidate(key []byte) bool {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	ok := cp.partition.invalidate(hash, key)
+	cp.mut.Unlock()
+
+	return ok
+}
+
+// InvalidateIf is Invalidate if *fn* returns true, *fn* is called with the partition of the key locked
+// so the check and the invalidation are atomic. *fn* must NOT call any method of the cache.
+// Returns false without calling *fn* if the key not existed
+func (c *Cache) InvalidateIf(key []byte, fn CondFunc) bool {
+	hash := c.hasher.Hash(key)
+	cp := c.getPartition(hash)
+
+	cp.mut.Lock()
+	ok := cp.partition.invalidateIf(hash, key, fn)
+	cp.mut.Unlock()
+
+	return ok
+}
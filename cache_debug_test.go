@@ -0,0 +1,23 @@
+//go:build espresso_debug
+// +build espresso_debug
+
+package espresso
+
+import (
+	"github.com/QuangTung97/espresso/allocator"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPartition_Get_After_Remove_Poisoned(t *testing.T) {
+	p := newEvictTestPartition()
+	p.set(1100, []byte{1, 2, 3}, []byte{10, 20, 30}, 0)
+
+	value, ok := p.getValue(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{10, 20, 30}, value)
+
+	// the value aliases the allocator memory, reading it after the entry is freed is a use-after-free
+	assert.True(t, p.remove(1100, []byte{1, 2, 3}))
+	assert.Equal(t, []byte{allocator.PoisonByte, allocator.PoisonByte, allocator.PoisonByte}, value)
+}
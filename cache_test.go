@@ -0,0 +1,590 @@
+package espresso
+
+import (
+	"errors"
+	"fmt"
+	"github.com/QuangTung97/espresso/allocator"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestCacheConfig(numPartitions int) CacheConfig {
+	return CacheConfig{
+		NumPartitions: numPartitions,
+		PartitionConfig: PartitionConfig{
+			InitAdmissionLimit: 100 * 64,
+			ProtectedRatio:     NewRational(80, 100),
+			MinProtectedLimit:  50 * 64,
+			NumCounters:        1000,
+			SketchMinCacheSize: 100,
+			AllocatorConfig: allocator.Config{
+				MemLimit:     16 << 12,
+				LRUEntrySize: lruEntrySize,
+				Slabs: []allocator.SlabConfig{
+					{
+						ElemSize:     64,
+						ChunkSizeLog: 12,
+					},
+					{
+						ElemSize:     128,
+						ChunkSizeLog: 12,
+					},
+				},
+			},
+		},
+	}
+}
+
+func TestValidateCacheConfig(t *testing.T) {
+	table := []struct {
+		name     string
+		conf     CacheConfig
+		field    string
+		expected string
+	}{
+		{
+			name:     "empty-num-partitions",
+			field:    "NumPartitions",
+			expected: "NumPartitions must > 0",
+		},
+		{
+			name: "num-partitions-not-power-of-2",
+			conf: CacheConfig{
+				NumPartitions: 3,
+			},
+			field:    "NumPartitions",
+			expected: "NumPartitions must be a power of 2",
+		},
+		{
+			name: "negative-load-ttl",
+			conf: CacheConfig{
+				NumPartitions: 4,
+				LoadTTL:       -1,
+			},
+			field:    "LoadTTL",
+			expected: "LoadTTL must >= 0",
+		},
+		{
+			name: "negative-load-error-ttl",
+			conf: CacheConfig{
+				NumPartitions: 4,
+				LoadErrorTTL:  -1,
+			},
+			field:    "LoadErrorTTL",
+			expected: "LoadErrorTTL must >= 0",
+		},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			err := validateCacheConfig(e.conf)
+			assert.True(t, errors.Is(err, ErrInvalidConfig))
+			assert.Equal(t, e.expected, err.(*ConfigError).Message)
+			assert.Equal(t, e.field, err.(*ConfigError).Field)
+		})
+	}
+}
+
+func TestTryNewCache(t *testing.T) {
+	conf := newTestCacheConfig(4)
+	conf.PartitionConfig.InitAdmissionLimit = 0
+
+	c, err := TryNewCache(conf)
+	assert.Nil(t, c)
+	assert.Equal(t, "invalid config: InitAdmissionLimit must > 0", err.Error())
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+
+	assert.PanicsWithError(t, "invalid config: NumPartitions must be a power of 2", func() {
+		NewCache(newTestCacheConfig(3))
+	})
+}
+
+func TestNewCache(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	assert.Equal(t, 4, len(c.partitions))
+	assert.Equal(t, uint32(62), c.shift)
+
+	c = NewCache(newTestCacheConfig(1))
+	assert.Equal(t, 1, len(c.partitions))
+	assert.Equal(t, uint32(64), c.shift)
+	assert.Same(t, &c.partitions[0], c.getPartition(12345))
+	assert.Equal(t, DefaultHasher, c.hasher)
+}
+
+func TestCache_LeaseGet_LeaseSet(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	value, ok := c.Get(key, nil)
+	assert.False(t, ok)
+	assert.Nil(t, value)
+
+	result, _ := c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, uint64(1), result.LeaseID)
+
+	result, _ = c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseRejected, result.Status)
+
+	value, ok = c.Get(key, nil)
+	assert.False(t, ok)
+	assert.Nil(t, value)
+
+	status, _ := c.LeaseSet(key, 1, 100, []byte("value01"), 0)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
+
+	result, _ = c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusExisted, result.Status)
+	assert.Equal(t, []byte("value01"), result.Value)
+
+	value, ok = c.Get(key, nil)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value01"), value)
+}
+
+func TestCache_Delete(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	assert.False(t, c.Delete(key))
+
+	result, _ := c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	c.LeaseSet(key, result.LeaseID, 100, []byte("value01"), 0)
+
+	assert.True(t, c.Delete(key))
+	assert.False(t, c.Delete(key))
+
+	_, ok := c.Get(key, nil)
+	assert.False(t, ok)
+
+	result, _ = c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+}
+
+func TestCache_Value_Is_Copied(t *testing.T) {
+	c := NewCache(newTestCacheConfig(1))
+	key := []byte("key01")
+
+	result, _ := c.LeaseGet(key)
+	c.LeaseSet(key, result.LeaseID, 100, []byte("value01"), 0)
+
+	value, _ := c.Get(key, nil)
+	value[0] = 'X'
+
+	value, _ = c.Get(key, nil)
+	assert.Equal(t, []byte("value01"), value)
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+
+	const numKeys = 64
+	const numGoroutines = 16
+
+	var wg sync.WaitGroup
+	wg.Add(numGoroutines)
+	for i := 0; i < numGoroutines; i++ {
+		go func() {
+			defer wg.Done()
+
+			for k := 0; k < numKeys; k++ {
+				key := []byte(fmt.Sprintf("key:%03d", k))
+				result, _ := c.LeaseGet(key)
+				if result.Status == LeaseGetStatusLeaseGranted {
+					c.LeaseSet(key, result.LeaseID, 1, []byte(fmt.Sprintf("value:%03d", k)), 0)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for k := 0; k < numKeys; k++ {
+		value, ok := c.Get([]byte(fmt.Sprintf("key:%03d", k)), nil)
+		assert.True(t, ok)
+		assert.Equal(t, []byte(fmt.Sprintf("value:%03d", k)), value)
+	}
+}
+
+type constHasher struct {
+	hash uint64
+}
+
+func (h constHasher) Hash([]byte) uint64 {
+	return h.hash
+}
+
+func TestCache_Custom_Hasher(t *testing.T) {
+	conf := newTestCacheConfig(4)
+	conf.Hasher = constHasher{hash: 3 << 62}
+	c := NewCache(conf)
+
+	result, _ := c.LeaseGet([]byte("key01"))
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+
+	p := c.partitions[3].partition
+	r, ok := p.get(3<<62, []byte("key01"))
+	assert.True(t, ok)
+	assert.Equal(t, []byte("key01"), r.key)
+	assert.Equal(t, uint32(1), p.sketch.Frequency(3<<62))
+
+	assert.Equal(t, 0, len(c.partitions[0].partition.contentMap))
+}
+
+func TestCache_Hash_Collision(t *testing.T) {
+	conf := newTestCacheConfig(4)
+	conf.Hasher = constHasher{hash: 1 << 62}
+	c := NewCache(conf)
+
+	const numKeys = 20
+	for k := 0; k < numKeys; k++ {
+		key := []byte(fmt.Sprintf("key:%03d", k))
+		result, _ := c.LeaseGet(key)
+		assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+		c.LeaseSet(key, result.LeaseID, 1, []byte(fmt.Sprintf("value:%03d", k)), 0)
+	}
+
+	for k := 0; k < numKeys; k++ {
+		value, ok := c.Get([]byte(fmt.Sprintf("key:%03d", k)), nil)
+		assert.True(t, ok)
+		assert.Equal(t, []byte(fmt.Sprintf("value:%03d", k)), value)
+	}
+
+	for k := 0; k < numKeys; k += 2 {
+		assert.True(t, c.Delete([]byte(fmt.Sprintf("key:%03d", k))))
+	}
+
+	for k := 0; k < numKeys; k++ {
+		value, ok := c.Get([]byte(fmt.Sprintf("key:%03d", k)), nil)
+		if k%2 == 0 {
+			assert.False(t, ok)
+		} else {
+			assert.True(t, ok)
+			assert.Equal(t, []byte(fmt.Sprintf("value:%03d", k)), value)
+		}
+	}
+}
+
+func TestCache_LeaseSet_Status(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	status, err := c.LeaseSet(key, 1, 100, []byte("value01"), 0)
+	assert.Equal(t, ErrLeaseMismatch, err)
+	assert.Equal(t, LeaseSetStatusEntryGone, status)
+
+	result, _ := c.LeaseGet(key)
+	status, err = c.LeaseSet(key, result.LeaseID+1, 100, []byte("value01"), 0)
+	assert.Equal(t, ErrLeaseMismatch, err)
+	assert.Equal(t, LeaseSetStatusLeaseMismatch, status)
+
+	status, err = c.LeaseSet(key, result.LeaseID, 100, []byte("value01"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
+
+	status, _ = c.LeaseSet(key, result.LeaseID, 100, []byte("value02"), 0)
+	assert.Equal(t, LeaseSetStatusLeaseMismatch, status)
+
+	c.Delete(key)
+	status, _ = c.LeaseSet(key, result.LeaseID, 100, []byte("value01"), 0)
+	assert.Equal(t, LeaseSetStatusEntryGone, status)
+
+	value, ok := c.Get(key, nil)
+	assert.False(t, ok)
+	assert.Nil(t, value)
+}
+
+func TestCache_DeleteIf(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	called := false
+	assert.False(t, c.DeleteIf(key, func(value []byte, found bool) bool {
+		called = true
+		return true
+	}))
+	assert.False(t, called)
+
+	// the key only has a lease
+	c.LeaseGet(key)
+	assert.True(t, c.DeleteIf(key, func(value []byte, found bool) bool {
+		assert.False(t, found)
+		assert.Nil(t, value)
+		return true
+	}))
+
+	result, _ := c.LeaseGet(key)
+	c.LeaseSet(key, result.LeaseID, 100, []byte("value01"), 0)
+
+	var values [][]byte
+	cond := func(deleted bool) CondFunc {
+		return func(value []byte, found bool) bool {
+			assert.True(t, found)
+			values = append(values, cloneBytes(value))
+			return deleted
+		}
+	}
+	assert.False(t, c.DeleteIf(key, cond(false)))
+	assert.True(t, c.DeleteIf(key, cond(true)))
+	assert.Equal(t, [][]byte{[]byte("value01"), []byte("value01")}, values)
+
+	_, ok := c.Get(key, nil)
+	assert.False(t, ok)
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	assert.False(t, c.Invalidate(key))
+
+	result, _ := c.LeaseGet(key)
+	c.LeaseSet(key, result.LeaseID, 100, []byte("value01"), 0)
+
+	assert.True(t, c.Invalidate(key))
+
+	_, ok := c.Get(key, nil)
+	assert.False(t, ok)
+
+	result, _ = c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, []byte("value01"), result.Value)
+	assert.True(t, result.Stale)
+
+	status, _ := c.LeaseSet(key, result.LeaseID, 101, []byte("value02"), 0)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
+
+	value, ok := c.Get(key, nil)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value02"), value)
+}
+
+func TestCache_InvalidateIf(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	assert.False(t, c.InvalidateIf(key, func(value []byte, found bool) bool {
+		return true
+	}))
+
+	result, _ := c.LeaseGet(key)
+	c.LeaseSet(key, result.LeaseID, 100, []byte("value01"), 0)
+
+	var values [][]byte
+	cond := func(invalidated bool) CondFunc {
+		return func(value []byte, found bool) bool {
+			values = append(values, cloneBytes(value))
+			return invalidated
+		}
+	}
+	assert.False(t, c.InvalidateIf(key, cond(false)))
+
+	value, ok := c.Get(key, nil)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value01"), value)
+
+	assert.True(t, c.InvalidateIf(key, cond(true)))
+	_, ok = c.Get(key, nil)
+	assert.False(t, ok)
+
+	// the stale value is NOT passed to the condition
+	assert.True(t, c.InvalidateIf(key, cond(true)))
+	assert.Equal(t, [][]byte{[]byte("value01"), []byte("value01"), nil}, values)
+
+	result, _ = c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, []byte("value01"), result.Value)
+	assert.True(t, result.Stale)
+}
+
+func TestCache_Full_Evict(t *testing.T) {
+	c := NewCache(newTestCacheConfig(2))
+
+	const numKeys = 10000
+	for k := 0; k < numKeys; k++ {
+		key := []byte(fmt.Sprintf("key:%05d", k))
+		result, err := c.LeaseGet(key)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+
+		status, err := c.LeaseSet(key, result.LeaseID, 1, []byte(fmt.Sprintf("value:%05d", k)), 0)
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LeaseSetStatusAccepted, status)
+
+		value, ok := c.Get(key, nil)
+		assert.True(t, ok)
+		assert.Equal(t, []byte(fmt.Sprintf("value:%05d", k)), value)
+	}
+
+	count := 0
+	for k := 0; k < numKeys; k++ {
+		if _, ok := c.Get([]byte(fmt.Sprintf("key:%05d", k)), nil); ok {
+			count++
+		}
+	}
+	assert.Less(t, count, numKeys)
+	assert.Greater(t, count, 0)
+}
+
+func TestCache_No_Space(t *testing.T) {
+	c := NewCache(newTestCacheConfig(2))
+
+	// larger than the largest buddy block of a partition
+	_, err := c.LeaseGet(make([]byte, math.MaxUint16))
+	assert.Equal(t, ErrValueTooLarge, err)
+
+	key := []byte("key01")
+	result, err := c.LeaseGet(key)
+	assert.Equal(t, nil, err)
+
+	_, err = c.LeaseSet(key, result.LeaseID, 1, make([]byte, 1<<16), 0)
+	assert.Equal(t, ErrValueTooLarge, err)
+
+	_, ok := c.Get(key, nil)
+	assert.False(t, ok)
+}
+
+func TestCache_Get_Dst(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+	assert.Equal(t, nil, c.Set(key, []byte("value01"), 0))
+
+	dst := make([]byte, 3, 32)
+	value, ok := c.Get(key, dst)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value01"), value)
+	// the capacity of dst is reused
+	assert.Equal(t, &dst[:1][0], &value[0])
+
+	value, ok = c.Get([]byte("key02"), dst)
+	assert.False(t, ok)
+	assert.Nil(t, value)
+}
+
+func TestCache_View(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+	assert.Equal(t, nil, c.Set(key, []byte("value01"), 0))
+
+	var viewed []byte
+	ok := c.View(key, func(value []byte) {
+		viewed = append(viewed, value...)
+	})
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value01"), viewed)
+
+	called := false
+	ok = c.View([]byte("key02"), func(value []byte) {
+		called = true
+	})
+	assert.False(t, ok)
+	assert.False(t, called)
+
+	// the lock is released after the callback
+	assert.True(t, c.Delete(key))
+}
+
+func TestCache_Update(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	appendValue := func(value []byte, found bool) ([]byte, time.Duration, bool) {
+		if !found {
+			return []byte("a"), 0, true
+		}
+		return append(value, 'b'), 0, true
+	}
+
+	ok, err := c.Update(key, appendValue)
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+
+	ok, err = c.Update(key, appendValue)
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+
+	value, _ := c.Get(key, nil)
+	assert.Equal(t, []byte("ab"), value)
+
+	ok, err = c.Update(key, func(value []byte, found bool) ([]byte, time.Duration, bool) {
+		assert.True(t, found)
+		assert.Equal(t, []byte("ab"), value)
+		return nil, 0, false
+	})
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+
+	value, _ = c.Get(key, nil)
+	assert.Equal(t, []byte("ab"), value)
+}
+
+func TestCache_Update_Revokes_Lease(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	result, _ := c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+
+	ok, err := c.Update(key, func(value []byte, found bool) ([]byte, time.Duration, bool) {
+		assert.False(t, found)
+		return []byte("value01"), 0, true
+	})
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+
+	_, err = c.LeaseSet(key, result.LeaseID, 1, []byte("value02"), 0)
+	assert.Equal(t, ErrLeaseMismatch, err)
+
+	value, _ := c.Get(key, nil)
+	assert.Equal(t, []byte("value01"), value)
+}
+
+func TestCache_Set(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	result, _ := c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+
+	err := c.Set(key, []byte("value01"), 0)
+	assert.Equal(t, nil, err)
+
+	status, err := c.LeaseSet(key, result.LeaseID, 100, []byte("value02"), 0)
+	assert.Equal(t, ErrLeaseMismatch, err)
+	assert.Equal(t, LeaseSetStatusLeaseMismatch, status)
+
+	value, ok := c.Get(key, nil)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value01"), value)
+
+	assert.Equal(t, ErrValueTooLarge, c.Set(key, make([]byte, 1<<16), 0))
+	_, ok = c.Get(key, nil)
+	assert.False(t, ok)
+}
+
+func TestCache_TTL(t *testing.T) {
+	clock := newFakeClock()
+	conf := newTestCacheConfig(2)
+	conf.PartitionConfig.Clock = clock
+	c := NewCache(conf)
+
+	c.Set([]byte("key01"), []byte("value01"), 2*time.Second)
+
+	result, _ := c.LeaseGet([]byte("key02"))
+	c.LeaseSet([]byte("key02"), result.LeaseID, 1, []byte("value02"), 5*time.Second)
+
+	clock.Advance(2 * time.Second)
+	_, ok := c.Get([]byte("key01"), nil)
+	assert.False(t, ok)
+
+	value, ok := c.Get([]byte("key02"), nil)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value02"), value)
+
+	clock.Advance(3 * time.Second)
+	_, ok = c.Get([]byte("key02"), nil)
+	assert.False(t, ok)
+}
@@ -0,0 +1,526 @@
+// Package client is a client of espresso-server with the same operations as espresso.Cache.
+// It speaks the meta commands of the memcached protocol, requests of concurrent callers are pipelined
+// over a pool of connections. Cluster distributes the keys over many servers with a consistent hash Ring
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/QuangTung97/espresso"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultPoolSize is used when Config.PoolSize is zero
+	DefaultPoolSize = 4
+	// DefaultDialTimeout is used when Config.DialTimeout is zero
+	DefaultDialTimeout = time.Second
+	// DefaultIOTimeout is used when Config.IOTimeout is zero
+	DefaultIOTimeout = 3 * time.Second
+	// DefaultMaxRetries is used when Config.MaxRetries is zero
+	DefaultMaxRetries = 2
+	// DefaultRetryBackoff is used when Config.RetryBackoff is zero
+	DefaultRetryBackoff = 10 * time.Millisecond
+
+	// maxBatchSize is the max number of keys of a request of GetMulti, larger batches are split over the connections
+	maxBatchSize = 100
+)
+
+// Config ...
+type Config struct {
+	// Addr is the TCP address of the espresso-server
+	Addr string
+
+	// PoolSize is the number of connections, the requests are pipelined over them
+	PoolSize int
+
+	// DialTimeout bounds connecting to the server, together with the deadline of the context
+	DialTimeout time.Duration
+	// IOTimeout bounds writing a command and waiting for its reply, the connection is closed on timeout
+	IOTimeout time.Duration
+
+	// MaxRetries is the number of retries of the idempotent reads Get and GetMulti after a connection failure,
+	// negative means no retry
+	MaxRetries int
+	// RetryBackoff is the delay before a retry
+	RetryBackoff time.Duration
+}
+
+func validateConfig(conf Config) error {
+	if conf.Addr == "" {
+		return newConfigError("Addr", "Addr must not be empty")
+	}
+	if conf.PoolSize < 0 {
+		return newConfigError("PoolSize", "PoolSize must >= 0")
+	}
+	if conf.DialTimeout < 0 {
+		return newConfigError("DialTimeout", "DialTimeout must >= 0")
+	}
+	if conf.IOTimeout < 0 {
+		return newConfigError("IOTimeout", "IOTimeout must >= 0")
+	}
+	if conf.RetryBackoff < 0 {
+		return newConfigError("RetryBackoff", "RetryBackoff must >= 0")
+	}
+	return nil
+}
+
+func withDefaults(conf Config) Config {
+	if conf.PoolSize == 0 {
+		conf.PoolSize = DefaultPoolSize
+	}
+	if conf.DialTimeout == 0 {
+		conf.DialTimeout = DefaultDialTimeout
+	}
+	if conf.IOTimeout == 0 {
+		conf.IOTimeout = DefaultIOTimeout
+	}
+	if conf.MaxRetries == 0 {
+		conf.MaxRetries = DefaultMaxRetries
+	}
+	if conf.MaxRetries < 0 {
+		conf.MaxRetries = 0
+	}
+	if conf.RetryBackoff == 0 {
+		conf.RetryBackoff = DefaultRetryBackoff
+	}
+	return conf
+}
+
+type connSlot struct {
+	mut    sync.Mutex
+	conn   *conn
+	closed bool
+}
+
+// Client is safe for concurrent use, the connections are dialed lazily and redialed after failures
+type Client struct {
+	conf   Config
+	dialer net.Dialer
+
+	next  uint64
+	slots []connSlot
+}
+
+// New returns an error matching espresso.ErrInvalidConfig if the config is invalid
+func New(conf Config) (*Client, error) {
+	if err := validateConfig(conf); err != nil {
+		return nil, err
+	}
+	conf = withDefaults(conf)
+
+	return &Client{
+		conf:  conf,
+		slots: make([]connSlot, conf.PoolSize),
+	}, nil
+}
+
+// Close closes the connections, the requests in progress fail and later calls return ErrClosed
+func (c *Client) Close() error {
+	for i := range c.slots {
+		slot := &c.slots[i]
+		slot.mut.Lock()
+		slot.closed = true
+		if slot.conn != nil {
+			slot.conn.fail(ErrClosed)
+			slot.conn = nil
+		}
+		slot.mut.Unlock()
+	}
+	return nil
+}
+
+// getConn picks the connections in round robin, a broken connection is replaced
+func (c *Client) getConn(ctx context.Context) (*conn, error) {
+	slot := &c.slots[atomic.AddUint64(&c.next, 1)%uint64(len(c.slots))]
+
+	slot.mut.Lock()
+	defer slot.mut.Unlock()
+
+	if slot.closed {
+		return nil, ErrClosed
+	}
+	if slot.conn != nil && !slot.conn.broken() {
+		return slot.conn, nil
+	}
+
+	dialCtx, cancel := context.WithTimeout(ctx, c.conf.DialTimeout)
+	defer cancel()
+
+	nc, err := c.dialer.DialContext(dialCtx, "tcp", c.conf.Addr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &connError{err: err}
+	}
+	slot.conn = newConn(nc, c.conf.IOTimeout)
+	return slot.conn, nil
+}
+
+// send writes the command on one of the connections, the reply is waited with wait
+func (c *Client) send(ctx context.Context, cmd []byte, parse func(r *replyReader) error) (*request, error) {
+	cn, err := c.getConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req := newRequest(cmd, parse)
+	if err := cn.send(req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// wait returns the error of the reply, or the error of the context if it is done before the reply.
+// The reply is still read and discarded in that case
+func wait(ctx context.Context, req *request) error {
+	select {
+	case err := <-req.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (c *Client) do(ctx context.Context, cmd []byte, parse func(r *replyReader) error) error {
+	req, err := c.send(ctx, cmd, parse)
+	if err != nil {
+		return err
+	}
+	return wait(ctx, req)
+}
+
+// retry calls *fn* again after the failures of the connections, at most Config.MaxRetries times
+func (c *Client) retry(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+
+		var connErr *connError
+		if !errors.As(err, &connErr) || errors.Is(err, ErrClosed) || attempt >= c.conf.MaxRetries {
+			return err
+		}
+
+		timer := time.NewTimer(c.conf.RetryBackoff)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+// Get returns the value of the key, failures of the connections are retried
+func (c *Client) Get(ctx context.Context, key []byte) ([]byte, bool, error) {
+	if !validKey(key) {
+		return nil, false, ErrInvalidKey
+	}
+	cmd := appendCommand(nil, "mg", key, "v")
+
+	var value []byte
+	found := false
+	err := c.retry(ctx, func() error {
+		value, found = nil, false
+		return c.do(ctx, cmd, func(r *replyReader) error {
+			reply, err := r.readMeta()
+			if err != nil {
+				return err
+			}
+			switch reply.code {
+			case "VA":
+				value = r.readData(reply.size)
+				found = true
+			case "EN":
+			default:
+				r.malformed([]byte(reply.code))
+			}
+			return r.err
+		})
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return value, found, nil
+}
+
+// GetResult is the result of a key of GetMulti
+type GetResult struct {
+	Value []byte
+	Found bool
+}
+
+// GetMulti returns the results of the keys in the same order. The keys are sent in batches of quiet mg commands,
+// the batches are pipelined over the connections. Failures of the connections are retried
+func (c *Client) GetMulti(ctx context.Context, keys [][]byte) ([]GetResult, error) {
+	for _, key := range keys {
+		if !validKey(key) {
+			return nil, ErrInvalidKey
+		}
+	}
+
+	results := make([]GetResult, len(keys))
+	err := c.retry(ctx, func() error {
+		for i := range results {
+			results[i] = GetResult{}
+		}
+
+		// the batches already sent are waited even if a later one fails, their replies write into *results*
+		var requests []*request
+		var firstErr error
+		for start := 0; start < len(keys); start += maxBatchSize {
+			end := start + maxBatchSize
+			if end > len(keys) {
+				end = len(keys)
+			}
+
+			req, err := c.send(ctx, encodeGetBatch(keys[start:end]), parseGetBatch(keys[start:end], results[start:end]))
+			if err != nil {
+				firstErr = err
+				break
+			}
+			requests = append(requests, req)
+		}
+
+		for _, req := range requests {
+			if err := wait(ctx, req); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// encodeGetBatch only replies the hits with their keys, the batch ends with mn
+func encodeGetBatch(keys [][]byte) []byte {
+	var cmd []byte
+	for _, key := range keys {
+		cmd = appendCommand(cmd, "mg", key, "v", "k", "q")
+	}
+	return append(cmd, "mn\r\n"...)
+}
+
+func parseGetBatch(keys [][]byte, results []GetResult) func(r *replyReader) error {
+	return func(r *replyReader) error {
+		indices := make(map[string][]int, len(keys))
+		for i, key := range keys {
+			indices[string(key)] = append(indices[string(key)], i)
+		}
+
+		var firstErr error
+		for {
+			reply, err := r.readMeta()
+			if r.err != nil {
+				return r.err
+			}
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+
+			switch {
+			case reply.code == "MN":
+				return firstErr
+			case reply.code == "VA" && reply.hasFlag('k'):
+				key := reply.flags['k']
+				value := r.readData(reply.size)
+				for _, i := range indices[key] {
+					results[i] = GetResult{Value: value, Found: true}
+				}
+			default:
+				r.malformed([]byte(reply.code))
+				return r.err
+			}
+		}
+	}
+}
+
+// LeaseGet gets the value of the key, or grants a lease to the caller for setting the value with LeaseSet.
+// See espresso.Cache.LeaseGet, it is NOT retried because a lost reply would hold the lease until its timeout
+func (c *Client) LeaseGet(ctx context.Context, key []byte) (espresso.LeaseGetResult, error) {
+	if !validKey(key) {
+		return espresso.LeaseGetResult{}, ErrInvalidKey
+	}
+	cmd := appendCommand(nil, "mg", key, "v", "c", "N"+strconv.Itoa(vivifyTTL))
+
+	var result espresso.LeaseGetResult
+	err := c.do(ctx, cmd, func(r *replyReader) error {
+		reply, err := r.readMeta()
+		if err != nil {
+			return err
+		}
+
+		switch reply.code {
+		case "VA":
+			result.Value = r.readData(reply.size)
+		case "EN":
+		default:
+			r.malformed([]byte(reply.code))
+			return r.err
+		}
+
+		switch {
+		case reply.hasFlag('W'):
+			token, err := strconv.ParseUint(reply.flags['c'], 10, 64)
+			if err != nil || token&leaseTokenBit == 0 {
+				r.malformed([]byte(reply.flags['c']))
+				return r.err
+			}
+			result.Status = espresso.LeaseGetStatusLeaseGranted
+			result.LeaseID = token &^ leaseTokenBit
+		case reply.hasFlag('Z') || reply.code == "EN":
+			result.Status = espresso.LeaseGetStatusLeaseRejected
+		default:
+			result.Status = espresso.LeaseGetStatusExisted
+		}
+		result.Stale = reply.hasFlag('X')
+		return r.err
+	})
+	if err != nil {
+		return espresso.LeaseGetResult{}, err
+	}
+	return result, nil
+}
+
+// LeaseSet sets the value of the key with the lease granted by LeaseGet, see espresso.Cache.LeaseSet.
+// An error matching espresso.ErrLeaseMismatch by errors.Is is returned with LeaseSetStatusLeaseMismatch
+// or LeaseSetStatusEntryGone if the lease is no longer held by the caller.
+// Unlike espresso.Cache.LeaseSet there is no *version*, the meta protocol has no field for it
+// and the server keeps its own CAS values.
+// The TTL is rounded up to seconds, zero or negative *ttl* means no expiration
+func (c *Client) LeaseSet(
+	ctx context.Context, key []byte, leaseID uint64, value []byte, ttl time.Duration,
+) (espresso.LeaseSetStatus, error) {
+	if !validKey(key) {
+		return 0, ErrInvalidKey
+	}
+	flags := append([]string{"C" + strconv.FormatUint(leaseID|leaseTokenBit, 10)}, expireFlag(ttl)...)
+	cmd := appendMetaSet(nil, key, value, flags...)
+
+	var status espresso.LeaseSetStatus
+	err := c.do(ctx, cmd, func(r *replyReader) error {
+		reply, err := r.readMeta()
+		if err != nil {
+			return err
+		}
+
+		switch reply.code {
+		case "HD":
+			status = espresso.LeaseSetStatusAccepted
+		case "EX":
+			status = espresso.LeaseSetStatusLeaseMismatch
+			return fmt.Errorf("%w: server replied %s", espresso.ErrLeaseMismatch, reply.code)
+		case "NF":
+			status = espresso.LeaseSetStatusEntryGone
+			return fmt.Errorf("%w: server replied %s", espresso.ErrLeaseMismatch, reply.code)
+		default:
+			r.malformed([]byte(reply.code))
+		}
+		return r.err
+	})
+	// ErrLeaseMismatch is only returned by the parse function, the status had been set
+	if err != nil && !errors.Is(err, espresso.ErrLeaseMismatch) {
+		return 0, err
+	}
+	return status, err
+}
+
+// Set sets the value of the key without a lease, outstanding leases of the key are revoked.
+// The TTL is rounded up to seconds, zero or negative *ttl* means no expiration
+func (c *Client) Set(ctx context.Context, key []byte, value []byte, ttl time.Duration) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+	cmd := appendMetaSet(nil, key, value, expireFlag(ttl)...)
+
+	return c.do(ctx, cmd, func(r *replyReader) error {
+		reply, err := r.readMeta()
+		if err != nil {
+			return err
+		}
+		if reply.code != "HD" {
+			r.malformed([]byte(reply.code))
+		}
+		return r.err
+	})
+}
+
+// deleteKey sends md with the flags, returns false if the key is NOT found
+func (c *Client) deleteKey(ctx context.Context, key []byte, flags ...string) (bool, error) {
+	if !validKey(key) {
+		return false, ErrInvalidKey
+	}
+	cmd := appendCommand(nil, "md", key, flags...)
+
+	found := false
+	err := c.do(ctx, cmd, func(r *replyReader) error {
+		reply, err := r.readMeta()
+		if err != nil {
+			return err
+		}
+		switch reply.code {
+		case "HD":
+			found = true
+		case "NF":
+		default:
+			r.malformed([]byte(reply.code))
+		}
+		return r.err
+	})
+	if err != nil {
+		return false, err
+	}
+	return found, nil
+}
+
+// Delete returns false if the key is NOT found
+func (c *Client) Delete(ctx context.Context, key []byte) (bool, error) {
+	return c.deleteKey(ctx, key)
+}
+
+// Invalidate marks the value of the key as stale, see espresso.Cache.Invalidate.
+// Returns false if the key is NOT found
+func (c *Client) Invalidate(ctx context.Context, key []byte) (bool, error) {
+	return c.deleteKey(ctx, key, "I")
+}
+
+// Ping checks the connection to the server with the no-op command mn, it is NOT retried
+func (c *Client) Ping(ctx context.Context) error {
+	return c.do(ctx, []byte("mn\r\n"), func(r *replyReader) error {
+		reply, err := r.readMeta()
+		if err != nil {
+			return err
+		}
+		if reply.code != "MN" {
+			r.malformed([]byte(reply.code))
+		}
+		return r.err
+	})
+}
+
+// FlushAll invalidates all items of the server with flush_all, it is NOT retried
+func (c *Client) FlushAll(ctx context.Context) error {
+	return c.do(ctx, []byte("flush_all\r\n"), func(r *replyReader) error {
+		line := r.readLine()
+		if r.err != nil {
+			return r.err
+		}
+		if err := serverError(line); err != nil {
+			return err
+		}
+		if string(line) != "OK" {
+			r.malformed(line)
+		}
+		return r.err
+	})
+}
@@ -0,0 +1,420 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/QuangTung97/espresso"
+	"github.com/QuangTung97/espresso/espressotest"
+	"github.com/QuangTung97/espresso/server"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startServer starts an espresso-server on loopback, returns its address
+func startServer(t *testing.T) string {
+	return startServerWith(t, espressotest.NewCache(espressotest.Config{}), server.Config{MaxItemSize: 64 << 10})
+}
+
+func startServerWith(t *testing.T, cache *espresso.Cache, conf server.Config) string {
+	s := server.New(cache, conf)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		_ = s.Close()
+		<-done
+	})
+	return l.Addr().String()
+}
+
+func newTestClient(t *testing.T, conf Config) *Client {
+	c, err := New(conf)
+	assert.Equal(t, nil, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestNew_Config(t *testing.T) {
+	table := []struct {
+		name  string
+		conf  Config
+		field string
+	}{
+		{name: "empty-addr", conf: Config{}, field: "Addr"},
+		{name: "pool-size", conf: Config{Addr: "localhost:11211", PoolSize: -1}, field: "PoolSize"},
+		{name: "dial-timeout", conf: Config{Addr: "localhost:11211", DialTimeout: -1}, field: "DialTimeout"},
+		{name: "io-timeout", conf: Config{Addr: "localhost:11211", IOTimeout: -1}, field: "IOTimeout"},
+		{name: "retry-backoff", conf: Config{Addr: "localhost:11211", RetryBackoff: -1}, field: "RetryBackoff"},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			_, err := New(e.conf)
+			assert.True(t, errors.Is(err, espresso.ErrInvalidConfig))
+
+			var confErr *espresso.ConfigError
+			assert.True(t, errors.As(err, &confErr))
+			assert.Equal(t, e.field, confErr.Field)
+		})
+	}
+
+	c, err := New(Config{Addr: "localhost:11211", MaxRetries: -1})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, c.conf.MaxRetries)
+	assert.Equal(t, DefaultPoolSize, len(c.slots))
+}
+
+func TestExptime(t *testing.T) {
+	now := time.Date(2021, 6, 10, 8, 0, 0, 0, time.UTC)
+
+	table := []struct {
+		name     string
+		ttl      time.Duration
+		expected int64
+	}{
+		{name: "no-expiration", ttl: 0, expected: 0},
+		{name: "negative", ttl: -time.Second, expected: 0},
+		{name: "rounded-up", ttl: 1500 * time.Millisecond, expected: 2},
+		{name: "seconds", ttl: 10 * time.Second, expected: 10},
+		{name: "max-relative", ttl: maxRelativeExpire * time.Second, expected: maxRelativeExpire},
+		{
+			name:     "unix-timestamp",
+			ttl:      (maxRelativeExpire + 1) * time.Second,
+			expected: now.Unix() + maxRelativeExpire + 1,
+		},
+	}
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			assert.Equal(t, e.expected, exptime(now, e.ttl))
+		})
+	}
+}
+
+func TestClient_Set_Get_Delete(t *testing.T) {
+	c := newTestClient(t, Config{Addr: startServer(t)})
+	ctx := context.Background()
+
+	value, ok, err := c.Get(ctx, []byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+	assert.Nil(t, value)
+
+	assert.Equal(t, nil, c.Set(ctx, []byte("key01"), []byte("value01"), time.Minute))
+
+	value, ok, err = c.Get(ctx, []byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value01"), value)
+
+	assert.Equal(t, nil, c.Set(ctx, []byte("key02"), nil, 0))
+	value, ok, err = c.Get(ctx, []byte("key02"))
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte{}, value)
+
+	deleted, err := c.Delete(ctx, []byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.True(t, deleted)
+
+	deleted, err = c.Delete(ctx, []byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.False(t, deleted)
+
+	_, ok, err = c.Get(ctx, []byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.False(t, ok)
+}
+
+func TestClient_Invalid_Key(t *testing.T) {
+	c := newTestClient(t, Config{Addr: startServer(t)})
+	ctx := context.Background()
+
+	for _, key := range []string{"", "key 01", "key\r\n", string(make([]byte, maxKeySize+1))} {
+		_, _, err := c.Get(ctx, []byte(key))
+		assert.Equal(t, ErrInvalidKey, err)
+	}
+
+	_, err := c.GetMulti(ctx, [][]byte{[]byte("key01"), []byte("key 02")})
+	assert.Equal(t, ErrInvalidKey, err)
+}
+
+func TestClient_Value_Too_Large(t *testing.T) {
+	c := newTestClient(t, Config{Addr: startServer(t)})
+	ctx := context.Background()
+
+	err := c.Set(ctx, []byte("key01"), make([]byte, 100<<10), 0)
+	assert.True(t, errors.Is(err, espresso.ErrValueTooLarge))
+	// the reply of the server is kept in the error
+	assert.Contains(t, err.Error(), "SERVER_ERROR object too large for cache")
+
+	// the connection is still usable
+	assert.Equal(t, nil, c.Set(ctx, []byte("key01"), []byte("value01"), 0))
+}
+
+func TestClient_GetMulti(t *testing.T) {
+	c := newTestClient(t, Config{Addr: startServer(t), PoolSize: 2})
+	ctx := context.Background()
+
+	var keys [][]byte
+	for i := 0; i < 250; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		keys = append(keys, key)
+		if i%2 == 0 {
+			assert.Equal(t, nil, c.Set(ctx, key, []byte(fmt.Sprintf("value%03d", i)), 0))
+		}
+	}
+	// a duplicated key
+	keys = append(keys, []byte("key000"))
+
+	results, err := c.GetMulti(ctx, keys)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, len(keys), len(results))
+	for i := 0; i < 250; i++ {
+		if i%2 == 0 {
+			assert.Equal(t, GetResult{Value: []byte(fmt.Sprintf("value%03d", i)), Found: true}, results[i])
+		} else {
+			assert.Equal(t, GetResult{}, results[i])
+		}
+	}
+	assert.Equal(t, GetResult{Value: []byte("value000"), Found: true}, results[250])
+
+	results, err = c.GetMulti(ctx, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(results))
+}
+
+func TestClient_Lease(t *testing.T) {
+	c := newTestClient(t, Config{Addr: startServer(t)})
+	ctx := context.Background()
+	key := []byte("key01")
+
+	result, err := c.LeaseGet(ctx, key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, espresso.LeaseGetStatusLeaseGranted, result.Status)
+	assert.Nil(t, result.Value)
+	leaseID := result.LeaseID
+
+	result, err = c.LeaseGet(ctx, key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, espresso.LeaseGetResult{Status: espresso.LeaseGetStatusLeaseRejected}, result)
+
+	status, err := c.LeaseSet(ctx, key, leaseID, []byte("value01"), time.Minute)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, espresso.LeaseSetStatusAccepted, status)
+
+	result, err = c.LeaseGet(ctx, key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, espresso.LeaseGetResult{
+		Status: espresso.LeaseGetStatusExisted,
+		Value:  []byte("value01"),
+	}, result)
+
+	// the lease is used
+	status, err = c.LeaseSet(ctx, key, leaseID, []byte("value02"), 0)
+	assert.True(t, errors.Is(err, espresso.ErrLeaseMismatch))
+	assert.Equal(t, espresso.LeaseSetStatusLeaseMismatch, status)
+
+	// the winner after invalidating gets the stale value
+	invalidated, err := c.Invalidate(ctx, key)
+	assert.Equal(t, nil, err)
+	assert.True(t, invalidated)
+
+	result, err = c.LeaseGet(ctx, key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, espresso.LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, []byte("value01"), result.Value)
+	assert.True(t, result.Stale)
+
+	_, err = c.Delete(ctx, key)
+	assert.Equal(t, nil, err)
+
+	status, err = c.LeaseSet(ctx, key, result.LeaseID, []byte("value03"), 0)
+	assert.True(t, errors.Is(err, espresso.ErrLeaseMismatch))
+	assert.Equal(t, espresso.LeaseSetStatusEntryGone, status)
+}
+
+func TestClient_Pipelining(t *testing.T) {
+	c := newTestClient(t, Config{Addr: startServer(t), PoolSize: 1})
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			key := []byte(fmt.Sprintf("key%02d", i))
+			value := []byte(fmt.Sprintf("value%02d", i))
+			for k := 0; k < 20; k++ {
+				assert.Equal(t, nil, c.Set(ctx, key, value, 0))
+
+				result, ok, err := c.Get(ctx, key)
+				assert.Equal(t, nil, err)
+				assert.True(t, ok)
+				assert.Equal(t, value, result)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestClient_Pipelining_Large_Values(t *testing.T) {
+	addr := startServerWith(t,
+		espressotest.NewCache(espressotest.Config{MemLimit: 32 << 20}),
+		server.Config{MaxItemSize: 1 << 20},
+	)
+	c := newTestClient(t, Config{Addr: addr, PoolSize: 1, IOTimeout: 5 * time.Second, MaxRetries: -1})
+	ctx := context.Background()
+
+	value := make([]byte, 512<<10)
+	for i := range value {
+		value[i] = byte(i)
+	}
+	for i := 0; i < 40; i++ {
+		assert.Equal(t, nil, c.Set(ctx, []byte(fmt.Sprintf("get%02d", i)), value, 0))
+	}
+
+	// the large commands and the large replies are in flight at the same time on a single connection
+	var wg sync.WaitGroup
+	for i := 0; i < 40; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			assert.Equal(t, nil, c.Set(ctx, []byte(fmt.Sprintf("set%02d", i)), value, 0))
+		}(i)
+		go func(i int) {
+			defer wg.Done()
+			result, ok, err := c.Get(ctx, []byte(fmt.Sprintf("get%02d", i)))
+			assert.Equal(t, nil, err)
+			assert.True(t, ok)
+			assert.Equal(t, value, result)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// startFakeServer accepts connections and serves them with *handle*
+func startFakeServer(t *testing.T, handle func(i int, conn net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+
+	var wg sync.WaitGroup
+	go func() {
+		for i := 0; ; i++ {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				defer func() { _ = conn.Close() }()
+				handle(i, conn)
+			}(i)
+		}
+	}()
+	t.Cleanup(func() {
+		_ = l.Close()
+		wg.Wait()
+	})
+	return l.Addr().String()
+}
+
+func TestClient_Context_Deadline(t *testing.T) {
+	// the server never replies
+	addr := startFakeServer(t, func(_ int, conn net.Conn) {
+		_, _ = io.Copy(ioutil.Discard, conn)
+	})
+	c := newTestClient(t, Config{Addr: addr})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, _, err := c.Get(ctx, []byte("key01"))
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestClient_IO_Timeout(t *testing.T) {
+	addr := startFakeServer(t, func(_ int, conn net.Conn) {
+		_, _ = io.Copy(ioutil.Discard, conn)
+	})
+	c := newTestClient(t, Config{Addr: addr, IOTimeout: 20 * time.Millisecond, MaxRetries: -1})
+
+	_, err := c.Delete(context.Background(), []byte("key01"))
+	var netErr net.Error
+	assert.True(t, errors.As(err, &netErr))
+	assert.True(t, netErr.Timeout())
+}
+
+func TestClient_Retry_Get(t *testing.T) {
+	// the first connection is closed after reading a command, the others reply a hit
+	addr := startFakeServer(t, func(i int, conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			if i == 0 {
+				return
+			}
+			_, _ = conn.Write([]byte("VA 7\r\nvalue01\r\n"))
+		}
+	})
+	c := newTestClient(t, Config{Addr: addr, PoolSize: 1})
+	ctx := context.Background()
+
+	value, ok, err := c.Get(ctx, []byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value01"), value)
+}
+
+func TestClient_No_Retry_Lease_Get(t *testing.T) {
+	addr := startFakeServer(t, func(_ int, conn net.Conn) {
+		_, _ = bufio.NewReader(conn).ReadString('\n')
+	})
+	c := newTestClient(t, Config{Addr: addr, PoolSize: 1})
+
+	_, err := c.LeaseGet(context.Background(), []byte("key01"))
+	var connErr *connError
+	assert.True(t, errors.As(err, &connErr))
+}
+
+func TestClient_Malformed_Reply(t *testing.T) {
+	addr := startFakeServer(t, func(_ int, conn net.Conn) {
+		r := bufio.NewReader(conn)
+		for {
+			if _, err := r.ReadString('\n'); err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("XYZ\r\n"))
+		}
+	})
+	c := newTestClient(t, Config{Addr: addr, MaxRetries: -1})
+
+	_, err := c.Delete(context.Background(), []byte("key01"))
+	assert.True(t, errors.Is(err, ErrMalformedReply))
+}
+
+func TestClient_Close(t *testing.T) {
+	c := newTestClient(t, Config{Addr: startServer(t)})
+	ctx := context.Background()
+
+	assert.Equal(t, nil, c.Set(ctx, []byte("key01"), []byte("value01"), 0))
+	assert.Equal(t, nil, c.Close())
+
+	_, _, err := c.Get(ctx, []byte("key01"))
+	assert.Equal(t, ErrClosed, err)
+}
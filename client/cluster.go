@@ -0,0 +1,343 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/QuangTung97/espresso"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultHealthCheckInterval is used when ClusterConfig.HealthCheckInterval is zero
+	DefaultHealthCheckInterval = time.Second
+	// DefaultFailureThreshold is used when ClusterConfig.FailureThreshold is zero
+	DefaultFailureThreshold = 3
+)
+
+// ErrNoNodes is returned by Cluster when all nodes are removed from the ring
+var ErrNoNodes = errors.New("espresso/client: no available nodes")
+
+// ClusterConfig ...
+type ClusterConfig struct {
+	// Nodes are the espresso-servers, the addresses must be unique
+	Nodes []Node
+
+	// VirtualNodes is the number of virtual nodes per unit of weight, see NewRing
+	VirtualNodes int
+	// Hasher is used for placing the keys and the virtual nodes on the ring, default to espresso.DefaultHasher
+	Hasher espresso.Hasher
+
+	// Client is the config of the clients of the nodes, Addr is ignored
+	Client Config
+
+	// HealthCheckInterval is the interval of pinging the nodes
+	HealthCheckInterval time.Duration
+	// FailureThreshold is the number of consecutive failed health checks before a node is removed from the ring,
+	// a removed node is flushed and added back after a successful health check, see Cluster
+	FailureThreshold int
+}
+
+func validateClusterConfig(conf ClusterConfig) error {
+	if len(conf.Nodes) == 0 {
+		return newConfigError("Nodes", "Nodes must not be empty")
+	}
+	addrs := map[string]bool{}
+	for _, n := range conf.Nodes {
+		if n.Addr == "" {
+			return newConfigError("Nodes", "Addr of nodes must not be empty")
+		}
+		if addrs[n.Addr] {
+			return newConfigError("Nodes", "Addr of nodes must be unique")
+		}
+		if n.Weight < 0 {
+			return newConfigError("Nodes", "Weight of nodes must >= 0")
+		}
+		addrs[n.Addr] = true
+	}
+	if conf.VirtualNodes < 0 {
+		return newConfigError("VirtualNodes", "VirtualNodes must >= 0")
+	}
+	if conf.HealthCheckInterval < 0 {
+		return newConfigError("HealthCheckInterval", "HealthCheckInterval must >= 0")
+	}
+	if conf.FailureThreshold < 0 {
+		return newConfigError("FailureThreshold", "FailureThreshold must >= 0")
+	}
+	return nil
+}
+
+type clusterNode struct {
+	node   Node
+	client *Client
+
+	// failures is the number of consecutive failed health checks
+	failures int
+	alive    bool
+}
+
+// Cluster distributes the keys over the nodes with a consistent hash Ring of the alive nodes.
+// While a node is removed, its keys are set and deleted on other nodes, so the items it still holds can be stale.
+// A removed node is therefore flushed with flush_all before it is added back, it starts cold instead of
+// serving the stale items. It is safe for concurrent use
+type Cluster struct {
+	conf ClusterConfig
+
+	// mut guards the health of the nodes
+	mut   sync.Mutex
+	nodes []*clusterNode
+	byKey map[string]*clusterNode
+	// ring is the *Ring of the alive nodes
+	ring atomic.Value
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// NewCluster returns an error matching espresso.ErrInvalidConfig if the config is invalid.
+// All nodes are on the ring initially, the health checks run in background until Close is called
+func NewCluster(conf ClusterConfig) (*Cluster, error) {
+	if err := validateClusterConfig(conf); err != nil {
+		return nil, err
+	}
+	if conf.HealthCheckInterval == 0 {
+		conf.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if conf.FailureThreshold == 0 {
+		conf.FailureThreshold = DefaultFailureThreshold
+	}
+
+	c := &Cluster{
+		conf:   conf,
+		byKey:  map[string]*clusterNode{},
+		closed: make(chan struct{}),
+	}
+	for _, n := range conf.Nodes {
+		clientConf := conf.Client
+		clientConf.Addr = n.Addr
+
+		client, err := New(clientConf)
+		if err != nil {
+			return nil, err
+		}
+
+		cn := &clusterNode{node: n, client: client, alive: true}
+		c.nodes = append(c.nodes, cn)
+		c.byKey[n.Addr] = cn
+	}
+	c.rebuildRing()
+
+	c.wg.Add(1)
+	go c.healthLoop()
+	return c, nil
+}
+
+// rebuildRing must be called with *mut* held or before the cluster is shared
+func (c *Cluster) rebuildRing() {
+	var alive []Node
+	for _, cn := range c.nodes {
+		if cn.alive {
+			alive = append(alive, cn.node)
+		}
+	}
+	c.ring.Store(NewRing(alive, c.conf.VirtualNodes, c.conf.Hasher))
+}
+
+// Ring returns the ring of the alive nodes
+func (c *Cluster) Ring() *Ring {
+	return c.ring.Load().(*Ring)
+}
+
+func (c *Cluster) healthLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.conf.HealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.checkHealth()
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// checkHealth pings the nodes concurrently, each ping is bounded by the interval of the health checks.
+// A removed node is only healthy after it had been flushed
+func (c *Cluster) checkHealth() {
+	ctx, cancel := context.WithTimeout(context.Background(), c.conf.HealthCheckInterval)
+	defer cancel()
+
+	// only the health checks change the alive nodes, the health checks do NOT run concurrently
+	c.mut.Lock()
+	removed := make([]bool, len(c.nodes))
+	for i, cn := range c.nodes {
+		removed[i] = !cn.alive
+	}
+	c.mut.Unlock()
+
+	healthy := make([]bool, len(c.nodes))
+	var wg sync.WaitGroup
+	for i, cn := range c.nodes {
+		wg.Add(1)
+		go func(i int, cn *clusterNode) {
+			defer wg.Done()
+			err := cn.client.Ping(ctx)
+			if err == nil && removed[i] {
+				err = cn.client.FlushAll(ctx)
+			}
+			healthy[i] = err == nil
+		}(i, cn)
+	}
+	wg.Wait()
+
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	changed := false
+	for i, cn := range c.nodes {
+		if healthy[i] {
+			cn.failures = 0
+			if !cn.alive {
+				cn.alive = true
+				changed = true
+			}
+			continue
+		}
+
+		cn.failures++
+		if cn.alive && cn.failures >= c.conf.FailureThreshold {
+			cn.alive = false
+			changed = true
+		}
+	}
+	if changed {
+		c.rebuildRing()
+	}
+}
+
+// Close stops the health checks and closes the clients of the nodes, calling it again does nothing
+func (c *Cluster) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.wg.Wait()
+
+		for _, cn := range c.nodes {
+			_ = cn.client.Close()
+		}
+	})
+	return nil
+}
+
+// clientOf returns the client of the node of the key
+func (c *Cluster) clientOf(key []byte) (*Client, error) {
+	node, ok := c.Ring().Get(key)
+	if !ok {
+		return nil, ErrNoNodes
+	}
+	return c.byKey[node.Addr].client, nil
+}
+
+// Get see Client.Get
+func (c *Cluster) Get(ctx context.Context, key []byte) ([]byte, bool, error) {
+	client, err := c.clientOf(key)
+	if err != nil {
+		return nil, false, err
+	}
+	return client.Get(ctx, key)
+}
+
+// GetMulti groups the keys by their nodes and calls Client.GetMulti of the nodes concurrently
+func (c *Cluster) GetMulti(ctx context.Context, keys [][]byte) ([]GetResult, error) {
+	ring := c.Ring()
+
+	groups := map[string][]int{}
+	for i, key := range keys {
+		node, ok := ring.Get(key)
+		if !ok {
+			return nil, ErrNoNodes
+		}
+		groups[node.Addr] = append(groups[node.Addr], i)
+	}
+
+	results := make([]GetResult, len(keys))
+	errs := make(chan error, len(groups))
+	for addr, indices := range groups {
+		go func(client *Client, indices []int) {
+			groupKeys := make([][]byte, 0, len(indices))
+			for _, i := range indices {
+				groupKeys = append(groupKeys, keys[i])
+			}
+
+			groupResults, err := client.GetMulti(ctx, groupKeys)
+			if err == nil {
+				for k, i := range indices {
+					results[i] = groupResults[k]
+				}
+			}
+			errs <- err
+		}(c.byKey[addr].client, indices)
+	}
+
+	var firstErr error
+	for range groups {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	return results, nil
+}
+
+// LeaseGet see Client.LeaseGet
+func (c *Cluster) LeaseGet(ctx context.Context, key []byte) (espresso.LeaseGetResult, error) {
+	client, err := c.clientOf(key)
+	if err != nil {
+		return espresso.LeaseGetResult{}, err
+	}
+	return client.LeaseGet(ctx, key)
+}
+
+// LeaseSet see Client.LeaseSet, the lease is only accepted by the node granting it
+func (c *Cluster) LeaseSet(
+	ctx context.Context, key []byte, leaseID uint64, value []byte, ttl time.Duration,
+) (espresso.LeaseSetStatus, error) {
+	client, err := c.clientOf(key)
+	if err != nil {
+		return 0, err
+	}
+	return client.LeaseSet(ctx, key, leaseID, value, ttl)
+}
+
+// Set see Client.Set
+func (c *Cluster) Set(ctx context.Context, key []byte, value []byte, ttl time.Duration) error {
+	client, err := c.clientOf(key)
+	if err != nil {
+		return err
+	}
+	return client.Set(ctx, key, value, ttl)
+}
+
+// Delete see Client.Delete
+func (c *Cluster) Delete(ctx context.Context, key []byte) (bool, error) {
+	client, err := c.clientOf(key)
+	if err != nil {
+		return false, err
+	}
+	return client.Delete(ctx, key)
+}
+
+// Invalidate see Client.Invalidate
+func (c *Cluster) Invalidate(ctx context.Context, key []byte) (bool, error) {
+	client, err := c.clientOf(key)
+	if err != nil {
+		return false, err
+	}
+	return client.Invalidate(ctx, key)
+}
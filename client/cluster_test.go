@@ -0,0 +1,299 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/QuangTung97/espresso"
+	"github.com/QuangTung97/espresso/espressotest"
+	"github.com/QuangTung97/espresso/server"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// testNode is an espresso-server on loopback that can be stopped and started again on the same address
+type testNode struct {
+	t    *testing.T
+	addr string
+
+	server *server.Server
+	done   chan error
+}
+
+func newTestNode(t *testing.T) *testNode {
+	n := &testNode{t: t, addr: "127.0.0.1:0"}
+	n.start()
+	t.Cleanup(n.stop)
+	return n
+}
+
+func (n *testNode) start() {
+	l, err := net.Listen("tcp", n.addr)
+	assert.Equal(n.t, nil, err)
+	n.addr = l.Addr().String()
+
+	n.server = server.New(espressotest.NewCache(espressotest.Config{}), server.Config{})
+	n.done = make(chan error, 1)
+	go func(s *server.Server, done chan<- error) {
+		done <- s.Serve(l)
+	}(n.server, n.done)
+}
+
+func (n *testNode) stop() {
+	if n.server == nil {
+		return
+	}
+	_ = n.server.Close()
+	<-n.done
+	n.server = nil
+}
+
+func newTestCluster(t *testing.T, nodes []*testNode, threshold int) *Cluster {
+	conf := ClusterConfig{
+		// the health checks are run by the tests
+		HealthCheckInterval: time.Hour,
+		FailureThreshold:    threshold,
+		Client:              Config{MaxRetries: -1},
+	}
+	for _, n := range nodes {
+		conf.Nodes = append(conf.Nodes, Node{Addr: n.addr})
+	}
+
+	c, err := NewCluster(conf)
+	assert.Equal(t, nil, err)
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func ringAddrs(r *Ring) []string {
+	var addrs []string
+	for _, n := range r.Nodes() {
+		addrs = append(addrs, n.Addr)
+	}
+	return addrs
+}
+
+func TestNewCluster_Config(t *testing.T) {
+	table := []struct {
+		name  string
+		conf  ClusterConfig
+		field string
+	}{
+		{name: "no-nodes", conf: ClusterConfig{}, field: "Nodes"},
+		{name: "empty-addr", conf: ClusterConfig{Nodes: []Node{{}}}, field: "Nodes"},
+		{
+			name:  "duplicated-addr",
+			conf:  ClusterConfig{Nodes: []Node{{Addr: "a:1"}, {Addr: "a:1"}}},
+			field: "Nodes",
+		},
+		{name: "weight", conf: ClusterConfig{Nodes: []Node{{Addr: "a:1", Weight: -1}}}, field: "Nodes"},
+		{
+			name:  "virtual-nodes",
+			conf:  ClusterConfig{Nodes: []Node{{Addr: "a:1"}}, VirtualNodes: -1},
+			field: "VirtualNodes",
+		},
+		{
+			name:  "interval",
+			conf:  ClusterConfig{Nodes: []Node{{Addr: "a:1"}}, HealthCheckInterval: -1},
+			field: "HealthCheckInterval",
+		},
+		{
+			name:  "threshold",
+			conf:  ClusterConfig{Nodes: []Node{{Addr: "a:1"}}, FailureThreshold: -1},
+			field: "FailureThreshold",
+		},
+		{
+			name:  "client",
+			conf:  ClusterConfig{Nodes: []Node{{Addr: "a:1"}}, Client: Config{PoolSize: -1}},
+			field: "PoolSize",
+		},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			_, err := NewCluster(e.conf)
+			assert.True(t, errors.Is(err, espresso.ErrInvalidConfig))
+
+			var confErr *espresso.ConfigError
+			assert.True(t, errors.As(err, &confErr))
+			assert.Equal(t, e.field, confErr.Field)
+		})
+	}
+}
+
+func TestCluster_Distributes_Keys(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t), newTestNode(t)}
+	c := newTestCluster(t, nodes, 1)
+	ctx := context.Background()
+
+	var keys [][]byte
+	for i := 0; i < 300; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		keys = append(keys, key)
+		assert.Equal(t, nil, c.Set(ctx, key, []byte(fmt.Sprintf("value%03d", i)), 0))
+	}
+
+	// every key is stored on the node of the ring
+	counts := map[string]int{}
+	for _, key := range keys {
+		node, ok := c.Ring().Get(key)
+		assert.True(t, ok)
+		counts[node.Addr]++
+
+		_, found, err := c.byKey[node.Addr].client.Get(ctx, key)
+		assert.Equal(t, nil, err)
+		assert.True(t, found)
+	}
+	assert.Equal(t, 3, len(counts))
+
+	results, err := c.GetMulti(ctx, append(keys, []byte("key-missing")))
+	assert.Equal(t, nil, err)
+	for i := range keys {
+		assert.Equal(t, GetResult{Value: []byte(fmt.Sprintf("value%03d", i)), Found: true}, results[i])
+	}
+	assert.Equal(t, GetResult{}, results[len(keys)])
+}
+
+func TestCluster_Lease(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t)}
+	c := newTestCluster(t, nodes, 1)
+	ctx := context.Background()
+
+	result, err := c.LeaseGet(ctx, []byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, espresso.LeaseGetStatusLeaseGranted, result.Status)
+
+	status, err := c.LeaseSet(ctx, []byte("key01"), result.LeaseID, []byte("value01"), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, espresso.LeaseSetStatusAccepted, status)
+
+	invalidated, err := c.Invalidate(ctx, []byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.True(t, invalidated)
+
+	result, err = c.LeaseGet(ctx, []byte("key01"))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, espresso.LeaseGetStatusLeaseGranted, result.Status)
+	assert.True(t, result.Stale)
+
+	assert.Equal(t, nil, c.Set(ctx, []byte("key02"), []byte("value02"), 0))
+	deleted, err := c.Delete(ctx, []byte("key02"))
+	assert.Equal(t, nil, err)
+	assert.True(t, deleted)
+}
+
+func TestCluster_Health_Check(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t), newTestNode(t)}
+	c := newTestCluster(t, nodes, 2)
+	ctx := context.Background()
+
+	allAddrs := []string{nodes[0].addr, nodes[1].addr, nodes[2].addr}
+	assert.Equal(t, allAddrs, ringAddrs(c.Ring()))
+
+	nodes[1].stop()
+
+	// the node is removed after 2 failed health checks
+	c.checkHealth()
+	assert.Equal(t, allAddrs, ringAddrs(c.Ring()))
+	c.checkHealth()
+	assert.Equal(t, []string{nodes[0].addr, nodes[2].addr}, ringAddrs(c.Ring()))
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		assert.Equal(t, nil, c.Set(ctx, key, []byte("value"), 0))
+		_, found, err := c.Get(ctx, key)
+		assert.Equal(t, nil, err)
+		assert.True(t, found)
+	}
+
+	// the node is added back after a successful health check
+	nodes[1].start()
+	c.checkHealth()
+	assert.Equal(t, allAddrs, ringAddrs(c.Ring()))
+}
+
+func TestCluster_Health_Check_Flush_Added_Node(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t)}
+	c := newTestCluster(t, nodes, 1)
+	ctx := context.Background()
+
+	var keys [][]byte
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if node, _ := c.Ring().Get(key); node.Addr == nodes[1].addr {
+			keys = append(keys, key)
+		}
+	}
+	assert.NotEqual(t, 0, len(keys))
+
+	nodes[1].stop()
+	c.checkHealth()
+	assert.Equal(t, []string{nodes[0].addr}, ringAddrs(c.Ring()))
+
+	// the node is reachable again and still holds items of the keys now owned by the other node
+	nodes[1].start()
+	for _, key := range keys {
+		assert.Equal(t, nil, c.byKey[nodes[1].addr].client.Set(ctx, key, []byte("stale"), 0))
+	}
+
+	// the items are flushed before the node is added back
+	c.checkHealth()
+	assert.Equal(t, []string{nodes[0].addr, nodes[1].addr}, ringAddrs(c.Ring()))
+
+	for _, key := range keys {
+		_, found, err := c.Get(ctx, key)
+		assert.Equal(t, nil, err)
+		assert.False(t, found)
+	}
+}
+
+func TestCluster_Close_Twice(t *testing.T) {
+	nodes := []*testNode{newTestNode(t)}
+	c := newTestCluster(t, nodes, 1)
+
+	assert.Equal(t, nil, c.Close())
+	assert.Equal(t, nil, c.Close())
+
+	_, _, err := c.Get(context.Background(), []byte("key01"))
+	assert.Equal(t, ErrClosed, err)
+}
+
+func TestCluster_No_Nodes(t *testing.T) {
+	nodes := []*testNode{newTestNode(t)}
+	c := newTestCluster(t, nodes, 1)
+	ctx := context.Background()
+
+	nodes[0].stop()
+	c.checkHealth()
+
+	_, _, err := c.Get(ctx, []byte("key01"))
+	assert.Equal(t, ErrNoNodes, err)
+
+	_, err = c.GetMulti(ctx, [][]byte{[]byte("key01")})
+	assert.Equal(t, ErrNoNodes, err)
+
+	err = c.Set(ctx, []byte("key01"), []byte("value01"), 0)
+	assert.Equal(t, ErrNoNodes, err)
+}
+
+func TestCluster_Health_Loop(t *testing.T) {
+	nodes := []*testNode{newTestNode(t), newTestNode(t)}
+
+	c, err := NewCluster(ClusterConfig{
+		Nodes:               []Node{{Addr: nodes[0].addr}, {Addr: nodes[1].addr}},
+		HealthCheckInterval: 10 * time.Millisecond,
+		FailureThreshold:    1,
+	})
+	assert.Equal(t, nil, err)
+	defer func() { _ = c.Close() }()
+
+	nodes[0].stop()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(c.Ring().Nodes()) != 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, []string{nodes[1].addr}, ringAddrs(c.Ring()))
+}
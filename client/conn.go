@@ -0,0 +1,165 @@
+package client
+
+import (
+	"bufio"
+	"net"
+	"sync"
+	"time"
+)
+
+const connBufferSize = 16 << 10
+
+// request is a command waiting for its reply
+type request struct {
+	cmd []byte
+	// parse reads the reply, errors of the connection are kept in the replyReader
+	parse func(r *replyReader) error
+	done  chan error
+}
+
+func newRequest(cmd []byte, parse func(r *replyReader) error) *request {
+	return &request{
+		cmd:   cmd,
+		parse: parse,
+		done:  make(chan error, 1),
+	}
+}
+
+// conn pipelines the requests of concurrent callers over a single connection.
+// The commands queued while a write is in progress are written together by writeLoop,
+// the replies are read in the order of the commands by readLoop.
+// The socket is never written or read while holding mut
+type conn struct {
+	nc      net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+
+	mut  sync.Mutex
+	cond *sync.Cond
+	// out are the commands queued but NOT yet written
+	out net.Buffers
+	// pending are the requests queued but NOT yet replied, in order
+	pending []*request
+	// err is the failure of the connection, every request fails after that
+	err     error
+	closed  chan struct{}
+	writeCh chan struct{}
+}
+
+func newConn(nc net.Conn, timeout time.Duration) *conn {
+	c := &conn{
+		nc:      nc,
+		r:       bufio.NewReaderSize(nc, connBufferSize),
+		timeout: timeout,
+		closed:  make(chan struct{}),
+		writeCh: make(chan struct{}, 1),
+	}
+	c.cond = sync.NewCond(&c.mut)
+
+	go c.readLoop()
+	go c.writeLoop()
+	return c
+}
+
+// send queues the command of the request, the reply is delivered to req.done
+func (c *conn) send(req *request) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+
+	if c.err != nil {
+		return c.err
+	}
+
+	c.out = append(c.out, req.cmd)
+	c.pending = append(c.pending, req)
+	c.cond.Signal()
+
+	select {
+	case c.writeCh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *conn) writeLoop() {
+	for {
+		select {
+		case <-c.writeCh:
+		case <-c.closed:
+			return
+		}
+
+		c.mut.Lock()
+		out := c.out
+		c.out = nil
+		c.mut.Unlock()
+
+		if len(out) == 0 {
+			continue
+		}
+
+		_ = c.nc.SetWriteDeadline(time.Now().Add(c.timeout))
+		if _, err := out.WriteTo(c.nc); err != nil {
+			c.fail(err)
+			return
+		}
+	}
+}
+
+func (c *conn) readLoop() {
+	for {
+		c.mut.Lock()
+		for len(c.pending) == 0 && c.err == nil {
+			c.cond.Wait()
+		}
+		if c.err != nil {
+			pending := c.pending
+			c.pending = nil
+			err := c.err
+			c.mut.Unlock()
+
+			for _, req := range pending {
+				req.done <- err
+			}
+			return
+		}
+
+		req := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		c.mut.Unlock()
+
+		_ = c.nc.SetReadDeadline(time.Now().Add(c.timeout))
+		r := &replyReader{r: c.r}
+		err := req.parse(r)
+		if r.err != nil {
+			err = c.fail(r.err)
+		}
+		req.done <- err
+	}
+}
+
+// fail closes the connection, returns the failure of the connection
+func (c *conn) fail(err error) error {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.failLocked(err)
+}
+
+func (c *conn) failLocked(err error) error {
+	if c.err != nil {
+		return c.err
+	}
+
+	c.err = &connError{err: err}
+	close(c.closed)
+	_ = c.nc.Close()
+	c.cond.Broadcast()
+	return c.err
+}
+
+func (c *conn) broken() bool {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.err != nil
+}
@@ -0,0 +1,50 @@
+package client
+
+import (
+	"errors"
+	"github.com/QuangTung97/espresso"
+)
+
+var (
+	// ErrClosed is returned after Client.Close is called
+	ErrClosed = errors.New("espresso/client: client closed")
+
+	// ErrInvalidKey is returned for keys that are empty, longer than 250 bytes,
+	// or contain whitespace or control characters
+	ErrInvalidKey = errors.New("espresso/client: invalid key")
+
+	// ErrMalformedReply is matched by errors.Is when the reply of the server can NOT be parsed,
+	// the connection is closed
+	ErrMalformedReply = errors.New("espresso/client: malformed reply")
+)
+
+// ServerError is an error replied by the server that does NOT match an error of espresso,
+// the connection is still usable
+type ServerError struct {
+	// Message is the reply line, e.g. "CLIENT_ERROR bad command line format"
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return "espresso/client: server error: " + e.Message
+}
+
+// connError is the failure of a connection, the connection is closed and the idempotent reads are retried
+type connError struct {
+	err error
+}
+
+func (e *connError) Error() string {
+	return "espresso/client: connection failed: " + e.err.Error()
+}
+
+func (e *connError) Unwrap() error {
+	return e.err
+}
+
+func newConfigError(field string, message string) error {
+	return &espresso.ConfigError{
+		Field:   field,
+		Message: message,
+	}
+}
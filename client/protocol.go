@@ -0,0 +1,215 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/QuangTung97/espresso"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxKeySize is the max length of a key of the memcached protocol
+	maxKeySize = 250
+
+	// maxRelativeExpire is the largest exptime interpreted as seconds from now, larger values are unix timestamps
+	maxRelativeExpire = 60 * 60 * 24 * 30
+
+	// leaseTokenBit is set by the server in the CAS tokens of leases, separating them from the CAS uniques of items
+	leaseTokenBit = 1 << 63
+
+	// vivifyTTL is the token of the flag N, the server uses its own lease timeout instead
+	vivifyTTL = 30
+)
+
+// validKey returns false if the key can NOT be sent in a command line
+func validKey(key []byte) bool {
+	if len(key) == 0 || len(key) > maxKeySize {
+		return false
+	}
+	for _, b := range key {
+		if b <= ' ' || b == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// exptime converts the TTL to the exptime of the memcached protocol, the seconds are rounded up.
+// TTLs longer than 30 days are converted to unix timestamps
+func exptime(now time.Time, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	seconds := int64((ttl + time.Second - 1) / time.Second)
+	if seconds <= maxRelativeExpire {
+		return seconds
+	}
+	return (now.Add(ttl).UnixNano() + int64(time.Second) - 1) / int64(time.Second)
+}
+
+// appendCommand appends a command line of a meta command with the key and the flags
+func appendCommand(buf []byte, cmd string, key []byte, flags ...string) []byte {
+	buf = append(buf, cmd...)
+	buf = append(buf, ' ')
+	buf = append(buf, key...)
+	for _, f := range flags {
+		buf = append(buf, ' ')
+		buf = append(buf, f...)
+	}
+	return append(buf, "\r\n"...)
+}
+
+// appendMetaSet appends ms with the data block
+func appendMetaSet(buf []byte, key []byte, value []byte, flags ...string) []byte {
+	buf = append(buf, "ms "...)
+	buf = append(buf, key...)
+	buf = append(buf, ' ')
+	buf = strconv.AppendInt(buf, int64(len(value)), 10)
+	for _, f := range flags {
+		buf = append(buf, ' ')
+		buf = append(buf, f...)
+	}
+	buf = append(buf, "\r\n"...)
+	buf = append(buf, value...)
+	return append(buf, "\r\n"...)
+}
+
+// expireFlag returns the flag T of the TTL, or nothing if the TTL means no expiration
+func expireFlag(ttl time.Duration) []string {
+	exp := exptime(time.Now(), ttl)
+	if exp == 0 {
+		return nil
+	}
+	return []string{"T" + strconv.FormatInt(exp, 10)}
+}
+
+// serverError returns the error of an error reply, or nil if the line is NOT an error.
+// The errors of espresso are wrapped with the reply line, they are matched by errors.Is
+func serverError(line []byte) error {
+	switch {
+	case bytes.Equal(line, []byte("SERVER_ERROR object too large for cache")):
+		return fmt.Errorf("%w: %s", espresso.ErrValueTooLarge, line)
+	case bytes.HasPrefix(line, []byte("SERVER_ERROR out of memory")):
+		return fmt.Errorf("%w: %s", espresso.ErrNoSpace, line)
+	case bytes.Equal(line, []byte("ERROR")),
+		bytes.HasPrefix(line, []byte("CLIENT_ERROR ")),
+		bytes.HasPrefix(line, []byte("SERVER_ERROR ")):
+		return &ServerError{Message: string(line)}
+	default:
+		return nil
+	}
+}
+
+// metaReply is a reply line of a meta command
+type metaReply struct {
+	// code is the return code: VA, HD, EN, NF, NS, EX or MN
+	code string
+	// size is the size of the data block of VA
+	size int
+	// flags are the tokens of the returned flags
+	flags map[byte]string
+}
+
+func (m metaReply) hasFlag(name byte) bool {
+	_, ok := m.flags[name]
+	return ok
+}
+
+func parseMetaReply(line []byte) (metaReply, bool) {
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 || len(fields[0]) != 2 {
+		return metaReply{}, false
+	}
+
+	reply := metaReply{code: fields[0], flags: map[byte]string{}}
+	fields = fields[1:]
+	if reply.code == "VA" {
+		if len(fields) == 0 {
+			return metaReply{}, false
+		}
+		size, err := strconv.Atoi(fields[0])
+		if err != nil || size < 0 {
+			return metaReply{}, false
+		}
+		reply.size = size
+		fields = fields[1:]
+	}
+
+	for _, f := range fields {
+		reply.flags[f[0]] = f[1:]
+	}
+	return reply, true
+}
+
+// replyReader reads the replies of a connection.
+// The first I/O error or malformed reply is kept in *err*, the connection must be closed after that
+type replyReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (r *replyReader) malformed(line []byte) {
+	if r.err == nil {
+		r.err = fmt.Errorf("%w: %q", ErrMalformedReply, line)
+	}
+}
+
+// readLine returns a line without the trailing \r\n
+func (r *replyReader) readLine() []byte {
+	if r.err != nil {
+		return nil
+	}
+	line, err := r.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		r.malformed(line)
+		return nil
+	}
+	if err != nil {
+		r.err = err
+		return nil
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		r.malformed(line)
+		return nil
+	}
+	return line[:len(line)-2]
+}
+
+// readData reads a data block followed by \r\n
+func (r *replyReader) readData(size int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	data := make([]byte, size+2)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		r.err = err
+		return nil
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		r.malformed(data[size:])
+		return nil
+	}
+	return data[:size]
+}
+
+// readMeta reads the reply line of a meta command, an error reply is returned as the error
+func (r *replyReader) readMeta() (metaReply, error) {
+	line := r.readLine()
+	if r.err != nil {
+		return metaReply{}, r.err
+	}
+	if err := serverError(line); err != nil {
+		return metaReply{}, err
+	}
+
+	reply, ok := parseMetaReply(line)
+	if !ok {
+		r.malformed(line)
+		return metaReply{}, r.err
+	}
+	return reply, nil
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"github.com/QuangTung97/espresso"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes is used when the number of virtual nodes per unit of weight is zero
+const DefaultVirtualNodes = 160
+
+// Node is a cache node of a Ring
+type Node struct {
+	// Addr is the TCP address of the espresso-server, also the identity of the node on the ring
+	Addr string
+	// Weight scales the number of virtual nodes, zero means 1
+	Weight int
+}
+
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+// Ring is a consistent hash ring, it is immutable and safe for concurrent use.
+// The points of a node only depend on its address, removing a node only moves the keys of that node
+type Ring struct {
+	hasher espresso.Hasher
+	nodes  []Node
+	points []ringPoint
+}
+
+// NewRing places *virtualNodes* times Weight points of every node on the ring.
+// Zero *virtualNodes* means DefaultVirtualNodes, nil *hasher* means espresso.DefaultHasher
+func NewRing(nodes []Node, virtualNodes int, hasher espresso.Hasher) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+	if hasher == nil {
+		hasher = espresso.DefaultHasher
+	}
+
+	var points []ringPoint
+	for i, n := range nodes {
+		weight := n.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		for k := 0; k < virtualNodes*weight; k++ {
+			points = append(points, ringPoint{
+				hash: hasher.Hash([]byte(n.Addr + "#" + strconv.Itoa(k))),
+				node: i,
+			})
+		}
+	}
+
+	// ties are broken by the addresses, the ring does NOT depend on the order of the nodes
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return nodes[points[i].node].Addr < nodes[points[j].node].Addr
+	})
+
+	return &Ring{
+		hasher: hasher,
+		nodes:  append([]Node(nil), nodes...),
+		points: points,
+	}
+}
+
+// Nodes returns the nodes of the ring
+func (r *Ring) Nodes() []Node {
+	return append([]Node(nil), r.nodes...)
+}
+
+// Get returns the node of the key: the node of the first point clockwise from the hash of the key.
+// Returns false if the ring is empty
+func (r *Ring) Get(key []byte) (Node, bool) {
+	if len(r.points) == 0 {
+		return Node{}, false
+	}
+
+	hash := r.hasher.Hash(key)
+	i := sort.Search(len(r.points), func(i int) bool {
+		return r.points[i].hash >= hash
+	})
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.nodes[r.points[i].node], true
+}
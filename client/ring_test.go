@@ -0,0 +1,96 @@
+package client
+
+import (
+	"fmt"
+	"github.com/QuangTung97/espresso"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRing_Empty(t *testing.T) {
+	r := NewRing(nil, 0, nil)
+	_, ok := r.Get([]byte("key01"))
+	assert.False(t, ok)
+}
+
+func ringKeys(n int) [][]byte {
+	keys := make([][]byte, 0, n)
+	for i := 0; i < n; i++ {
+		keys = append(keys, []byte(fmt.Sprintf("key:%d", i)))
+	}
+	return keys
+}
+
+func TestRing_Weights(t *testing.T) {
+	r := NewRing([]Node{
+		{Addr: "10.0.0.1:11211"},
+		{Addr: "10.0.0.2:11211", Weight: 1},
+		{Addr: "10.0.0.3:11211", Weight: 2},
+	}, 0, nil)
+
+	counts := map[string]int{}
+	keys := ringKeys(100000)
+	for _, key := range keys {
+		node, ok := r.Get(key)
+		assert.True(t, ok)
+		counts[node.Addr]++
+	}
+
+	table := []struct {
+		addr  string
+		share float64
+	}{
+		{addr: "10.0.0.1:11211", share: 0.25},
+		{addr: "10.0.0.2:11211", share: 0.25},
+		{addr: "10.0.0.3:11211", share: 0.5},
+	}
+	for _, e := range table {
+		share := float64(counts[e.addr]) / float64(len(keys))
+		assert.InDelta(t, e.share, share, 0.05, e.addr)
+	}
+}
+
+func TestRing_Remove_Node_Only_Moves_Its_Keys(t *testing.T) {
+	nodes := []Node{
+		{Addr: "10.0.0.1:11211"},
+		{Addr: "10.0.0.2:11211"},
+		{Addr: "10.0.0.3:11211"},
+		{Addr: "10.0.0.4:11211"},
+	}
+	full := NewRing(nodes, 0, nil)
+	removed := NewRing([]Node{nodes[3], nodes[0], nodes[2]}, 0, nil)
+
+	moved := 0
+	keys := ringKeys(10000)
+	for _, key := range keys {
+		before, _ := full.Get(key)
+		after, _ := removed.Get(key)
+		if before.Addr == nodes[1].Addr {
+			assert.NotEqual(t, nodes[1].Addr, after.Addr)
+			moved++
+			continue
+		}
+		assert.Equal(t, before, after)
+	}
+	assert.InDelta(t, 0.25, float64(moved)/float64(len(keys)), 0.05)
+}
+
+func TestRing_Hasher(t *testing.T) {
+	nodes := []Node{{Addr: "10.0.0.1:11211"}, {Addr: "10.0.0.2:11211"}}
+	r1 := NewRing(nodes, 0, espresso.DefaultHasher)
+	r2 := NewRing(nodes, 0, nil)
+	r3 := NewRing(nodes, 0, espresso.NewXXHasher(123))
+
+	diff := 0
+	for _, key := range ringKeys(1000) {
+		n1, _ := r1.Get(key)
+		n2, _ := r2.Get(key)
+		n3, _ := r3.Get(key)
+		assert.Equal(t, n1, n2)
+		if n1 != n3 {
+			diff++
+		}
+	}
+	assert.True(t, diff > 0)
+	assert.Equal(t, nodes, r1.Nodes())
+}
@@ -0,0 +1,75 @@
+package espresso
+
+import "math"
+
+const (
+	// hillClimberRestartThreshold is the change of hit rate that restarts the step size
+	hillClimberRestartThreshold = 0.05
+	// hillClimberStepPercent is the restarted step size, relative to the total weight of entries
+	hillClimberStepPercent = 0.0625
+	// hillClimberStepDecayRate is the decay of the step size when the hit rate is stable
+	hillClimberStepDecayRate = 0.98
+
+	// hillClimberSampleMultiple is the length of a sample period, relative to the number of entries
+	hillClimberSampleMultiple  = 10
+	hillClimberMinSamplePeriod = 100
+)
+
+// hillClimber adjusts the size of the admission window to maximize the hit rate, similar to Caffeine.
+// The window keeps moving in the same direction while the hit rate improves, and reverses otherwise
+type hillClimber struct {
+	hits   uint64
+	misses uint64
+
+	prevHitRate float64
+	stepSize    float64
+}
+
+func (c *hillClimber) record(hit bool) {
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+func hillClimberSamplePeriod(numEntries uint32) uint64 {
+	period := hillClimberSampleMultiple * uint64(numEntries)
+	if period < hillClimberMinSamplePeriod {
+		return hillClimberMinSamplePeriod
+	}
+	return period
+}
+
+// sampleCompleted returns true if the current sample is long enough for climbing
+func (c *hillClimber) sampleCompleted(numEntries uint32) bool {
+	return c.hits+c.misses >= hillClimberSamplePeriod(numEntries)
+}
+
+// climb returns the change of the admission limit for the completed sample and starts a new sample
+func (c *hillClimber) climb(totalWeight uint32) int64 {
+	if c.stepSize == 0 {
+		// start by shrinking the window, the same as Caffeine
+		c.stepSize = -hillClimberStepPercent * float64(totalWeight)
+	}
+
+	hitRate := float64(c.hits) / float64(c.hits+c.misses)
+	change := hitRate - c.prevHitRate
+
+	amount := c.stepSize
+	if change < 0 {
+		amount = -amount
+	}
+
+	if math.Abs(change) >= hillClimberRestartThreshold {
+		c.stepSize = math.Copysign(hillClimberStepPercent*float64(totalWeight), amount)
+	} else {
+		c.stepSize = hillClimberStepDecayRate * amount
+	}
+
+	c.prevHitRate = hitRate
+	c.hits = 0
+	c.misses = 0
+
+	return int64(math.Round(amount))
+}
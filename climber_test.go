@@ -0,0 +1,55 @@
+package espresso
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHillClimberSamplePeriod(t *testing.T) {
+	assert.Equal(t, uint64(100), hillClimberSamplePeriod(0))
+	assert.Equal(t, uint64(100), hillClimberSamplePeriod(10))
+	assert.Equal(t, uint64(2000), hillClimberSamplePeriod(200))
+}
+
+func recordSample(c *hillClimber, hits int, misses int) {
+	for i := 0; i < hits; i++ {
+		c.record(true)
+	}
+	for i := 0; i < misses; i++ {
+		c.record(false)
+	}
+}
+
+func TestHillClimber_Climb(t *testing.T) {
+	var c hillClimber
+
+	recordSample(&c, 30, 70)
+	assert.True(t, c.sampleCompleted(10))
+	assert.False(t, c.sampleCompleted(11))
+
+	// the first climb shrinks the window
+	assert.Equal(t, int64(-100), c.climb(1600))
+	assert.Equal(t, 0.3, c.prevHitRate)
+	assert.Equal(t, uint64(0), c.hits)
+	assert.Equal(t, uint64(0), c.misses)
+	assert.False(t, c.sampleCompleted(10))
+
+	// hit rate improved a lot, keep shrinking with a restarted step
+	recordSample(&c, 40, 60)
+	assert.Equal(t, int64(-100), c.climb(1600))
+	assert.Equal(t, float64(-100), c.stepSize)
+
+	// hit rate slightly improved, keep shrinking with a decayed step
+	recordSample(&c, 41, 59)
+	assert.Equal(t, int64(-100), c.climb(1600))
+	assert.Equal(t, float64(-98), c.stepSize)
+
+	// hit rate got worse, reverse the direction
+	recordSample(&c, 20, 80)
+	assert.Equal(t, int64(98), c.climb(1600))
+	assert.Equal(t, float64(100), c.stepSize)
+
+	recordSample(&c, 20, 80)
+	assert.Equal(t, int64(100), c.climb(1600))
+	assert.Equal(t, float64(98), c.stepSize)
+}
@@ -0,0 +1,18 @@
+package espresso
+
+import "time"
+
+// Clock is the source of time of the cache, can be replaced in tests
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct {
+}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock is the Clock using time.Now
+var SystemClock Clock = systemClock{}
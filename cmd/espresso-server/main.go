@@ -0,0 +1,148 @@
+// Command espresso-server serves an espresso cache over the memcached ASCII protocol and optionally the Redis protocol
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/QuangTung97/espresso"
+	"github.com/QuangTung97/espresso/allocator"
+	"github.com/QuangTung97/espresso/lru"
+	"github.com/QuangTung97/espresso/metrics"
+	"github.com/QuangTung97/espresso/server"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// lruEntrySize is the size of an LRU node of a partition
+	lruEntrySize = uint32(unsafe.Sizeof(lru.ListHead{}))
+	// minSlabItemSize and maxSlabItemSize bound the slab classes, larger items are allocated from the buddy
+	minSlabItemSize = 64
+	maxSlabItemSize = 16 << 10
+	// avgItemSize is used for sizing the frequency sketch
+	avgItemSize = 256
+)
+
+type options struct {
+	listen        string
+	respListen    string
+	metricsListen string
+	memoryMB      int
+	numPartitions int
+	maxItemSize   int
+	growthFactor  float64
+	slabAutomove  bool
+}
+
+func parseOptions() options {
+	var opts options
+	flag.StringVar(&opts.listen, "listen", ":11211", "TCP address of the memcached protocol")
+	flag.StringVar(&opts.respListen, "resp-listen", "", "TCP address of the Redis protocol, disabled if empty")
+	flag.StringVar(&opts.metricsListen, "metrics-listen", "",
+		"HTTP address serving Prometheus metrics at /metrics, disabled if empty")
+	flag.IntVar(&opts.memoryMB, "memory", 64, "memory limit of the cache in megabytes")
+	flag.IntVar(&opts.numPartitions, "partitions", 16, "number of partitions, must be a power of 2")
+	flag.IntVar(&opts.maxItemSize, "max-item-size", server.DefaultMaxItemSize, "max size of an item in bytes")
+	flag.Float64Var(&opts.growthFactor, "growth-factor", 1.25, "growth factor of the slab classes")
+	flag.BoolVar(&opts.slabAutomove, "slab-automove", true, "move slab chunks between classes by eviction pressure")
+	flag.Parse()
+	return opts
+}
+
+func newCacheConfig(opts options) (espresso.CacheConfig, error) {
+	if opts.numPartitions <= 0 {
+		return espresso.CacheConfig{}, errors.New("partitions must > 0")
+	}
+	if opts.memoryMB <= 0 {
+		return espresso.CacheConfig{}, errors.New("memory must > 0")
+	}
+	// computed in uint64 to not overflow int on 32-bit platforms
+	partitionMemory := uint64(opts.memoryMB) << 20 / uint64(opts.numPartitions)
+	if partitionMemory >= 1<<32 {
+		return espresso.CacheConfig{}, errors.New("memory of a partition must < 4GB, increase partitions")
+	}
+	memLimit := int(partitionMemory)
+	if memLimit < 0 || uint64(memLimit) != partitionMemory {
+		return espresso.CacheConfig{}, errors.New("memory of a partition is too large for this platform, increase partitions")
+	}
+
+	allocatorConf, err := allocator.GenerateConfig(allocator.SlabClassConfig{
+		MemLimit:     memLimit,
+		LRUEntrySize: lruEntrySize,
+		MinItemSize:  minSlabItemSize,
+		MaxItemSize:  maxSlabItemSize,
+		GrowthFactor: opts.growthFactor,
+	})
+	if err != nil {
+		return espresso.CacheConfig{}, err
+	}
+
+	numItems := uint64(memLimit / avgItemSize)
+	return espresso.CacheConfig{
+		NumPartitions: opts.numPartitions,
+		PartitionConfig: espresso.PartitionConfig{
+			InitAdmissionLimit: uint32(memLimit / 100),
+			ProtectedRatio:     espresso.NewRational(80, 100),
+			MinProtectedLimit:  uint32(memLimit / 10),
+			NumCounters:        numItems,
+			SketchMinCacheSize: numItems,
+			AllocatorConfig:    allocatorConf,
+			SlabAutomove:       opts.slabAutomove,
+		},
+	}, nil
+}
+
+func main() {
+	opts := parseOptions()
+
+	conf, err := newCacheConfig(opts)
+	if err != nil {
+		log.Fatalf("invalid options: %v", err)
+	}
+	cache, err := espresso.TryNewCache(conf)
+	if err != nil {
+		log.Fatalf("invalid options: %v", err)
+	}
+
+	s := server.New(cache, server.Config{MaxItemSize: opts.maxItemSize})
+
+	if opts.metricsListen != "" {
+		registry := metrics.NewRegistry()
+		if err := registry.Register("default", cache); err != nil {
+			log.Fatal(err)
+		}
+
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", registry.Handler())
+		go func() {
+			log.Fatal(http.ListenAndServe(opts.metricsListen, mux))
+		}()
+	}
+
+	if opts.respListen != "" {
+		go func() {
+			log.Printf("espresso-server serving the Redis protocol on %s", opts.respListen)
+			if err := s.ListenAndServeRESP(opts.respListen); err != server.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		fmt.Fprintf(os.Stderr, "received %v, shutting down\n", sig)
+		_ = s.Close()
+	}()
+
+	log.Printf("espresso-server %s listening on %s", server.Version, opts.listen)
+	if err := s.ListenAndServe(opts.listen); err != server.ErrServerClosed {
+		log.Fatal(err)
+	}
+}
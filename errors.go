@@ -0,0 +1,30 @@
+package espresso
+
+import (
+	"errors"
+	"github.com/QuangTung97/espresso/allocator"
+)
+
+var (
+	// ErrInvalidConfig is matched by errors.Is for all config errors, including the ones of allocator
+	ErrInvalidConfig = allocator.ErrInvalidConfig
+
+	// ErrNoSpace is returned when the entry can NOT fit into the partition even after evicting all other entries
+	ErrNoSpace = errors.New("espresso: not enough space")
+
+	// ErrValueTooLarge is returned when the entry is larger than the largest slab
+	ErrValueTooLarge = errors.New("espresso: value too large")
+
+	// ErrLeaseMismatch is returned when the lease is no longer held by the caller
+	ErrLeaseMismatch = errors.New("espresso: lease mismatch")
+)
+
+// ConfigError describes the invalid field of a config
+type ConfigError = allocator.ConfigError
+
+func newConfigError(field string, message string) error {
+	return &ConfigError{
+		Field:   field,
+		Message: message,
+	}
+}
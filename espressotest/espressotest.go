@@ -0,0 +1,58 @@
+// Package espressotest provides caches for the tests of the packages built on espresso
+package espressotest
+
+import (
+	"github.com/QuangTung97/espresso"
+	"github.com/QuangTung97/espresso/allocator"
+	"github.com/QuangTung97/espresso/lru"
+	"unsafe"
+)
+
+const (
+	defaultNumPartitions = 4
+	defaultMemLimit      = 1 << 20
+)
+
+// Config ...
+type Config struct {
+	// NumPartitions is the number of partitions, default to 4
+	NumPartitions int
+	// MemLimit is the memory limit of a partition, default to 1MB
+	MemLimit int
+	// Clock default to espresso.SystemClock
+	Clock espresso.Clock
+}
+
+// NewCache returns a small cache, the slab classes are from 64 bytes to 16KB
+func NewCache(conf Config) *espresso.Cache {
+	if conf.NumPartitions == 0 {
+		conf.NumPartitions = defaultNumPartitions
+	}
+	if conf.MemLimit == 0 {
+		conf.MemLimit = defaultMemLimit
+	}
+
+	allocatorConf, err := allocator.GenerateConfig(allocator.SlabClassConfig{
+		MemLimit:     conf.MemLimit,
+		LRUEntrySize: uint32(unsafe.Sizeof(lru.ListHead{})),
+		MinItemSize:  64,
+		MaxItemSize:  16 << 10,
+		GrowthFactor: 2,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return espresso.NewCache(espresso.CacheConfig{
+		NumPartitions: conf.NumPartitions,
+		PartitionConfig: espresso.PartitionConfig{
+			InitAdmissionLimit: 16 << 10,
+			ProtectedRatio:     espresso.NewRational(80, 100),
+			MinProtectedLimit:  64 << 10,
+			NumCounters:        1000,
+			SketchMinCacheSize: 100,
+			AllocatorConfig:    allocatorConf,
+			Clock:              conf.Clock,
+		},
+	})
+}
@@ -0,0 +1,25 @@
+package espressotest
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewCache(t *testing.T) {
+	c := NewCache(Config{})
+	assert.Equal(t, nil, c.Set([]byte("key01"), []byte("value01"), 0))
+
+	value, ok := c.Get([]byte("key01"), nil)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value01"), value)
+
+	stats := c.Stats()
+	assert.Equal(t, 4, len(stats.Partitions))
+	assert.Equal(t, uint64(1<<20), stats.Partitions[0].Allocator.TotalBytes)
+	assert.Equal(t, uint32(64), stats.Partitions[0].Allocator.Slabs[0].ElemSize)
+
+	c = NewCache(Config{NumPartitions: 2, MemLimit: 1 << 18})
+	stats = c.Stats()
+	assert.Equal(t, 2, len(stats.Partitions))
+	assert.Equal(t, uint64(1<<18), stats.Partitions[0].Allocator.TotalBytes)
+}
@@ -2,4 +2,7 @@ module github.com/QuangTung97/espresso
 
 go 1.14
 
-require github.com/stretchr/testify v1.7.0
+require (
+	github.com/bradfitz/gomemcache v0.0.0-20190913173617-a41fca850d0b
+	github.com/stretchr/testify v1.7.0
+)
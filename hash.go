@@ -0,0 +1,105 @@
+package espresso
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Hasher computes the 64-bit hash of a key.
+// The same hash is used for choosing the partition, the content map and the frequency sketch.
+type Hasher interface {
+	Hash(key []byte) uint64
+}
+
+const (
+	xxPrime1 uint64 = 11400714785074694791
+	xxPrime2 uint64 = 14029467366897019727
+	xxPrime3 uint64 = 1609587929392839161
+	xxPrime4 uint64 = 9650029242287828579
+	xxPrime5 uint64 = 2870177450012600261
+)
+
+// XXHasher is the seeded xxHash64 algorithm.
+// Using a secret random seed makes it harder for attackers to create many keys with the same hash
+type XXHasher struct {
+	seed uint64
+}
+
+var _ Hasher = XXHasher{}
+
+// NewXXHasher ...
+func NewXXHasher(seed uint64) XXHasher {
+	return XXHasher{seed: seed}
+}
+
+// DefaultHasher is the xxHash64 with seed = 0
+var DefaultHasher Hasher = NewXXHasher(0)
+
+func xxRound(acc, input uint64) uint64 {
+	acc += input * xxPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	acc *= xxPrime1
+	return acc
+}
+
+func xxMergeRound(acc, val uint64) uint64 {
+	val = xxRound(0, val)
+	acc ^= val
+	acc = acc*xxPrime1 + xxPrime4
+	return acc
+}
+
+// Hash ...
+func (h XXHasher) Hash(key []byte) uint64 {
+	n := len(key)
+	var result uint64
+
+	if n >= 32 {
+		v1 := h.seed + xxPrime1 + xxPrime2
+		v2 := h.seed + xxPrime2
+		v3 := h.seed
+		v4 := h.seed - xxPrime1
+
+		for len(key) >= 32 {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(key[0:8]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(key[8:16]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(key[16:24]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(key[24:32]))
+			key = key[32:]
+		}
+
+		result = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		result = xxMergeRound(result, v1)
+		result = xxMergeRound(result, v2)
+		result = xxMergeRound(result, v3)
+		result = xxMergeRound(result, v4)
+	} else {
+		result = h.seed + xxPrime5
+	}
+
+	result += uint64(n)
+
+	for ; len(key) >= 8; key = key[8:] {
+		k1 := xxRound(0, binary.LittleEndian.Uint64(key[:8]))
+		result ^= k1
+		result = bits.RotateLeft64(result, 27)*xxPrime1 + xxPrime4
+	}
+	if len(key) >= 4 {
+		result ^= uint64(binary.LittleEndian.Uint32(key[:4])) * xxPrime1
+		result = bits.RotateLeft64(result, 23)*xxPrime2 + xxPrime3
+		key = key[4:]
+	}
+	for _, b := range key {
+		result ^= uint64(b) * xxPrime5
+		result = bits.RotateLeft64(result, 11) * xxPrime1
+	}
+
+	result ^= result >> 33
+	result *= xxPrime2
+	result ^= result >> 29
+	result *= xxPrime3
+	result ^= result >> 32
+
+	return result
+}
@@ -0,0 +1,74 @@
+package espresso
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestXXHasher_Hash(t *testing.T) {
+	table := []struct {
+		name     string
+		key      string
+		expected uint64
+	}{
+		{
+			name:     "empty",
+			key:      "",
+			expected: 0xef46db3751d8e999,
+		},
+		{
+			name:     "single-byte",
+			key:      "a",
+			expected: 0xd24ec4f1a98c6e5b,
+		},
+		{
+			name:     "less-than-4-bytes",
+			key:      "abc",
+			expected: 0x44bc2cf5ad770999,
+		},
+		{
+			name:     "less-than-8-bytes",
+			key:      "key01",
+			expected: 0x8d82a9c3d9fe37ea,
+		},
+		{
+			name:     "exactly-32-bytes",
+			key:      "0123456789abcdef0123456789abcdef",
+			expected: 0x642a94958e71e6c5,
+		},
+		{
+			name:     "long",
+			key:      "Call me Ishmael. Some years ago--never mind how long precisely-",
+			expected: 0x02a2e85470d6fd96,
+		},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			assert.Equal(t, e.expected, NewXXHasher(0).Hash([]byte(e.key)))
+			assert.Equal(t, e.expected, DefaultHasher.Hash([]byte(e.key)))
+		})
+	}
+}
+
+func TestXXHasher_Seed(t *testing.T) {
+	key := []byte("some-key")
+	assert.NotEqual(t, NewXXHasher(0).Hash(key), NewXXHasher(1).Hash(key))
+	assert.Equal(t, NewXXHasher(123).Hash(key), NewXXHasher(123).Hash(key))
+}
+
+func TestXXHasher_Distribution(t *testing.T) {
+	const numKeys = 1 << 14
+	const numBuckets = 16
+
+	var buckets [numBuckets]int
+	for i := 0; i < numKeys; i++ {
+		hash := DefaultHasher.Hash([]byte(fmt.Sprintf("key:%d", i)))
+		buckets[hash>>60]++
+	}
+
+	for _, count := range buckets {
+		assert.InDelta(t, numKeys/numBuckets, count, numKeys/numBuckets/10)
+	}
+}
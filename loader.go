@@ -0,0 +1,197 @@
+package espresso
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LoaderFunc loads the value of a key that is not in the cache
+type LoaderFunc func(ctx context.Context) ([]byte, error)
+
+const (
+	// loadRetryMinDelay and loadRetryMaxDelay bound the backoff of waiting for a lease held by another caller
+	loadRetryMinDelay = time.Millisecond
+	loadRetryMaxDelay = 100 * time.Millisecond
+)
+
+// loadCall is a GetOrLoad in progress, shared by the concurrent callers of the same key
+type loadCall struct {
+	done     chan struct{}
+	finished bool
+
+	value []byte
+	err   error
+	// completed is false if the leader gave up without a result (its context is done or the loader panicked),
+	// the waiting callers retry in that case
+	completed bool
+	// errExpire is the time until which the cached err is returned without calling the loader again
+	errExpire time.Time
+}
+
+// loadGroup coalesces the concurrent GetOrLoad calls of the same key, the zero value is ready to use
+type loadGroup struct {
+	mut   sync.Mutex
+	calls map[string]*loadCall
+	// cached are the calls kept for their errors, in the order of errExpire because the TTL is the same for all
+	cached []cachedCall
+}
+
+type cachedCall struct {
+	key  string
+	call *loadCall
+}
+
+// join returns the call of the key, and true if the caller must lead the call
+func (g *loadGroup) join(key string, now time.Time) (*loadCall, bool) {
+	g.mut.Lock()
+	defer g.mut.Unlock()
+
+	if g.calls == nil {
+		g.calls = map[string]*loadCall{}
+	}
+	g.removeExpired(now)
+
+	call, ok := g.calls[key]
+	if ok && (!call.finished || now.Before(call.errExpire)) {
+		return call, false
+	}
+
+	call = &loadCall{done: make(chan struct{})}
+	g.calls[key] = call
+	return call, true
+}
+
+// finish publishes the result of the call, the call is kept until *errExpire* if the error is cached
+func (g *loadGroup) finish(key string, call *loadCall, errExpire time.Time) {
+	g.mut.Lock()
+	call.finished = true
+	call.errExpire = errExpire
+	if errExpire.IsZero() {
+		g.removeLocked(key, call)
+	} else {
+		g.cached = append(g.cached, cachedCall{key: key, call: call})
+	}
+	g.mut.Unlock()
+
+	close(call.done)
+}
+
+// removeExpired removes the calls whose cached errors expired at *now*
+func (g *loadGroup) removeExpired(now time.Time) {
+	n := 0
+	for n < len(g.cached) && !now.Before(g.cached[n].call.errExpire) {
+		g.removeLocked(g.cached[n].key, g.cached[n].call)
+		g.cached[n] = cachedCall{}
+		n++
+	}
+	g.cached = g.cached[n:]
+}
+
+func (g *loadGroup) removeLocked(key string, call *loadCall) {
+	if g.calls[key] == call {
+		delete(g.calls, key)
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// GetOrLoad returns the value of the key, calling *loader* to load the value if the key is not in the cache.
+// Concurrent calls of the same key are coalesced, only the caller holding the lease calls the loader
+// and the others wait for its result. Callers rejected by a lease held outside of GetOrLoad wait for the value to be set.
+// The loaded value is set with the TTL CacheConfig.LoadTTL, and is returned even if it can NOT be stored.
+// Errors of the loader are returned to all waiting callers, and are NOT cached unless CacheConfig.LoadErrorTTL is set.
+// Returns the error of *ctx* if it is done before the value is available
+func (c *Cache) GetOrLoad(ctx context.Context, key []byte, loader LoaderFunc) ([]byte, error) {
+	for {
+		call, leader := c.loads.join(string(key), c.clock.Now())
+		if leader {
+			return c.leadLoad(ctx, key, loader, call)
+		}
+
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		if call.completed {
+			return cloneBytes(call.value), call.err
+		}
+	}
+}
+
+func (c *Cache) leadLoad(ctx context.Context, key []byte, loader LoaderFunc, call *loadCall) (value []byte, err error) {
+	defer func() {
+		// the caller of the leader owns *value*, the waiting callers get copies of a private one
+		call.value = cloneBytes(value)
+		call.err = err
+
+		var errExpire time.Time
+		if call.completed && err != nil && c.loadErrorTTL > 0 {
+			errExpire = c.clock.Now().Add(c.loadErrorTTL)
+		}
+		c.loads.finish(string(key), call, errExpire)
+	}()
+
+	delay := loadRetryMinDelay
+	for {
+		result, err := c.LeaseGet(key)
+		if err != nil {
+			call.completed = true
+			return nil, err
+		}
+
+		switch result.Status {
+		case LeaseGetStatusExisted:
+			call.completed = true
+			return result.Value, nil
+
+		case LeaseGetStatusLeaseGranted:
+			value, err := c.loadWithLease(ctx, key, result.LeaseID, loader)
+			// the waiting callers retry if the loader failed because the context of the leader is done
+			call.completed = err == nil || ctx.Err() == nil
+			return value, err
+
+		default:
+			// the lease is held by a caller outside of GetOrLoad
+			if err := sleepContext(ctx, delay); err != nil {
+				return nil, err
+			}
+			delay *= 2
+			if delay > loadRetryMaxDelay {
+				delay = loadRetryMaxDelay
+			}
+		}
+	}
+}
+
+// loadWithLease calls the loader and sets the value, the lease is released if the value is NOT loaded
+func (c *Cache) loadWithLease(ctx context.Context, key []byte, leaseID uint64, loader LoaderFunc) ([]byte, error) {
+	loaded := false
+	defer func() {
+		if !loaded {
+			c.releaseLease(key, leaseID)
+		}
+	}()
+
+	value, err := loader(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loaded = true
+
+	// the value is still returned if the lease is revoked or there is not enough space
+	_, _ = c.LeaseSet(key, leaseID, 0, value, c.loadTTL)
+	return value, nil
+}
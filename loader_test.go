@@ -0,0 +1,320 @@
+package espresso
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type countingLoader struct {
+	calls int32
+	value []byte
+	err   error
+}
+
+func (l *countingLoader) load(context.Context) ([]byte, error) {
+	atomic.AddInt32(&l.calls, 1)
+	return l.value, l.err
+}
+
+func (l *countingLoader) numCalls() int {
+	return int(atomic.LoadInt32(&l.calls))
+}
+
+func TestCache_GetOrLoad(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+	loader := &countingLoader{value: []byte("value01")}
+
+	value, err := c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("value01"), value)
+	assert.Equal(t, 1, loader.numCalls())
+
+	value, err = c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("value01"), value)
+	assert.Equal(t, 1, loader.numCalls())
+
+	value, ok := c.Get(key, nil)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("value01"), value)
+	assert.Equal(t, 0, len(c.loads.calls))
+}
+
+func TestCache_GetOrLoad_Coalescing(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var calls int32
+	loader := func(ctx context.Context) ([]byte, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return []byte("value01"), nil
+	}
+
+	const numCallers = 10
+	results := make([][]byte, numCallers)
+	var wg sync.WaitGroup
+	wg.Add(numCallers)
+	for i := 0; i < numCallers; i++ {
+		go func(i int) {
+			defer wg.Done()
+			value, err := c.GetOrLoad(context.Background(), key, loader)
+			assert.Equal(t, nil, err)
+			results[i] = value
+		}(i)
+	}
+
+	<-started
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for _, value := range results {
+		assert.Equal(t, []byte("value01"), value)
+	}
+}
+
+func TestCache_GetOrLoad_Leader_Owns_Value(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context) ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("value01"), nil
+	}
+
+	leaderDone := make(chan struct{})
+	go func() {
+		defer close(leaderDone)
+		value, err := c.GetOrLoad(context.Background(), key, loader)
+		assert.Equal(t, nil, err)
+		// the leader modifies its value while the waiting caller is copying the result
+		copy(value, "XXXXXXX")
+	}()
+	<-started
+
+	waiterDone := make(chan []byte)
+	go func() {
+		value, _ := c.GetOrLoad(context.Background(), key, loader)
+		waiterDone <- value
+	}()
+
+	// gives the caller time to join the call
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	assert.Equal(t, []byte("value01"), <-waiterDone)
+	<-leaderDone
+}
+
+func TestCache_GetOrLoad_Error_Not_Cached(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+	loadErr := errors.New("load error")
+	loader := &countingLoader{err: loadErr}
+
+	value, err := c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, loadErr, err)
+	assert.Nil(t, value)
+
+	value, err = c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, loadErr, err)
+	assert.Nil(t, value)
+	assert.Equal(t, 2, loader.numCalls())
+
+	// the lease is released
+	result, err := c.LeaseGet(key)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+}
+
+func TestCache_GetOrLoad_Error_Cached(t *testing.T) {
+	clock := newFakeClock()
+	conf := newTestCacheConfig(4)
+	conf.PartitionConfig.Clock = clock
+	conf.LoadErrorTTL = 5 * time.Second
+	c := NewCache(conf)
+
+	key := []byte("key01")
+	loadErr := errors.New("load error")
+	loader := &countingLoader{err: loadErr}
+
+	_, err := c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, loadErr, err)
+
+	clock.Advance(4 * time.Second)
+	_, err = c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, loadErr, err)
+	assert.Equal(t, 1, loader.numCalls())
+
+	clock.Advance(time.Second)
+	loader.err = nil
+	loader.value = []byte("value01")
+	value, err := c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("value01"), value)
+	assert.Equal(t, 2, loader.numCalls())
+	assert.Equal(t, 0, len(c.loads.calls))
+}
+
+func TestCache_GetOrLoad_Error_Cache_Expired_By_Clock(t *testing.T) {
+	clock := newFakeClock()
+	conf := newTestCacheConfig(4)
+	conf.PartitionConfig.Clock = clock
+	conf.LoadErrorTTL = 5 * time.Second
+	c := NewCache(conf)
+
+	loadErr := errors.New("load error")
+	loader := &countingLoader{err: loadErr}
+
+	_, err := c.GetOrLoad(context.Background(), []byte("key01"), loader.load)
+	assert.Equal(t, loadErr, err)
+	clock.Advance(2 * time.Second)
+	_, err = c.GetOrLoad(context.Background(), []byte("key02"), loader.load)
+	assert.Equal(t, loadErr, err)
+	assert.Equal(t, 2, len(c.loads.calls))
+
+	// the cached errors are removed by the clock of the cache, NOT by the real time
+	clock.Advance(3 * time.Second)
+	_, err = c.GetOrLoad(context.Background(), []byte("key03"), loader.load)
+	assert.Equal(t, loadErr, err)
+	assert.Equal(t, 2, len(c.loads.calls))
+	assert.Contains(t, c.loads.calls, "key02")
+	assert.Contains(t, c.loads.calls, "key03")
+
+	clock.Advance(5 * time.Second)
+	_, err = c.GetOrLoad(context.Background(), []byte("key01"), loader.load)
+	assert.Equal(t, loadErr, err)
+	assert.Equal(t, 1, len(c.loads.calls))
+	assert.Equal(t, 4, loader.numCalls())
+}
+
+func TestCache_GetOrLoad_Wait_For_Lease_Holder(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	// the lease is held outside of GetOrLoad
+	result, _ := c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+
+	loader := &countingLoader{value: []byte("value02")}
+	done := make(chan []byte)
+	go func() {
+		value, err := c.GetOrLoad(context.Background(), key, loader.load)
+		assert.Equal(t, nil, err)
+		done <- value
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	_, err := c.LeaseSet(key, result.LeaseID, 1, []byte("value01"), 0)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, []byte("value01"), <-done)
+	assert.Equal(t, 0, loader.numCalls())
+}
+
+func TestCache_GetOrLoad_Context_Done(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	c.LeaseGet(key)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	loader := &countingLoader{value: []byte("value01")}
+	value, err := c.GetOrLoad(ctx, key, loader.load)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Nil(t, value)
+	assert.Equal(t, 0, loader.numCalls())
+	assert.Equal(t, 0, len(c.loads.calls))
+}
+
+func TestCache_GetOrLoad_Leader_Canceled(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+
+	started := make(chan struct{})
+	leaderLoader := func(ctx context.Context) ([]byte, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	leaderDone := make(chan error)
+	go func() {
+		_, err := c.GetOrLoad(ctx, key, leaderLoader)
+		leaderDone <- err
+	}()
+	<-started
+
+	// the waiting caller loads the value itself after the leader gave up
+	loader := &countingLoader{value: []byte("value01")}
+	followerDone := make(chan []byte)
+	go func() {
+		value, err := c.GetOrLoad(context.Background(), key, loader.load)
+		assert.Equal(t, nil, err)
+		followerDone <- value
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+
+	assert.Equal(t, context.Canceled, <-leaderDone)
+	assert.Equal(t, []byte("value01"), <-followerDone)
+	assert.Equal(t, 1, loader.numCalls())
+}
+
+func TestCache_GetOrLoad_TTL(t *testing.T) {
+	clock := newFakeClock()
+	conf := newTestCacheConfig(4)
+	conf.PartitionConfig.Clock = clock
+	conf.LoadTTL = 10 * time.Second
+	c := NewCache(conf)
+
+	key := []byte("key01")
+	loader := &countingLoader{value: []byte("value01")}
+
+	_, err := c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, nil, err)
+
+	clock.Advance(10 * time.Second)
+	_, ok := c.Get(key, nil)
+	assert.False(t, ok)
+
+	_, err = c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, loader.numCalls())
+}
+
+func TestCache_GetOrLoad_Stale_Value(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+	key := []byte("key01")
+	assert.Equal(t, nil, c.Set(key, []byte("value01"), 0))
+	assert.True(t, c.Invalidate(key))
+
+	loader := &countingLoader{err: errors.New("load error")}
+	_, err := c.GetOrLoad(context.Background(), key, loader.load)
+	assert.Equal(t, loader.err, err)
+
+	// the stale value is kept after the lease is released
+	result, _ := c.LeaseGet(key)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.True(t, result.Stale)
+	assert.Equal(t, []byte("value01"), result.Value)
+}
@@ -7,14 +7,16 @@ import (
 
 const nullPtr uint32 = math.MaxUint32
 
-// LRU ...
+// LRU is a list of hashes with weights, the limit is on the total weight.
+// The weights are kept by the callers, they must pass the same weight to Put and Delete
 type LRU struct {
 	slab  *allocator.RealSlab
 	limit uint32
 
-	next uint32
-	prev uint32
-	size uint32
+	next   uint32
+	prev   uint32
+	size   uint32
+	weight uint32
 }
 
 // ListHead ...
@@ -30,9 +32,10 @@ func New(slab *allocator.RealSlab, limit uint32) *LRU {
 		slab:  slab,
 		limit: limit,
 
-		next: nullPtr,
-		prev: nullPtr,
-		size: 0,
+		next:   nullPtr,
+		prev:   nullPtr,
+		size:   0,
+		weight: 0,
 	}
 }
 
@@ -48,9 +51,10 @@ func (l *LRU) GetLRUList() []uint64 {
 	return result
 }
 
-// Put ...
-func (l *LRU) Put(hash uint64) (uint32, bool) {
-	if l.size >= l.limit {
+// Put returns false if the list head can NOT be allocated,
+// or the list is not empty and the total weight would exceed the limit
+func (l *LRU) Put(hash uint64, weight uint32) (uint32, bool) {
+	if l.size > 0 && uint64(l.weight)+uint64(weight) > uint64(l.limit) {
 		return 0, false
 	}
 
@@ -60,6 +64,7 @@ func (l *LRU) Put(hash uint64) (uint32, bool) {
 	}
 
 	l.size++
+	l.weight += weight
 	head := (*ListHead)(l.slab.ToRealAddr(addr))
 	head.hash = hash
 
@@ -83,9 +88,20 @@ func (l *LRU) Last() (uint32, uint64) {
 	return l.prev, last.hash
 }
 
+// Prev returns the list head before *addr*, ok = false if *addr* is the first one
+func (l *LRU) Prev(addr uint32) (uint32, uint64, bool) {
+	head := (*ListHead)(l.slab.ToRealAddr(addr))
+	if head.prev == nullPtr {
+		return 0, 0, false
+	}
+	prev := (*ListHead)(l.slab.ToRealAddr(head.prev))
+	return head.prev, prev.hash, true
+}
+
 // Delete ...
-func (l *LRU) Delete(addr uint32) {
+func (l *LRU) Delete(addr uint32, weight uint32) {
 	l.size--
+	l.weight -= weight
 	head := (*ListHead)(l.slab.ToRealAddr(addr))
 
 	if head.next != nullPtr {
@@ -142,6 +158,11 @@ func (l *LRU) Size() uint32 {
 	return l.size
 }
 
+// Weight returns the total weight
+func (l *LRU) Weight() uint32 {
+	return l.weight
+}
+
 // Limit ...
 func (l *LRU) Limit() uint32 {
 	return l.limit
@@ -35,7 +35,7 @@ func TestLRU_Put_Delete(t *testing.T) {
 
 	l := New(slab, 100)
 
-	p1, ok := l.Put(2233)
+	p1, ok := l.Put(2233, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(0), p1)
 	assert.Equal(t, []uint64{2233}, l.GetLRUList())
@@ -44,7 +44,7 @@ func TestLRU_Put_Delete(t *testing.T) {
 	assert.Equal(t, uint64(2233), hash)
 	assert.Equal(t, uint32(1), l.Size())
 
-	p2, ok := l.Put(3300)
+	p2, ok := l.Put(3300, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(1000), p2)
 	assert.Equal(t, []uint64{3300, 2233}, l.GetLRUList())
@@ -53,7 +53,7 @@ func TestLRU_Put_Delete(t *testing.T) {
 	assert.Equal(t, uint64(2233), hash)
 	assert.Equal(t, uint32(2), l.Size())
 
-	p3, ok := l.Put(4400)
+	p3, ok := l.Put(4400, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(2000), p3)
 	assert.Equal(t, []uint64{4400, 3300, 2233}, l.GetLRUList())
@@ -63,7 +63,7 @@ func TestLRU_Put_Delete(t *testing.T) {
 	assert.Equal(t, uint32(3), l.Size())
 	assert.Equal(t, uint64(1000*3+96), l.slab.GetMemUsage())
 
-	l.Delete(p2)
+	l.Delete(p2, 1)
 	assert.Equal(t, []uint64{4400, 2233}, l.GetLRUList())
 	addr, hash = l.Last()
 	assert.Equal(t, p1, addr)
@@ -71,7 +71,7 @@ func TestLRU_Put_Delete(t *testing.T) {
 	assert.Equal(t, uint32(2), l.Size())
 	assert.Equal(t, uint64(1000*2+96), l.slab.GetMemUsage())
 
-	l.Delete(p1)
+	l.Delete(p1, 1)
 	assert.Equal(t, []uint64{4400}, l.GetLRUList())
 	addr, hash = l.Last()
 	assert.Equal(t, p3, addr)
@@ -79,7 +79,7 @@ func TestLRU_Put_Delete(t *testing.T) {
 	assert.Equal(t, uint32(1), l.Size())
 	assert.Equal(t, uint64(1000*1+96), l.slab.GetMemUsage())
 
-	l.Delete(p3)
+	l.Delete(p3, 1)
 	assert.Equal(t, []uint64(nil), l.GetLRUList())
 	assert.Equal(t, uint32(0), l.Size())
 	assert.Equal(t, uint64(96), l.slab.GetMemUsage())
@@ -93,30 +93,30 @@ func TestLRU_Put_Limited(t *testing.T) {
 	slab := allocator.NewRealSlab(&buddy, 1000, 12)
 
 	l := New(slab, 3)
-	p1, ok := l.Put(1100)
+	p1, ok := l.Put(1100, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(0), p1)
 
-	p2, ok := l.Put(2200)
+	p2, ok := l.Put(2200, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(1000), p2)
 
-	p3, ok := l.Put(3300)
+	p3, ok := l.Put(3300, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(2000), p3)
 
-	p4, ok := l.Put(4400)
+	p4, ok := l.Put(4400, 1)
 	assert.False(t, ok)
 	assert.Equal(t, uint32(0), p4)
 
-	p5, ok := l.Put(4400)
+	p5, ok := l.Put(4400, 1)
 	assert.False(t, ok)
 	assert.Equal(t, uint32(0), p5)
 	assert.Equal(t, uint32(3), l.Size())
 
 	l.UpdateLimit(2)
 
-	p6, ok := l.Put(4400)
+	p6, ok := l.Put(4400, 1)
 	assert.False(t, ok)
 	assert.Equal(t, uint32(0), p6)
 	assert.Equal(t, uint32(3), l.Size())
@@ -130,23 +130,23 @@ func TestLRU_Put_CanNot_Allocate(t *testing.T) {
 	slab := allocator.NewRealSlab(&buddy, 1000, 12)
 
 	l := New(slab, 100)
-	p1, ok := l.Put(1100)
+	p1, ok := l.Put(1100, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(0), p1)
 
-	p2, ok := l.Put(2200)
+	p2, ok := l.Put(2200, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(1000), p2)
 
-	p3, ok := l.Put(3300)
+	p3, ok := l.Put(3300, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(2000), p3)
 
-	p4, ok := l.Put(4400)
+	p4, ok := l.Put(4400, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(3000), p4)
 
-	p5, ok := l.Put(4400)
+	p5, ok := l.Put(4400, 1)
 	assert.False(t, ok)
 	assert.Equal(t, uint32(0), p5)
 }
@@ -160,19 +160,19 @@ func TestLRU_Put_Touch(t *testing.T) {
 
 	l := New(slab, 100)
 
-	p1, ok := l.Put(2233)
+	p1, ok := l.Put(2233, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(0), p1)
 
-	p2, ok := l.Put(3300)
+	p2, ok := l.Put(3300, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(1000), p2)
 
-	p3, ok := l.Put(4400)
+	p3, ok := l.Put(4400, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(2000), p3)
 
-	p4, ok := l.Put(5500)
+	p4, ok := l.Put(5500, 1)
 	assert.True(t, ok)
 	assert.Equal(t, uint32(3000), p4)
 	assert.Equal(t, uint32(4), l.Size())
@@ -197,9 +197,9 @@ func TestLRU_Put_Touch(t *testing.T) {
 	assert.Equal(t, p3, addr)
 	assert.Equal(t, uint64(4400), hash)
 
-	l.Delete(p2)
-	l.Delete(p1)
-	l.Delete(p3)
+	l.Delete(p2, 1)
+	l.Delete(p1, 1)
+	l.Delete(p3, 1)
 
 	assert.Equal(t, []uint64{5500}, l.GetLRUList())
 	l.Touch(p4)
@@ -208,3 +208,71 @@ func TestLRU_Put_Touch(t *testing.T) {
 	assert.Equal(t, p4, addr)
 	assert.Equal(t, uint64(5500), hash)
 }
+
+func TestLRU_Prev(t *testing.T) {
+	data := make([]uint64, 1<<12)
+	var buddy allocator.Buddy
+	allocator.BuddyInit(&buddy, 12, 2, unsafe.Pointer(&data[0]))
+
+	slab := allocator.NewRealSlab(&buddy, 1000, 12)
+
+	l := New(slab, 100)
+
+	p1, _ := l.Put(1100, 1)
+	p2, _ := l.Put(2200, 1)
+	p3, _ := l.Put(3300, 1)
+
+	addr, hash := l.Last()
+	assert.Equal(t, p1, addr)
+	assert.Equal(t, uint64(1100), hash)
+
+	addr, hash, ok := l.Prev(addr)
+	assert.True(t, ok)
+	assert.Equal(t, p2, addr)
+	assert.Equal(t, uint64(2200), hash)
+
+	addr, hash, ok = l.Prev(addr)
+	assert.True(t, ok)
+	assert.Equal(t, p3, addr)
+	assert.Equal(t, uint64(3300), hash)
+
+	_, _, ok = l.Prev(addr)
+	assert.False(t, ok)
+}
+
+func TestLRU_Weight(t *testing.T) {
+	data := make([]uint64, 1<<12)
+	var buddy allocator.Buddy
+	allocator.BuddyInit(&buddy, 12, 2, unsafe.Pointer(&data[0]))
+
+	slab := allocator.NewRealSlab(&buddy, 1000, 12)
+
+	l := New(slab, 100)
+
+	// the first element can be heavier than the limit
+	p1, ok := l.Put(1100, 120)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(120), l.Weight())
+
+	_, ok = l.Put(2200, 10)
+	assert.False(t, ok)
+
+	l.Delete(p1, 120)
+	assert.Equal(t, uint32(0), l.Weight())
+
+	_, ok = l.Put(2200, 60)
+	assert.True(t, ok)
+	p3, ok := l.Put(3300, 40)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(100), l.Weight())
+	assert.Equal(t, uint32(2), l.Size())
+
+	_, ok = l.Put(4400, 1)
+	assert.False(t, ok)
+
+	l.Delete(p3, 40)
+	_, ok = l.Put(4400, 40)
+	assert.True(t, ok)
+	assert.Equal(t, []uint64{4400, 2200}, l.GetLRUList())
+	assert.Equal(t, uint32(100), l.Weight())
+}
@@ -0,0 +1,256 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/QuangTung97/espresso"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// StatsSource is implemented by *espresso.Cache
+type StatsSource interface {
+	Stats() espresso.CacheStats
+}
+
+type namedSource struct {
+	name   string
+	source StatsSource
+}
+
+// Registry collects the metrics of the registered caches in the Prometheus text exposition format.
+// Every sample has the label cache="<name>"
+type Registry struct {
+	mut     sync.Mutex
+	sources []namedSource
+}
+
+// NewRegistry ...
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a cache to the registry, returns an error if the name is already registered
+func (r *Registry) Register(name string, source StatsSource) error {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	for _, s := range r.sources {
+		if s.name == name {
+			return fmt.Errorf("metrics: cache %q is already registered", name)
+		}
+	}
+	r.sources = append(r.sources, namedSource{name: name, source: source})
+	sort.Slice(r.sources, func(i, j int) bool {
+		return r.sources[i].name < r.sources[j].name
+	})
+	return nil
+}
+
+// Unregister removes a cache from the registry, returns false if the name is not registered
+func (r *Registry) Unregister(name string) bool {
+	r.mut.Lock()
+	defer r.mut.Unlock()
+
+	for i, s := range r.sources {
+		if s.name == name {
+			r.sources = append(r.sources[:i], r.sources[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	labels []label
+	value  float64
+}
+
+type family struct {
+	name    string
+	help    string
+	typ     string
+	samples []sample
+}
+
+type familyList struct {
+	families []*family
+	index    map[string]*family
+}
+
+func (l *familyList) add(name string, typ string, help string, value float64, labels ...label) {
+	f, ok := l.index[name]
+	if !ok {
+		f = &family{name: name, help: help, typ: typ}
+		l.families = append(l.families, f)
+		l.index[name] = f
+	}
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+func (l *familyList) counter(name string, help string, value uint64, labels ...label) {
+	l.add(name, "counter", help, float64(value), labels...)
+}
+
+func (l *familyList) gauge(name string, help string, value float64, labels ...label) {
+	l.add(name, "gauge", help, value, labels...)
+}
+
+func collectCache(l *familyList, name string, stats espresso.CacheStats) {
+	cache := label{name: "cache", value: name}
+
+	l.counter("espresso_hits_total", "Number of reads that found a valid value.", stats.Hits, cache)
+	l.counter("espresso_misses_total", "Number of reads that found no valid value.", stats.Misses, cache)
+	l.gauge("espresso_hit_ratio", "Hits divided by all reads since the cache was created.", stats.HitRatio(), cache)
+
+	l.counter("espresso_leases_total", "Number of LeaseGet calls by outcome.",
+		stats.LeaseGranted, cache, label{name: "outcome", value: "granted"})
+	l.counter("espresso_leases_total", "Number of LeaseGet calls by outcome.",
+		stats.LeaseRejected, cache, label{name: "outcome", value: "rejected"})
+
+	evictions := []struct {
+		segment string
+		value   uint64
+	}{
+		{segment: "admission", value: stats.AdmissionEvictions},
+		{segment: "probation", value: stats.ProbationEvictions},
+		{segment: "protected", value: stats.ProtectedEvictions},
+	}
+	for _, e := range evictions {
+		l.counter("espresso_evictions_total", "Number of evicted entries by LRU segment.",
+			e.value, cache, label{name: "segment", value: e.segment})
+	}
+	l.counter("espresso_admission_rejections_total",
+		"Number of admission candidates rejected by TinyLFU.", stats.AdmissionRejections, cache)
+	l.counter("espresso_expirations_total", "Number of entries removed after their TTL.", stats.Expirations, cache)
+	l.counter("espresso_slab_moves_total", "Number of chunks moved between slab classes.", stats.SlabMoves, cache)
+
+	l.gauge("espresso_entries", "Number of entries.", float64(stats.NumEntries), cache)
+	l.gauge("espresso_memory_usage_bytes", "Memory used by the slabs and the large items.",
+		float64(stats.MemUsage), cache)
+
+	lruTotals := stats.LRUTotals()
+	segments := [3]espresso.LRUSum{lruTotals.Admission, lruTotals.Probation, lruTotals.Protected}
+	totalBytes := uint64(0)
+	largeItems := uint64(0)
+	largeBytes := uint64(0)
+	for _, p := range stats.Partitions {
+		totalBytes += p.Allocator.TotalBytes
+		largeItems += p.Allocator.LargeItems
+		largeBytes += p.Allocator.LargeBytes
+	}
+
+	l.gauge("espresso_memory_limit_bytes", "Memory managed by the allocators.", float64(totalBytes), cache)
+	l.gauge("espresso_large_items", "Number of items larger than the largest slab class.",
+		float64(largeItems), cache)
+	l.gauge("espresso_large_item_bytes", "Memory used by the items larger than the largest slab class.",
+		float64(largeBytes), cache)
+
+	for i, segment := range []string{"admission", "probation", "protected"} {
+		segmentLabel := label{name: "segment", value: segment}
+		l.gauge("espresso_lru_entries", "Number of entries by LRU segment.",
+			float64(segments[i].Size), cache, segmentLabel)
+		l.gauge("espresso_lru_weight_bytes", "Weight of the entries by LRU segment.",
+			float64(segments[i].Weight), cache, segmentLabel)
+		if segment != "probation" {
+			// probation is unbounded
+			l.gauge("espresso_lru_limit_bytes", "Weight limit by LRU segment.",
+				float64(segments[i].Limit), cache, segmentLabel)
+		}
+	}
+
+	for _, s := range stats.SlabTotals() {
+		elemSize := label{name: "elem_size", value: strconv.FormatUint(uint64(s.ElemSize), 10)}
+		l.gauge("espresso_slab_items", "Number of items by slab class.", float64(s.NumItems), cache, elemSize)
+		l.gauge("espresso_slab_chunks", "Number of chunks by slab class.", float64(s.NumChunks), cache, elemSize)
+		l.gauge("espresso_slab_item_bytes", "Memory used by the items by slab class.",
+			float64(s.ItemBytes), cache, elemSize)
+		l.gauge("espresso_slab_wasted_bytes", "Memory of the chunks NOT used by any item, by slab class.",
+			float64(s.WastedBytes), cache, elemSize)
+	}
+
+	for i, p := range stats.Partitions {
+		l.gauge("espresso_fragmentation_ratio",
+			"Fraction of the free memory outside of the largest free buddy block.",
+			p.Allocator.Fragmentation, cache, label{name: "partition", value: strconv.Itoa(i)})
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func writeFamily(w *bytes.Buffer, f *family) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, f.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+
+	for _, s := range f.samples {
+		w.WriteString(f.name)
+		if len(s.labels) > 0 {
+			w.WriteByte('{')
+			for i, l := range s.labels {
+				if i > 0 {
+					w.WriteByte(',')
+				}
+				fmt.Fprintf(w, `%s="%s"`, l.name, labelValueReplacer.Replace(l.value))
+			}
+			w.WriteByte('}')
+		}
+		w.WriteByte(' ')
+		w.WriteString(formatValue(s.value))
+		w.WriteByte('\n')
+	}
+}
+
+// WriteText writes the metrics of all registered caches in the Prometheus text exposition format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mut.Lock()
+	sources := append([]namedSource(nil), r.sources...)
+	r.mut.Unlock()
+
+	l := &familyList{index: map[string]*family{}}
+	for _, s := range sources {
+		collectCache(l, s.name, s.source.Stats())
+	}
+
+	var buf bytes.Buffer
+	for _, f := range l.families {
+		writeFamily(&buf, f)
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// ServeHTTP implements http.Handler, the registry can be mounted at /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType)
+	if req.Method == http.MethodHead {
+		return
+	}
+	_ = r.WriteText(w)
+}
+
+// Handler returns an http.Handler serving the metrics of the registry
+func (r *Registry) Handler() http.Handler {
+	return r
+}
@@ -0,0 +1,164 @@
+package metrics
+
+import (
+	"bytes"
+	"github.com/QuangTung97/espresso"
+	"github.com/QuangTung97/espresso/allocator"
+	"github.com/QuangTung97/espresso/espressotest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type fakeSource struct {
+	stats espresso.CacheStats
+}
+
+func (s *fakeSource) Stats() espresso.CacheStats {
+	return s.stats
+}
+
+func newFakeStats() espresso.CacheStats {
+	partition := espresso.PartitionStats{
+		NumEntries: 3,
+		Admission:  espresso.LRUStats{Size: 1, Weight: 48, Limit: 480},
+		Protected:  espresso.LRUStats{Size: 2, Weight: 96, Limit: 4800},
+		Probation:  espresso.LRUStats{Limit: 1<<32 - 1},
+		Allocator: allocator.Stats{
+			TotalBytes: 1 << 16,
+			MemUsage:   144,
+			Slabs: []allocator.SlabStats{
+				{ElemSize: 48, ChunkSizeLog: 12, NumItems: 3, NumChunks: 1, ItemBytes: 144, WastedBytes: 4096 - 144},
+			},
+			Fragmentation: 0.5,
+		},
+	}
+
+	return espresso.CacheStats{
+		PartitionCounters: espresso.PartitionCounters{
+			Hits:               30,
+			Misses:             10,
+			LeaseGranted:       7,
+			LeaseRejected:      2,
+			AdmissionEvictions: 5,
+		},
+		NumEntries: 6,
+		MemUsage:   288,
+		Partitions: []espresso.PartitionStats{partition, partition},
+	}
+}
+
+func TestWriteFamily(t *testing.T) {
+	var buf bytes.Buffer
+	writeFamily(&buf, &family{
+		name: "test_total",
+		help: "Test help.",
+		typ:  "counter",
+		samples: []sample{
+			{value: 1},
+			{labels: []label{{name: "a", value: `x"y\z` + "\n"}, {name: "b", value: "c"}}, value: 0.25},
+		},
+	})
+
+	assert.Equal(t, `# HELP test_total Test help.
+# TYPE test_total counter
+test_total 1
+test_total{a="x\"y\\z\n",b="c"} 0.25
+`, buf.String())
+}
+
+func TestRegistry_WriteText(t *testing.T) {
+	r := NewRegistry()
+	assert.Equal(t, nil, r.Register("users", &fakeSource{stats: newFakeStats()}))
+	assert.Equal(t, nil, r.Register("items", &fakeSource{stats: newFakeStats()}))
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, r.WriteText(&buf))
+	text := buf.String()
+
+	expectedLines := []string{
+		"# TYPE espresso_hits_total counter",
+		`espresso_hits_total{cache="items"} 30`,
+		`espresso_hits_total{cache="users"} 30`,
+		`espresso_hit_ratio{cache="users"} 0.75`,
+		`espresso_leases_total{cache="users",outcome="granted"} 7`,
+		`espresso_leases_total{cache="users",outcome="rejected"} 2`,
+		`espresso_evictions_total{cache="users",segment="admission"} 5`,
+		`espresso_evictions_total{cache="users",segment="probation"} 0`,
+		`espresso_entries{cache="users"} 6`,
+		`espresso_memory_usage_bytes{cache="users"} 288`,
+		`espresso_memory_limit_bytes{cache="users"} 131072`,
+		`espresso_lru_entries{cache="users",segment="protected"} 4`,
+		`espresso_lru_weight_bytes{cache="users",segment="admission"} 96`,
+		`espresso_lru_limit_bytes{cache="users",segment="admission"} 960`,
+		`espresso_slab_items{cache="users",elem_size="48"} 6`,
+		`espresso_slab_wasted_bytes{cache="users",elem_size="48"} 7904`,
+		`espresso_fragmentation_ratio{cache="users",partition="1"} 0.5`,
+	}
+	for _, line := range expectedLines {
+		assert.Contains(t, text, line+"\n")
+	}
+	assert.NotContains(t, text, `segment="probation"} 4294967295`)
+
+	// every family is written once, the samples of all caches are grouped together
+	assert.Equal(t, 1, strings.Count(text, "# TYPE espresso_hits_total counter\n"))
+	assert.Less(t,
+		strings.Index(text, `espresso_hits_total{cache="items"}`),
+		strings.Index(text, `espresso_hits_total{cache="users"}`),
+	)
+	assert.Less(t,
+		strings.Index(text, `espresso_hits_total{cache="users"}`),
+		strings.Index(text, "# TYPE espresso_misses_total"),
+	)
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r := NewRegistry()
+	assert.Equal(t, nil, r.Register("users", &fakeSource{}))
+	assert.Error(t, r.Register("users", &fakeSource{}))
+
+	assert.True(t, r.Unregister("users"))
+	assert.False(t, r.Unregister("users"))
+
+	var buf bytes.Buffer
+	assert.Equal(t, nil, r.WriteText(&buf))
+	assert.Equal(t, "", buf.String())
+}
+
+func TestRegistry_Handler(t *testing.T) {
+	c := espressotest.NewCache(espressotest.Config{NumPartitions: 2})
+	assert.Equal(t, nil, c.Set([]byte("key01"), []byte("value01"), 0))
+	c.Get([]byte("key01"), nil)
+	c.Get([]byte("key02"), nil)
+
+	r := NewRegistry()
+	assert.Equal(t, nil, r.Register("default", c))
+
+	server := httptest.NewServer(r.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	assert.Equal(t, nil, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	var buf bytes.Buffer
+	_, err = buf.ReadFrom(resp.Body)
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ContentType, resp.Header.Get("Content-Type"))
+	assert.Contains(t, buf.String(), `espresso_hits_total{cache="default"} 1`+"\n")
+	assert.Contains(t, buf.String(), `espresso_misses_total{cache="default"} 1`+"\n")
+	assert.Contains(t, buf.String(), `espresso_slab_items{cache="default",elem_size="64"} 1`+"\n")
+}
+
+func TestRegistry_Handler_Method_Not_Allowed(t *testing.T) {
+	r := NewRegistry()
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/metrics", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "GET, HEAD", w.Header().Get("Allow"))
+}
@@ -5,12 +5,14 @@ import (
 	"github.com/QuangTung97/espresso/allocator"
 	"github.com/QuangTung97/espresso/lru"
 	"github.com/QuangTung97/espresso/sketch"
+	"github.com/QuangTung97/espresso/wheel"
 	"math"
 	"reflect"
+	"time"
 	"unsafe"
 )
 
-type entryStatus uint16
+type entryStatus uint8
 
 const (
 	entryStatusLeasing entryStatus = 0
@@ -18,7 +20,7 @@ const (
 	entryStatusInvalid entryStatus = 2
 )
 
-type lruListType uint16
+type lruListType uint8
 
 const (
 	lruListAdmission lruListType = 0
@@ -38,27 +40,82 @@ const (
 	LeaseGetStatusExisted LeaseGetStatus = 3
 )
 
+// LeaseSetStatus ...
+type LeaseSetStatus uint32
+
+const (
+	// LeaseSetStatusAccepted the value is stored
+	LeaseSetStatusAccepted LeaseSetStatus = 1
+	// LeaseSetStatusLeaseMismatch the entry is not leasing or the lease belongs to another caller
+	LeaseSetStatusLeaseMismatch LeaseSetStatus = 2
+	// LeaseSetStatusEntryGone the entry had been evicted or deleted
+	LeaseSetStatusEntryGone LeaseSetStatus = 3
+)
+
+// DefaultLeaseTimeout is used when PartitionConfig.LeaseTimeout is zero
+const DefaultLeaseTimeout = 10 * time.Second
+
+// DefaultTimerTick is used when PartitionConfig.TimerTick is zero
+const DefaultTimerTick = time.Second
+
 // PartitionConfig ...
 type PartitionConfig struct {
+	// InitAdmissionLimit is the initial size of the admission window in bytes,
+	// the window is resized by hill climbing to maximize the hit rate
 	InitAdmissionLimit uint32
-	ProtectedRatio     Rational
+	// ProtectedRatio is the max ratio of the protected list in the main (protected + probation) region, in bytes
+	ProtectedRatio Rational
+	// MinProtectedLimit is the min size of the protected list in bytes
 	MinProtectedLimit  uint32
 	NumCounters        uint64
 	SketchMinCacheSize uint64
 	AllocatorConfig    allocator.Config
+
+	// LeaseTimeout is the duration after which a granted lease is considered abandoned,
+	// default to DefaultLeaseTimeout
+	LeaseTimeout time.Duration
+	// Clock default to SystemClock
+	Clock Clock
+	// TimerTick is the resolution of the timing wheel that frees expired entries, default to DefaultTimerTick.
+	// Reading an expired entry always misses, regardless of the resolution
+	TimerTick time.Duration
+
+	// SlabAutomove moves chunks from the slab classes having low eviction pressure to the classes
+	// having high eviction pressure, by evicting the least recently used entries of the donor class.
+	// Similar to memcached's slab_automove
+	SlabAutomove bool
 }
 
 // Partition ...
 type Partition struct {
 	allocator  *allocator.Allocator
 	contentMap map[uint64]uint32
+	collisions map[uint64][]uint32
 	sketch     *sketch.Sketch
 
-	leaseIDSeq uint64
+	leaseIDSeq   uint64
+	leaseTimeout time.Duration
+	clock        Clock
+
+	timer     *wheel.Wheel
+	timerTick uint64 // in nanoseconds
+
+	protectedRatio    Rational
+	minProtectedLimit uint32
 
 	admission *lru.LRU
 	protected *lru.LRU
 	probation *lru.LRU
+
+	climber hillClimber
+
+	slabAutomove bool
+	rebalancer   slabRebalancer
+
+	counters PartitionCounters
+
+	// pinned are the entry addresses held across evictions, updated when the entries are relocated
+	pinned []*uint32
 }
 
 // LeaseGetResult ...
@@ -66,52 +123,112 @@ type LeaseGetResult struct {
 	Status  LeaseGetStatus
 	LeaseID uint64
 	Value   []byte
+	Stale   bool // Value is the stale value of an invalidated entry
 }
 
 type entryHeader struct {
-	size    uint32 // size is the size of the whole entry (including header)
-	keySize uint32 // keySize is the size of key only
-	leaseID uint64 // leaseID or version
-	hash    uint64 // hash
-	lruAddr uint32 // address of LRU List Head
-	status  entryStatus
-	lruList lruListType
+	size      uint32 // size is the size of the whole entry (including header)
+	keySize   uint16 // keySize is the size of key only
+	status    entryStatus
+	lruList   lruListType
+	lruAddr   uint32 // address of LRU List Head
+	timerAddr uint32 // address of the timing wheel node, only valid entries having TTL have one
+	leaseID   uint64 // leaseID or version
+	hash      uint64 // hash
+	// expire is the expire time of the lease, or of the value for valid entries (zero = no TTL).
+	// In unix nanoseconds
+	expire int64
 }
 
-func validatePartitionConfig(conf PartitionConfig) {
+func validatePartitionConfig(conf PartitionConfig) error {
 	if conf.InitAdmissionLimit == 0 {
-		panic("InitAdmissionLimit must > 0")
+		return newConfigError("InitAdmissionLimit", "InitAdmissionLimit must > 0")
 	}
 	if conf.ProtectedRatio.Denominator == 0 || conf.ProtectedRatio.Nominator == 0 {
-		panic("ProtectedRatio must not empty")
+		return newConfigError("ProtectedRatio", "ProtectedRatio must not empty")
 	}
 	if conf.MinProtectedLimit == 0 {
-		panic("MinProtectedLimit must > 0")
+		return newConfigError("MinProtectedLimit", "MinProtectedLimit must > 0")
 	}
 	if conf.NumCounters == 0 {
-		panic("NumCounters must > 0")
+		return newConfigError("NumCounters", "NumCounters must > 0")
 	}
 	if conf.SketchMinCacheSize == 0 {
-		panic("SketchMinCacheSize must > 0")
+		return newConfigError("SketchMinCacheSize", "SketchMinCacheSize must > 0")
+	}
+	if conf.LeaseTimeout < 0 {
+		return newConfigError("LeaseTimeout", "LeaseTimeout must >= 0")
+	}
+	if conf.TimerTick < 0 {
+		return newConfigError("TimerTick", "TimerTick must >= 0")
 	}
+	return nil
 }
 
-// NewPartition ...
+// NewPartition panics if the config is invalid, see TryNewPartition
 func NewPartition(conf PartitionConfig) *Partition {
-	validatePartitionConfig(conf)
+	p, err := TryNewPartition(conf)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
 
-	alloc := allocator.New(conf.AllocatorConfig)
-	return &Partition{
+// TryNewPartition returns an error matching ErrInvalidConfig if the config is invalid
+func TryNewPartition(conf PartitionConfig) (*Partition, error) {
+	if err := validatePartitionConfig(conf); err != nil {
+		return nil, err
+	}
+
+	leaseTimeout := conf.LeaseTimeout
+	if leaseTimeout == 0 {
+		leaseTimeout = DefaultLeaseTimeout
+	}
+
+	clock := conf.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+
+	timerTick := uint64(conf.TimerTick)
+	if timerTick == 0 {
+		timerTick = uint64(DefaultTimerTick)
+	}
+
+	alloc, err := allocator.TryNew(conf.AllocatorConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Partition{
 		allocator:  alloc,
 		contentMap: map[uint64]uint32{},
+		collisions: map[uint64][]uint32{},
 		sketch:     sketch.New(conf.NumCounters, conf.SketchMinCacheSize),
 
-		leaseIDSeq: 0,
+		leaseIDSeq:   0,
+		leaseTimeout: leaseTimeout,
+		clock:        clock,
+
+		timer: wheel.New(
+			alloc.CreateRealSlab(uint32(unsafe.Sizeof(wheel.Node{}))),
+			uint64(clock.Now().UnixNano())/timerTick,
+		),
+		timerTick: timerTick,
+
+		protectedRatio:    conf.ProtectedRatio,
+		minProtectedLimit: conf.MinProtectedLimit,
 
 		admission: lru.New(alloc.GetLRUSlab(), conf.InitAdmissionLimit),
 		protected: lru.New(alloc.GetLRUSlab(), conf.MinProtectedLimit),
 		probation: lru.New(alloc.GetLRUSlab(), math.MaxUint32),
+
+		slabAutomove: conf.SlabAutomove,
+		rebalancer:   newSlabRebalancer(alloc.NumSlabs()),
 	}
+	alloc.SetRelocateFunc(p.relocate)
+	p.timer.SetExpireFunc(p.expireEntry)
+	return p, nil
 }
 
 func (p *Partition) getBytes(addr uint32, length uint32) []byte {
@@ -123,150 +240,744 @@ func (p *Partition) getBytes(addr uint32, length uint32) []byte {
 	return result
 }
 
+const nullAddr uint32 = math.MaxUint32
+
 func assertTrue(b bool) {
 	if !b {
 		panic("must be true")
 	}
 }
 
-func (p *Partition) putLease(hash uint64, key []byte, leaseID uint64) bool {
-	size := uint32(unsafe.Sizeof(entryHeader{})) + uint32(len(key))
+func (p *Partition) getHeader(addr uint32) *entryHeader {
+	return (*entryHeader)(p.allocator.ToRealAddr(addr))
+}
 
-	var lruAddr uint32
-	var lruList lruListType
+func (p *Partition) getKey(addr uint32) []byte {
+	header := p.getHeader(addr)
+	return p.getBytes(addr+uint32(unsafe.Sizeof(entryHeader{})), uint32(header.keySize))
+}
 
-	for p.admission.Size() >= p.admission.Limit() {
-		lastAddr, lastHash := p.admission.Last()
-		p.admission.Delete(lastAddr)
+// findEntry returns the address of the entry having the same hash and key
+func (p *Partition) findEntry(hash uint64, key []byte) (uint32, bool) {
+	addr, ok := p.contentMap[hash]
+	if !ok {
+		return 0, false
+	}
+	if bytes.Equal(p.getKey(addr), key) {
+		return addr, true
+	}
 
-		// Can NOT be false here
-		lastAddr, ok := p.probation.Put(lastHash)
-		assertTrue(ok)
+	for _, addr := range p.collisions[hash] {
+		if bytes.Equal(p.getKey(addr), key) {
+			return addr, true
+		}
+	}
+	return 0, false
+}
 
-		entryAddr := p.contentMap[lastHash]
-		header := (*entryHeader)(p.allocator.ToRealAddr(entryAddr))
-		header.lruList = lruListProbation
+// findEntryByLRU returns the address of the entry that owns the LRU list head at *lruAddr*
+func (p *Partition) findEntryByLRU(hash uint64, lruAddr uint32) uint32 {
+	addr := p.contentMap[hash]
+	if p.getHeader(addr).lruAddr == lruAddr {
+		return addr
 	}
 
-	lruAddr, ok := p.admission.Put(hash)
-	if !ok {
-		// TODO loop until enough space
-		return false
+	for _, addr := range p.collisions[hash] {
+		if p.getHeader(addr).lruAddr == lruAddr {
+			return addr
+		}
 	}
-	lruList = lruListAdmission
+	panic("entry of LRU list head not found")
+}
 
-	addr, ok := p.allocator.Allocate(size)
-	if !ok {
-		// TODO loop until enough space
-		return false
+// findEntryByTimer returns the address of the entry that owns the timing wheel node at *timerAddr*
+func (p *Partition) findEntryByTimer(hash uint64, timerAddr uint32) uint32 {
+	addr := p.contentMap[hash]
+	if p.getHeader(addr).timerAddr == timerAddr {
+		return addr
+	}
+
+	for _, addr := range p.collisions[hash] {
+		if p.getHeader(addr).timerAddr == timerAddr {
+			return addr
+		}
+	}
+	panic("entry of timing wheel node not found")
+}
+
+// linkEntry adds the entry to the content map, entries with the same hash are chained in the collisions map
+func (p *Partition) linkEntry(hash uint64, addr uint32) {
+	if _, existed := p.contentMap[hash]; existed {
+		p.collisions[hash] = append(p.collisions[hash], addr)
+		return
 	}
 	p.contentMap[hash] = addr
+}
 
-	header := (*entryHeader)(p.allocator.ToRealAddr(addr))
-	*header = entryHeader{
-		size:    size,
-		keySize: uint32(len(key)),
-		leaseID: leaseID,
-		hash:    hash,
-		lruAddr: lruAddr,
-		status:  entryStatusLeasing,
-		lruList: lruList,
+func (p *Partition) removeCollision(hash uint64, index int) {
+	list := p.collisions[hash]
+	last := len(list) - 1
+
+	list[index] = list[last]
+	if last == 0 {
+		delete(p.collisions, hash)
+	} else {
+		p.collisions[hash] = list[:last]
 	}
+}
 
-	keyAddr := addr + uint32(unsafe.Sizeof(entryHeader{}))
-	keyLen := header.keySize
+func (p *Partition) unlinkEntry(hash uint64, addr uint32) {
+	list := p.collisions[hash]
+	if p.contentMap[hash] == addr {
+		if len(list) == 0 {
+			delete(p.contentMap, hash)
+			return
+		}
+		p.contentMap[hash] = list[len(list)-1]
+		p.removeCollision(hash, len(list)-1)
+		return
+	}
 
-	copy(p.getBytes(keyAddr, keyLen), key)
+	for i := range list {
+		if list[i] == addr {
+			p.removeCollision(hash, i)
+			return
+		}
+	}
+}
 
-	return true
+func (p *Partition) replaceEntryAddr(hash uint64, oldAddr uint32, newAddr uint32) {
+	if p.contentMap[hash] == oldAddr {
+		p.contentMap[hash] = newAddr
+		return
+	}
+
+	list := p.collisions[hash]
+	for i := range list {
+		if list[i] == oldAddr {
+			list[i] = newAddr
+			return
+		}
+	}
 }
 
-func (p *Partition) evict() {
-	if p.admission.Size() == 0 && p.probation.Size() == 0 {
+// allocateEntry allocates and links a new entry, evicting other entries until it fits.
+// The new entry is NOT in any LRU list yet
+func (p *Partition) allocateEntry(hash uint64, key []byte, size uint32) (uint32, error) {
+	if size > p.allocator.GetMaxSize() || len(key) > math.MaxUint16 {
+		return 0, ErrValueTooLarge
+	}
+
+	for {
+		addr, ok := p.allocator.Allocate(size)
+		if ok {
+			header := p.getHeader(addr)
+			*header = entryHeader{
+				size:      size,
+				keySize:   uint16(len(key)),
+				hash:      hash,
+				lruAddr:   nullAddr,
+				timerAddr: nullAddr,
+			}
+
+			keyAddr := addr + uint32(unsafe.Sizeof(entryHeader{}))
+			copy(p.getBytes(keyAddr, uint32(header.keySize)), key)
+
+			p.linkEntry(hash, addr)
+			return addr, nil
+		}
+
+		if p.rebalanceSlab(size) {
+			continue
+		}
+		if !p.evictForSize(size) {
+			return 0, ErrNoSpace
+		}
+	}
+}
+
+// hasTTL returns true if the entry must be freed by the timing wheel
+func (h *entryHeader) hasTTL() bool {
+	return h.status == entryStatusValid && h.expire != 0
+}
+
+func (p *Partition) toTimerTick(expire int64) uint64 {
+	// round up, the entry must NOT be freed before its expire time
+	return (uint64(expire) + p.timerTick - 1) / p.timerTick
+}
+
+// putEntryNodes adds the timing wheel node (if the entry has TTL) and the LRU list head of the entry,
+// evicting other entries until they can be allocated. The entry is removed if there is not enough space
+func (p *Partition) putEntryNodes(addr uint32, lruList lruListType) error {
+	// the entry can be moved by evictions
+	p.pin(&addr)
+	defer p.unpin()
+
+	header := p.getHeader(addr)
+	hash := header.hash
+
+	if header.hasTTL() {
+		expireTick := p.toTimerTick(header.expire)
+		for {
+			timerAddr, ok := p.timer.Add(hash, expireTick)
+			if ok {
+				p.getHeader(addr).timerAddr = timerAddr
+				break
+			}
+
+			if !p.evict() {
+				p.removeEntry(addr)
+				return ErrNoSpace
+			}
+		}
+	}
+
+	// the evictions above can relocate the entry, the header must be fetched again
+	weight := p.entryWeight(p.getHeader(addr))
+	p.makeRoom(lruList, weight)
+
+	for {
+		lruAddr, ok := p.getLRU(lruList).Put(hash, weight)
+		if ok {
+			header := p.getHeader(addr)
+			header.lruAddr = lruAddr
+			header.lruList = lruList
+			return nil
+		}
+
+		if !p.evict() {
+			p.removeEntry(addr)
+			return ErrNoSpace
+		}
+	}
+}
+
+// putNewEntry adds a new entry to the admission list
+func (p *Partition) putNewEntry(
+	hash uint64, key []byte, value []byte, status entryStatus, leaseID uint64, expire int64,
+) error {
+	size := uint32(unsafe.Sizeof(entryHeader{})) + uint32(len(key)) + uint32(len(value))
+
+	if size <= p.allocator.GetMaxSize() {
+		// move the overflow to probation before allocating, they are the candidates for evictions
+		p.makeRoom(lruListAdmission, p.allocator.GetSlabSize(size))
+	}
+
+	addr, err := p.allocateEntry(hash, key, size)
+	if err != nil {
+		return err
+	}
+
+	header := p.getHeader(addr)
+	header.leaseID = leaseID
+	header.status = status
+	header.expire = expire
+
+	valueAddr := addr + uint32(unsafe.Sizeof(entryHeader{})) + uint32(len(key))
+	copy(p.getBytes(valueAddr, uint32(len(value))), value)
+
+	return p.putEntryNodes(addr, lruListAdmission)
+}
+
+func (p *Partition) putLease(hash uint64, key []byte, leaseID uint64, expire int64) error {
+	return p.putNewEntry(hash, key, nil, entryStatusLeasing, leaseID, expire)
+}
+
+// entryWeight is the number of bytes used by the entry
+func (p *Partition) entryWeight(header *entryHeader) uint32 {
+	return p.allocator.GetSlabSize(header.size)
+}
+
+func (p *Partition) numEntries() uint32 {
+	return p.admission.Size() + p.protected.Size() + p.probation.Size()
+}
+
+func (p *Partition) totalWeight() uint32 {
+	return p.admission.Weight() + p.protected.Weight() + p.probation.Weight()
+}
+
+// recordAccess feeds the hill climber, the admission limit is adjusted at the end of every sample period.
+// Entries exceeding a shrunk admission limit are moved to probation by the next putNewEntry
+func (p *Partition) recordAccess(hit bool) {
+	p.climber.record(hit)
+	if hit {
+		p.counters.Hits++
+	} else {
+		p.counters.Misses++
+	}
+
+	if !p.climber.sampleCompleted(p.numEntries()) {
 		return
 	}
 
-	var lastAddr uint32
-	if p.probation.Size() == 0 {
-		admissionAddr, admissionHash := p.admission.Last()
+	totalWeight := p.totalWeight()
+	oldLimit := int64(p.admission.Limit())
+	limit := oldLimit + p.climber.climb(totalWeight)
 
-		p.admission.Delete(admissionAddr)
-		lastAddr = p.contentMap[admissionHash]
-		delete(p.contentMap, admissionHash)
-	} else if p.admission.Size() == 0 {
-		probationAddr, probationHash := p.probation.Last()
+	// the window can only grow up to the whole cache
+	maxLimit := int64(totalWeight)
+	if maxLimit < oldLimit {
+		maxLimit = oldLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+	if limit < 1 {
+		limit = 1
+	}
+	p.admission.UpdateLimit(uint32(limit))
+}
 
-		p.probation.Delete(probationAddr)
-		lastAddr = p.contentMap[probationHash]
-		delete(p.contentMap, probationHash)
+func (p *Partition) updateProtectedLimit() {
+	mainWeight := p.protected.Weight() + p.probation.Weight()
+	limit := p.protectedRatio.MulUint32(mainWeight)
+	if limit < p.minProtectedLimit {
+		limit = p.minProtectedLimit
+	}
+	p.protected.UpdateLimit(limit)
+}
+
+func (p *Partition) moveToList(addr uint32, to lruListType) {
+	header := p.getHeader(addr)
+	weight := p.entryWeight(header)
+
+	p.getLRU(header.lruList).Delete(header.lruAddr, weight)
+
+	// Can NOT be false here, the deleted list head is reused and the room is made by makeRoom
+	lruAddr, ok := p.getLRU(to).Put(header.hash, weight)
+	assertTrue(ok)
+
+	header.lruAddr = lruAddr
+	header.lruList = to
+}
+
+// makeRoom moves the entries at the end of admission or protected to probation, until *weight* fits into the list
+func (p *Partition) makeRoom(lruList lruListType, weight uint32) {
+	var l *lru.LRU
+	switch lruList {
+	case lruListAdmission:
+		l = p.admission
+	case lruListProtected:
+		p.updateProtectedLimit()
+		l = p.protected
+	default:
+		return
+	}
+
+	for l.Size() > 0 && uint64(l.Weight())+uint64(weight) > uint64(l.Limit()) {
+		lastAddr, lastHash := l.Last()
+		p.moveToList(p.findEntryByLRU(lastHash, lastAddr), lruListProbation)
+	}
+}
+
+// touch updates the LRU lists on a hit.
+// Entries in probation are promoted to protected, protected entries are demoted if protected overflows
+func (p *Partition) touch(addr uint32) {
+	header := p.getHeader(addr)
+	switch header.lruList {
+	case lruListAdmission:
+		p.admission.Touch(header.lruAddr)
+
+	case lruListProtected:
+		p.protected.Touch(header.lruAddr)
+
+	default:
+		p.makeRoom(lruListProtected, p.entryWeight(header))
+		p.moveToList(addr, lruListProtected)
+	}
+}
+
+func (p *Partition) evictLRUEntry(lruAddr uint32, hash uint64) {
+	addr := p.findEntryByLRU(hash, lruAddr)
+	p.counters.recordEviction(p.getHeader(addr).lruList)
+	p.removeEntry(addr)
+}
+
+func (p *Partition) evictLast(l *lru.LRU) {
+	lruAddr, hash := l.Last()
+	p.evictLRUEntry(lruAddr, hash)
+}
+
+// evict removes the victim entry, returns false if there is no entry to evict
+func (p *Partition) evict() bool {
+	if p.admission.Size() == 0 && p.probation.Size() == 0 {
+		if p.protected.Size() == 0 {
+			return false
+		}
+		p.evictLast(p.protected)
+		return true
+	}
+
+	if p.probation.Size() == 0 {
+		p.evictLast(p.admission)
+	} else if p.admission.Size() == 0 {
+		p.evictLast(p.probation)
 	} else {
-		admissionAddr, admissionHash := p.admission.Last()
-		probationAddr, probationHash := p.probation.Last()
+		p.evictCandidateOrVictims()
+	}
+	return true
+}
+
+// evictScanLimit is the number of entries from the end of a LRU list to look for victims
+const evictScanLimit = 8
+
+type lruNode struct {
+	addr uint32
+	hash uint64
+}
+
+// evictCandidateOrVictims compares the candidate at the end of admission with the entries at the end of probation
+// that it would displace, until their total weight reaches the weight of the candidate.
+// The candidate is admitted only if its frequency is higher than the total frequency of those victims
+func (p *Partition) evictCandidateOrVictims() {
+	candidateAddr, candidateHash := p.admission.Last()
+	candidateWeight := p.entryWeight(p.getHeader(p.findEntryByLRU(candidateHash, candidateAddr)))
+
+	var victims [evictScanLimit]lruNode
+	numVictims := 0
+	victimWeight := uint32(0)
+	victimFreq := uint32(0)
 
+	lruAddr, hash := p.probation.Last()
+	for numVictims < evictScanLimit {
+		victims[numVictims] = lruNode{addr: lruAddr, hash: hash}
+		numVictims++
+
+		victimWeight += p.entryWeight(p.getHeader(p.findEntryByLRU(hash, lruAddr)))
+		victimFreq += p.sketch.Frequency(hash)
+		if victimWeight >= candidateWeight {
+			break
+		}
+
+		var ok bool
+		lruAddr, hash, ok = p.probation.Prev(lruAddr)
+		if !ok {
+			break
+		}
+	}
+
+	if p.sketch.Frequency(candidateHash) <= victimFreq {
+		p.counters.AdmissionRejections++
+		p.evictLRUEntry(candidateAddr, candidateHash)
+		return
+	}
+	for _, victim := range victims[:numVictims] {
+		p.evictLRUEntry(victim.addr, victim.hash)
+	}
+}
+
+// findSameSlabVictim finds the nearest entry to the end of the list that has the slab size equal to *slabSize*
+func (p *Partition) findSameSlabVictim(l *lru.LRU, slabSize uint32) (uint32, uint64, bool) {
+	if l.Size() == 0 {
+		return 0, 0, false
+	}
+
+	lruAddr, hash := l.Last()
+	for i := 0; i < evictScanLimit; i++ {
+		header := p.getHeader(p.findEntryByLRU(hash, lruAddr))
+		if p.allocator.GetSlabSize(header.size) == slabSize {
+			return lruAddr, hash, true
+		}
+
+		var ok bool
+		lruAddr, hash, ok = l.Prev(lruAddr)
+		if !ok {
+			break
+		}
+	}
+	return 0, 0, false
+}
+
+// evictForSize prefers evicting entries in the same slab as *size*, the freed slot can be reused immediately.
+// Returns false if there is no entry to evict
+func (p *Partition) evictForSize(size uint32) bool {
+	slabSize := p.allocator.GetSlabSize(size)
+
+	admissionAddr, admissionHash, admissionOk := p.findSameSlabVictim(p.admission, slabSize)
+	probationAddr, probationHash, probationOk := p.findSameSlabVictim(p.probation, slabSize)
+
+	if admissionOk && probationOk {
 		if p.sketch.Frequency(admissionHash) <= p.sketch.Frequency(probationHash) {
-			p.admission.Delete(admissionAddr)
-			lastAddr = p.contentMap[admissionHash]
-			delete(p.contentMap, admissionHash)
+			p.counters.AdmissionRejections++
+			p.evictLRUEntry(admissionAddr, admissionHash)
 		} else {
-			p.probation.Delete(probationAddr)
-			lastAddr = p.contentMap[probationHash]
-			delete(p.contentMap, probationHash)
+			p.evictLRUEntry(probationAddr, probationHash)
 		}
+		return true
 	}
 
-	header := (*entryHeader)(p.allocator.ToRealAddr(lastAddr))
-	size := header.size
+	if admissionOk {
+		p.evictLRUEntry(admissionAddr, admissionHash)
+		return true
+	}
+	if probationOk {
+		p.evictLRUEntry(probationAddr, probationHash)
+		return true
+	}
+
+	return p.evict()
+}
 
-	_, needMove := p.allocator.Deallocate(lastAddr, size)
-	if needMove {
-		p.contentMap[header.hash] = lastAddr
+// rebalanceSlab records the allocation failure of the slab class of *size*,
+// then moves a chunk from a donor class if SlabAutomove is enabled. Returns false if no chunk was moved
+func (p *Partition) rebalanceSlab(size uint32) bool {
+	slabIndex, ok := p.allocator.GetSlabIndex(size)
+	if !ok {
+		return false
 	}
+	p.rebalancer.recordFailure(slabIndex)
+
+	if !p.slabAutomove {
+		return false
+	}
+
+	// the chunk of the donor must be at least as large as the chunk of the receiver,
+	// a smaller freed chunk only makes room for the receiver if its buddy block happens to be free too
+	chunkSizeLog := p.allocator.GetSlab(slabIndex).GetChunkSizeLog()
+	donor, ok := p.rebalancer.findDonor(slabIndex, func(index int) bool {
+		slab := p.allocator.GetSlab(index)
+		return slab.GetNumChunks() > 0 && slab.GetChunkSizeLog() >= chunkSizeLog
+	})
+	if !ok {
+		return false
+	}
+	if !p.evacuateSlabChunk(donor) {
+		return false
+	}
+	p.counters.SlabMoves++
+	return true
 }
 
-func (p *Partition) putValue(hash uint64, key []byte, version uint64, value []byte) bool {
-	entryAddr := p.contentMap[hash]
-	header := (*entryHeader)(p.allocator.ToRealAddr(entryAddr))
-	header.status = entryStatusValid
-	header.leaseID = version
+// slabEvacuateScanFactor bounds the number of entries scanned for evacuating a chunk,
+// as a multiple of the number of victims needed
+const slabEvacuateScanFactor = 4
 
-	newSize := uint32(unsafe.Sizeof(entryHeader{})) + uint32(len(key)) + uint32(len(value))
+// evacuateSlabChunk evicts the least recently used entries of the slab class *slabIndex*,
+// until the class puts a chunk back to the buddy. The entries of a class are scattered in the lists,
+// nothing is evicted and false is returned if NOT enough victims are found within the scan limit
+func (p *Partition) evacuateSlabChunk(slabIndex int) bool {
+	limit := int(p.allocator.GetSlab(slabIndex).NumElemsToFreeChunk())
+	scanLimit := slabEvacuateScanFactor * limit
+
+	victims := make([]lruNode, 0, limit)
+	victims, scanLimit = p.collectSlabVictims(p.probation, slabIndex, victims, limit, scanLimit)
+	victims, scanLimit = p.collectSlabVictims(p.admission, slabIndex, victims, limit, scanLimit)
+	victims, _ = p.collectSlabVictims(p.protected, slabIndex, victims, limit, scanLimit)
+	if len(victims) < limit {
+		return false
+	}
 
-	if p.allocator.GetSlabSize(header.size) != p.allocator.GetSlabSize(newSize) {
-		oldSize := header.size
+	for _, victim := range victims {
+		p.evictLRUEntry(victim.addr, victim.hash)
+	}
+	return true
+}
 
-		newAddr, ok := p.allocator.Allocate(newSize)
+// collectSlabVictims appends the entries of the slab class *slabIndex*, from the end of the list, up to *limit* victims.
+// At most *scanLimit* entries are scanned, returns the remaining scan limit
+func (p *Partition) collectSlabVictims(
+	l *lru.LRU, slabIndex int, victims []lruNode, limit int, scanLimit int,
+) ([]lruNode, int) {
+	if l.Size() == 0 {
+		return victims, scanLimit
+	}
+
+	lruAddr, hash := l.Last()
+	for len(victims) < limit && scanLimit > 0 {
+		scanLimit--
+		header := p.getHeader(p.findEntryByLRU(hash, lruAddr))
+		if index, ok := p.allocator.GetSlabIndex(header.size); ok && index == slabIndex {
+			victims = append(victims, lruNode{addr: lruAddr, hash: hash})
+		}
+
+		var ok bool
+		lruAddr, hash, ok = l.Prev(lruAddr)
 		if !ok {
-			// TODO loop until enough space
-			return false
+			break
 		}
-		newHeader := (*entryHeader)(p.allocator.ToRealAddr(newAddr))
-		*newHeader = *header
-		header = newHeader
+	}
+	return victims, scanLimit
+}
 
-		p.contentMap[hash] = newAddr
+func (p *Partition) deallocateEntry(addr uint32) {
+	p.allocator.Deallocate(addr, p.getHeader(addr).size)
+}
 
-		keyAddr := newAddr + uint32(unsafe.Sizeof(entryHeader{}))
-		keyLen := uint32(len(key))
-		copy(p.getBytes(keyAddr, keyLen), key)
+// removeEntry removes the entry from the LRU list, the timing wheel and the content map, then frees it
+func (p *Partition) removeEntry(addr uint32) {
+	header := p.getHeader(addr)
+	if header.lruAddr != nullAddr {
+		p.getLRU(header.lruList).Delete(header.lruAddr, p.entryWeight(header))
+	}
+	if header.timerAddr != nullAddr {
+		p.timer.Delete(header.timerAddr)
+	}
+	p.unlinkEntry(header.hash, addr)
+	p.deallocateEntry(addr)
+}
 
-		valueAddr := keyAddr + keyLen
-		valueLen := uint32(len(value))
-		copy(p.getBytes(valueAddr, valueLen), value)
+// expireEntry is called by the timing wheel, the node is already freed
+func (p *Partition) expireEntry(timerAddr uint32, hash uint64) {
+	addr := p.findEntryByTimer(hash, timerAddr)
+	p.getHeader(addr).timerAddr = nullAddr
+	p.counters.Expirations++
+	p.removeEntry(addr)
+}
 
-		p.allocator.Deallocate(entryAddr, oldSize)
-		// TODO need move
-	} else {
-		valueAddr := entryAddr + uint32(unsafe.Sizeof(entryHeader{})) + uint32(len(key))
-		valueLen := uint32(len(value))
-		valueBytes := p.getBytes(valueAddr, valueLen)
-		copy(valueBytes, value)
+// advance reads the clock and frees the entries expired in the passed timer ticks.
+// Returns the current time in unix nanoseconds
+func (p *Partition) advance() int64 {
+	now := p.clock.Now().UnixNano()
+	p.timer.Advance(uint64(now) / p.timerTick)
+	return now
+}
+
+func computeExpire(now int64, ttl time.Duration) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+	return now + int64(ttl)
+}
+
+// findLiveEntry is findEntry with lazy expiration, the expired entry is removed
+func (p *Partition) findLiveEntry(hash uint64, key []byte, now int64) (uint32, bool) {
+	addr, ok := p.findEntry(hash, key)
+	if !ok {
+		return 0, false
+	}
+
+	header := p.getHeader(addr)
+	if header.hasTTL() && header.expire <= now {
+		p.counters.Expirations++
+		p.removeEntry(addr)
+		return 0, false
+	}
+	return addr, true
+}
+
+// resetTimer replaces the timing wheel node of the entry by a node for *expire*.
+// Returns false if the node can NOT be allocated, the entry has no node in that case
+func (p *Partition) resetTimer(addr uint32, expire int64) bool {
+	header := p.getHeader(addr)
+	if header.timerAddr != nullAddr {
+		p.timer.Delete(header.timerAddr)
+		header.timerAddr = nullAddr
+	}
+	if expire == 0 {
+		return true
+	}
+
+	timerAddr, ok := p.timer.Add(header.hash, p.toTimerTick(expire))
+	if !ok {
+		return false
+	}
+	header.timerAddr = timerAddr
+	return true
+}
+
+// relocate is called by the allocator after an entry had been moved from *from* to *to*.
+// The LRU list heads only keep the hash, the entry of a list head is found by the hash and lruAddr
+func (p *Partition) relocate(from uint32, to uint32) {
+	header := p.getHeader(to)
+	p.replaceEntryAddr(header.hash, from, to)
+
+	for _, addr := range p.pinned {
+		if *addr == from {
+			*addr = to
+		}
+	}
+}
+
+// pin keeps *addr* pointing to the entry when the entry is relocated, until unpin is called
+func (p *Partition) pin(addr *uint32) {
+	p.pinned = append(p.pinned, addr)
+}
+
+func (p *Partition) unpin() {
+	p.pinned = p.pinned[:len(p.pinned)-1]
+}
+
+func (p *Partition) getLRU(lruList lruListType) *lru.LRU {
+	switch lruList {
+	case lruListAdmission:
+		return p.admission
+	case lruListProtected:
+		return p.protected
+	default:
+		return p.probation
 	}
+}
+
+func (p *Partition) remove(hash uint64, key []byte) bool {
+	return p.removeIf(hash, key, nil)
+}
 
-	header.size = newSize
+// removeIf removes the entry if *cond* is nil or returns true, see Cache.DeleteIf
+func (p *Partition) removeIf(hash uint64, key []byte, cond CondFunc) bool {
+	now := p.advance()
+
+	addr, ok := p.findLiveEntry(hash, key, now)
+	if !ok {
+		return false
+	}
+	if cond != nil && !cond(p.validValue(addr)) {
+		return false
+	}
 
+	p.removeEntry(addr)
 	return true
 }
 
+// validValue returns the value of the entry, or false if the entry is NOT valid
+func (p *Partition) validValue(addr uint32) ([]byte, bool) {
+	result := p.getEntry(addr)
+	if result.status != entryStatusValid {
+		return nil, false
+	}
+	return result.value, true
+}
+
+// putValue stores the value to the existing entry, *expire* = 0 means no TTL
+func (p *Partition) putValue(hash uint64, key []byte, version uint64, value []byte, expire int64) error {
+	entryAddr, _ := p.findEntry(hash, key)
+	header := p.getHeader(entryAddr)
+
+	newSize := uint32(unsafe.Sizeof(entryHeader{})) + uint32(len(key)) + uint32(len(value))
+
+	sameSlab := newSize <= p.allocator.GetMaxSize() &&
+		p.allocator.GetSlabSize(header.size) == p.allocator.GetSlabSize(newSize)
+
+	if sameSlab && p.resetTimer(entryAddr, expire) {
+		header.status = entryStatusValid
+		header.leaseID = version
+		header.expire = expire
+		header.size = newSize
+
+		valueAddr := entryAddr + uint32(unsafe.Sizeof(entryHeader{})) + uint32(len(key))
+		copy(p.getBytes(valueAddr, uint32(len(value))), value)
+		return nil
+	}
+
+	// remove the old entry before allocating, the evictions can move or evict it
+	lruList := header.lruList
+	p.removeEntry(entryAddr)
+
+	newAddr, err := p.allocateEntry(hash, key, newSize)
+	if err != nil {
+		return err
+	}
+
+	header = p.getHeader(newAddr)
+	header.status = entryStatusValid
+	header.leaseID = version
+	header.expire = expire
+
+	valueAddr := newAddr + uint32(unsafe.Sizeof(entryHeader{})) + uint32(len(key))
+	copy(p.getBytes(valueAddr, uint32(len(value))), value)
+
+	return p.putEntryNodes(newAddr, lruList)
+}
+
 type getResult struct {
 	status  entryStatus
 	lruList lruListType
@@ -276,15 +987,11 @@ type getResult struct {
 	value   []byte
 }
 
-func (p *Partition) get(hash uint64) (getResult, bool) {
-	addr, ok := p.contentMap[hash]
-	if !ok {
-		return getResult{}, false
-	}
-	header := (*entryHeader)(p.allocator.ToRealAddr(addr))
+func (p *Partition) getEntry(addr uint32) getResult {
+	header := p.getHeader(addr)
 
 	keyAddr := addr + uint32(unsafe.Sizeof(entryHeader{}))
-	keyLen := header.keySize
+	keyLen := uint32(header.keySize)
 
 	valueAddr := keyAddr + keyLen
 	valueLen := header.size - uint32(unsafe.Sizeof(entryHeader{})) - keyLen
@@ -296,45 +1003,192 @@ func (p *Partition) get(hash uint64) (getResult, bool) {
 		leaseID: header.leaseID,
 		key:     p.getBytes(keyAddr, keyLen),
 		value:   p.getBytes(valueAddr, valueLen),
-	}, true
+	}
 }
 
-func (p *Partition) leaseGet(hash uint64, key []byte) LeaseGetResult {
+func (p *Partition) get(hash uint64, key []byte) (getResult, bool) {
+	addr, ok := p.findEntry(hash, key)
+	if !ok {
+		return getResult{}, false
+	}
+	return p.getEntry(addr), true
+}
+
+func (p *Partition) leaseGet(hash uint64, key []byte) (LeaseGetResult, error) {
 	p.sketch.Increase(hash)
 
-	result, existed := p.get(hash)
+	now := p.advance()
+	leaseExpire := now + int64(p.leaseTimeout)
+
+	addr, existed := p.findLiveEntry(hash, key, now)
 	if !existed {
+		p.recordAccess(false)
 		p.leaseIDSeq++
 
-		// Must NOT be false
-		ok := p.putLease(hash, key, p.leaseIDSeq)
-		assertTrue(ok)
+		err := p.putLease(hash, key, p.leaseIDSeq, leaseExpire)
+		if err != nil {
+			return LeaseGetResult{}, err
+		}
+		p.counters.LeaseGranted++
 
 		return LeaseGetResult{
 			Status:  LeaseGetStatusLeaseGranted,
 			LeaseID: p.leaseIDSeq,
-		}
+		}, nil
 	}
 
-	if !bytes.Equal(result.key, key) {
-		// TODO hash equals but key not equals
-		return LeaseGetResult{}
+	p.touch(addr)
+
+	result := p.getEntry(addr)
+	p.recordAccess(result.status == entryStatusValid)
+
+	if result.status == entryStatusValid {
+		return LeaseGetResult{
+			Status: LeaseGetStatusExisted,
+			Value:  result.value,
+		}, nil
 	}
 
-	if result.status == entryStatusLeasing {
+	// the stale value of an invalidated entry is returned together with the lease
+	var staleValue []byte
+	stale := result.status == entryStatusInvalid
+	if stale {
+		staleValue = result.value
+	}
+
+	header := p.getHeader(addr)
+	if header.leaseID != 0 && header.expire > now {
+		p.counters.LeaseRejected++
 		return LeaseGetResult{
 			Status: LeaseGetStatusLeaseRejected,
-		}
+			Value:  staleValue,
+			Stale:  stale,
+		}, nil
 	}
 
+	// no lease is outstanding or the previous lease is abandoned, grant a new one
+	p.leaseIDSeq++
+	header.leaseID = p.leaseIDSeq
+	header.expire = leaseExpire
+	p.counters.LeaseGranted++
+
 	return LeaseGetResult{
-		Status: LeaseGetStatusExisted,
-		Value:  result.value,
+		Status:  LeaseGetStatusLeaseGranted,
+		LeaseID: p.leaseIDSeq,
+		Value:   staleValue,
+		Stale:   stale,
+	}, nil
+}
+
+func (p *Partition) getValue(hash uint64, key []byte) ([]byte, bool) {
+	p.sketch.Increase(hash)
+
+	now := p.advance()
+
+	addr, existed := p.findLiveEntry(hash, key, now)
+	if !existed {
+		p.recordAccess(false)
+		return nil, false
+	}
+	p.touch(addr)
+
+	result := p.getEntry(addr)
+	p.recordAccess(result.status == entryStatusValid)
+
+	if result.status != entryStatusValid {
+		return nil, false
 	}
+	return result.value, true
 }
 
-func (p *Partition) leaseSet(hash uint64, key []byte, leaseID uint64, version uint64, value []byte) {
-	// TODO Must Not be false
-	ok := p.putValue(hash, key, version, value)
-	assertTrue(ok)
+// leaseSet returns ErrLeaseMismatch if the lease is no longer held by the caller.
+// Returns ErrNoSpace or ErrValueTooLarge if the value can NOT fit, the entry is removed in that case
+func (p *Partition) leaseSet(
+	hash uint64, key []byte, leaseID uint64, version uint64, value []byte, ttl time.Duration,
+) (LeaseSetStatus, error) {
+	now := p.advance()
+
+	addr, existed := p.findLiveEntry(hash, key, now)
+	if !existed {
+		return LeaseSetStatusEntryGone, ErrLeaseMismatch
+	}
+
+	header := p.getHeader(addr)
+	if header.status == entryStatusValid || header.leaseID == 0 || header.leaseID != leaseID {
+		return LeaseSetStatusLeaseMismatch, ErrLeaseMismatch
+	}
+
+	err := p.putValue(hash, key, version, value, computeExpire(now, ttl))
+	if err != nil {
+		return LeaseSetStatusEntryGone, err
+	}
+	return LeaseSetStatusAccepted, nil
+}
+
+// set stores the value without a lease, outstanding leases are revoked.
+// Returns ErrNoSpace or ErrValueTooLarge if the value can NOT fit, the old value is removed in that case
+func (p *Partition) set(hash uint64, key []byte, value []byte, ttl time.Duration) error {
+	now := p.advance()
+	expire := computeExpire(now, ttl)
+
+	_, existed := p.findLiveEntry(hash, key, now)
+	if existed {
+		return p.putValue(hash, key, 0, value, expire)
+	}
+	return p.putNewEntry(hash, key, value, entryStatusValid, 0, expire)
+}
+
+// invalidate marks the entry as invalid but keeps its value as a stale value,
+// outstanding leases are revoked so that in-flight loaders can NOT write back.
+// The timing wheel still frees the stale value when its TTL passed
+func (p *Partition) invalidate(hash uint64, key []byte) bool {
+	return p.invalidateIf(hash, key, nil)
+}
+
+// invalidateIf invalidates the entry if *cond* is nil or returns true, see Cache.InvalidateIf
+func (p *Partition) invalidateIf(hash uint64, key []byte, cond CondFunc) bool {
+	now := p.advance()
+
+	addr, existed := p.findLiveEntry(hash, key, now)
+	if !existed {
+		return false
+	}
+	if cond != nil && !cond(p.validValue(addr)) {
+		return false
+	}
+
+	header := p.getHeader(addr)
+	if header.status == entryStatusValid {
+		header.status = entryStatusInvalid
+	}
+	header.leaseID = 0
+	header.expire = 0
+
+	return true
+}
+
+// releaseLease gives up the lease without setting a value, the next LeaseGet is granted a new lease.
+// The leasing entry is removed, the invalidated entry keeps its stale value.
+// Returns false if the lease is no longer held by the caller
+func (p *Partition) releaseLease(hash uint64, key []byte, leaseID uint64) bool {
+	now := p.advance()
+
+	addr, existed := p.findLiveEntry(hash, key, now)
+	if !existed {
+		return false
+	}
+
+	header := p.getHeader(addr)
+	if header.status == entryStatusValid || header.leaseID == 0 || header.leaseID != leaseID {
+		return false
+	}
+
+	if header.status == entryStatusLeasing {
+		p.removeEntry(addr)
+		return true
+	}
+
+	header.leaseID = 0
+	header.expire = 0
+	return true
 }
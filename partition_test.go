@@ -1,16 +1,21 @@
 package espresso
 
 import (
+	"errors"
+	"fmt"
 	"github.com/QuangTung97/espresso/allocator"
 	"github.com/QuangTung97/espresso/lru"
 	"github.com/stretchr/testify/assert"
 	"math"
+	"math/rand"
+	"strings"
 	"testing"
+	"time"
 	"unsafe"
 )
 
 func TestSizeOfEntryHeader(t *testing.T) {
-	assert.Equal(t, uintptr(32), unsafe.Sizeof(entryHeader{}))
+	assert.Equal(t, uintptr(40), unsafe.Sizeof(entryHeader{}))
 }
 
 func TestValidatePartitionConfig(t *testing.T) {
@@ -69,18 +74,44 @@ func TestValidatePartitionConfig(t *testing.T) {
 
 	for _, e := range table {
 		t.Run(e.name, func(t *testing.T) {
-			defer func() {
-				if v := recover(); v != nil {
-					assert.Equal(t, e.expected, v.(string))
-				} else {
-					assert.Fail(t, "must panic")
-				}
-			}()
-			validatePartitionConfig(e.conf)
+			err := validatePartitionConfig(e.conf)
+			assert.True(t, errors.Is(err, ErrInvalidConfig))
+			assert.Equal(t, e.expected, err.(*ConfigError).Message)
+			assert.Equal(t, strings.Fields(e.expected)[0], err.(*ConfigError).Field)
 		})
 	}
 }
 
+func TestTryNewPartition(t *testing.T) {
+	conf := newEvictTestPartitionConfig()
+	conf.LeaseTimeout = -1
+
+	p, err := TryNewPartition(conf)
+	assert.Nil(t, p)
+	assert.Equal(t, "invalid config: LeaseTimeout must >= 0", err.Error())
+
+	conf = newEvictTestPartitionConfig()
+	conf.AllocatorConfig.MemLimit = 0
+
+	p, err = TryNewPartition(conf)
+	assert.Nil(t, p)
+	assert.True(t, errors.Is(err, ErrInvalidConfig))
+	assert.Equal(t, "MemLimit", err.(*ConfigError).Field)
+
+	p, err = TryNewPartition(newEvictTestPartitionConfig())
+	assert.Equal(t, nil, err)
+	assert.NotNil(t, p)
+}
+
+func TestNewPartition_Panic(t *testing.T) {
+	conf := newEvictTestPartitionConfig()
+	conf.NumCounters = 0
+
+	assert.PanicsWithError(t, "invalid config: NumCounters must > 0", func() {
+		NewPartition(conf)
+	})
+}
+
 func TestNewPartition(t *testing.T) {
 	conf := PartitionConfig{
 		InitAdmissionLimit: 123,
@@ -108,6 +139,7 @@ func TestNewPartition(t *testing.T) {
 	p := NewPartition(conf)
 	assert.NotNil(t, p.allocator)
 	assert.NotNil(t, p.contentMap)
+	assert.NotNil(t, p.collisions)
 	assert.NotNil(t, p.sketch)
 
 	assert.NotNil(t, p.admission)
@@ -118,15 +150,18 @@ func TestNewPartition(t *testing.T) {
 
 	assert.NotNil(t, p.probation)
 	assert.Equal(t, uint32(math.MaxUint32), p.probation.Limit())
+
+	assert.Equal(t, DefaultLeaseTimeout, p.leaseTimeout)
+	assert.Equal(t, SystemClock, p.clock)
 }
 
 var lruEntrySize = uint32(unsafe.Sizeof(lru.ListHead{}))
 
 func TestPartition_PutLease(t *testing.T) {
 	conf := PartitionConfig{
-		InitAdmissionLimit: 3,
+		InitAdmissionLimit: 3 * 96,
 		ProtectedRatio:     NewRational(80, 100),
-		MinProtectedLimit:  50,
+		MinProtectedLimit:  50 * 96,
 		NumCounters:        100,
 		SketchMinCacheSize: 10,
 		AllocatorConfig: allocator.Config{
@@ -143,15 +178,15 @@ func TestPartition_PutLease(t *testing.T) {
 
 	p := NewPartition(conf)
 
-	ok := p.putLease(1100, []byte{1, 2, 3}, 11)
-	assert.True(t, ok)
+	err := p.putLease(1100, []byte{1, 2, 3}, 11, 0)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, []uint64{1100}, p.admission.GetLRUList())
 	contentMap := map[uint64]uint32{
-		1100: 1 << 12,
+		1100: 0,
 	}
 	assert.Equal(t, contentMap, p.contentMap)
 
-	result, ok := p.get(1100)
+	result, ok := p.get(1100, []byte{1, 2, 3})
 	assert.True(t, ok)
 
 	assert.Equal(t, entryStatusLeasing, result.status)
@@ -162,40 +197,40 @@ func TestPartition_PutLease(t *testing.T) {
 	assert.Equal(t, []byte{1, 2, 3}, result.key)
 	assert.Equal(t, []byte{}, result.value)
 
-	ok = p.putLease(2200, []byte{5, 6, 7}, 22)
-	assert.True(t, ok)
+	err = p.putLease(2200, []byte{5, 6, 7}, 22, 0)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, []uint64{2200, 1100}, p.admission.GetLRUList())
 	contentMap = map[uint64]uint32{
-		1100: 1 << 12,
-		2200: 1<<12 + 96,
+		1100: 0,
+		2200: 96,
 	}
 	assert.Equal(t, contentMap, p.contentMap)
 
-	ok = p.putLease(3300, []byte{8, 9, 10}, 33)
-	assert.True(t, ok)
+	err = p.putLease(3300, []byte{8, 9, 10}, 33, 0)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, []uint64{3300, 2200, 1100}, p.admission.GetLRUList())
 	contentMap = map[uint64]uint32{
-		1100: 1 << 12,
-		2200: 1<<12 + 96,
-		3300: 1<<12 + 2*96,
+		1100: 0,
+		2200: 96,
+		3300: 2 * 96,
 	}
 	assert.Equal(t, contentMap, p.contentMap)
 
-	ok = p.putLease(4400, []byte{11, 12, 13}, 44)
-	assert.True(t, ok)
+	err = p.putLease(4400, []byte{11, 12, 13}, 44, 0)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, []uint64{4400, 3300, 2200}, p.admission.GetLRUList())
 	assert.Equal(t, []uint64{1100}, p.probation.GetLRUList())
 
-	result, ok = p.get(1100)
+	result, ok = p.get(1100, []byte{1, 2, 3})
 	assert.True(t, ok)
 	assert.Equal(t, lruListProbation, result.lruList)
 
-	ok = p.putLease(5500, []byte{14, 15, 16}, 55)
-	assert.True(t, ok)
+	err = p.putLease(5500, []byte{14, 15, 16}, 55, 0)
+	assert.Equal(t, nil, err)
 	assert.Equal(t, []uint64{5500, 4400, 3300}, p.admission.GetLRUList())
 	assert.Equal(t, []uint64{2200, 1100}, p.probation.GetLRUList())
 
-	result, ok = p.get(2200)
+	result, ok = p.get(2200, []byte{5, 6, 7})
 	assert.True(t, ok)
 	assert.Equal(t, lruListProbation, result.lruList)
 	assert.Equal(t, entryStatusLeasing, result.status)
@@ -207,9 +242,9 @@ func TestPartition_PutLease(t *testing.T) {
 
 func TestPartition_PutValue(t *testing.T) {
 	conf := PartitionConfig{
-		InitAdmissionLimit: 3,
+		InitAdmissionLimit: 3 * 48,
 		ProtectedRatio:     NewRational(80, 100),
-		MinProtectedLimit:  50,
+		MinProtectedLimit:  50 * 48,
 		NumCounters:        100,
 		SketchMinCacheSize: 10,
 		AllocatorConfig: allocator.Config{
@@ -217,11 +252,11 @@ func TestPartition_PutValue(t *testing.T) {
 			LRUEntrySize: lruEntrySize,
 			Slabs: []allocator.SlabConfig{
 				{
-					ElemSize:     40,
+					ElemSize:     48,
 					ChunkSizeLog: 12,
 				},
 				{
-					ElemSize:     80,
+					ElemSize:     96,
 					ChunkSizeLog: 12,
 				},
 			},
@@ -230,11 +265,11 @@ func TestPartition_PutValue(t *testing.T) {
 
 	p := NewPartition(conf)
 
-	p.putLease(1100, []byte{1, 2, 3}, 11)
-	ok := p.putValue(1100, []byte{1, 2, 3}, 101, []byte{10, 20, 30, 40, 50})
-	assert.True(t, ok)
+	p.putLease(1100, []byte{1, 2, 3}, 11, 0)
+	err := p.putValue(1100, []byte{1, 2, 3}, 101, []byte{10, 20, 30, 40, 50}, 0)
+	assert.Equal(t, nil, err)
 
-	result, ok := p.get(1100)
+	result, ok := p.get(1100, []byte{1, 2, 3})
 	assert.True(t, ok)
 	assert.Equal(t, entryStatusValid, result.status)
 	assert.Equal(t, uint64(1100), result.hash)
@@ -243,11 +278,11 @@ func TestPartition_PutValue(t *testing.T) {
 	assert.Equal(t, []byte{1, 2, 3}, result.key)
 	assert.Equal(t, []byte{10, 20, 30, 40, 50}, result.value)
 
-	p.putLease(2200, []byte{5, 6, 7}, 22)
-	ok = p.putValue(2200, []byte{5, 6, 7}, 202, []byte{80, 90, 70, 20, 10, 5})
-	assert.True(t, ok)
+	p.putLease(2200, []byte{5, 6, 7}, 22, 0)
+	err = p.putValue(2200, []byte{5, 6, 7}, 202, []byte{80, 90, 70, 20, 10, 5}, 0)
+	assert.Equal(t, nil, err)
 
-	result, ok = p.get(2200)
+	result, ok = p.get(2200, []byte{5, 6, 7})
 	assert.True(t, ok)
 	assert.Equal(t, entryStatusValid, result.status)
 	assert.Equal(t, uint64(2200), result.hash)
@@ -259,9 +294,9 @@ func TestPartition_PutValue(t *testing.T) {
 
 func TestPartition_LeaseGet(t *testing.T) {
 	conf := PartitionConfig{
-		InitAdmissionLimit: 3,
+		InitAdmissionLimit: 3 * 48,
 		ProtectedRatio:     NewRational(80, 100),
-		MinProtectedLimit:  50,
+		MinProtectedLimit:  50 * 48,
 		NumCounters:        100,
 		SketchMinCacheSize: 5,
 		AllocatorConfig: allocator.Config{
@@ -269,11 +304,11 @@ func TestPartition_LeaseGet(t *testing.T) {
 			LRUEntrySize: lruEntrySize,
 			Slabs: []allocator.SlabConfig{
 				{
-					ElemSize:     40,
+					ElemSize:     48,
 					ChunkSizeLog: 12,
 				},
 				{
-					ElemSize:     80,
+					ElemSize:     96,
 					ChunkSizeLog: 12,
 				},
 			},
@@ -281,19 +316,20 @@ func TestPartition_LeaseGet(t *testing.T) {
 	}
 	p := NewPartition(conf)
 
-	result := p.leaseGet(1100, []byte{1, 2, 3})
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
 	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
 	assert.Equal(t, uint64(1), result.LeaseID)
 	assert.Equal(t, uint32(1), p.sketch.Frequency(1100))
 
-	result = p.leaseGet(1100, []byte{1, 2, 3})
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
 	assert.Equal(t, LeaseGetStatusLeaseRejected, result.Status)
 	assert.Equal(t, uint64(0), result.LeaseID)
 	assert.Equal(t, uint32(2), p.sketch.Frequency(1100))
 
-	p.leaseSet(1100, []byte{1, 2, 3}, 1, 101, []byte{10, 20, 30})
+	status, _ := p.leaseSet(1100, []byte{1, 2, 3}, 1, 101, []byte{10, 20, 30}, 0)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
 
-	result = p.leaseGet(1100, []byte{1, 2, 3})
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
 	assert.Equal(t, LeaseGetStatusExisted, result.Status)
 	assert.Equal(t, uint64(0), result.LeaseID)
 	assert.Equal(t, uint32(3), p.sketch.Frequency(1100))
@@ -302,9 +338,9 @@ func TestPartition_LeaseGet(t *testing.T) {
 
 func TestPartition_Evict_From_Probation(t *testing.T) {
 	conf := PartitionConfig{
-		InitAdmissionLimit: 3,
+		InitAdmissionLimit: 3 * 48,
 		ProtectedRatio:     NewRational(80, 100),
-		MinProtectedLimit:  50,
+		MinProtectedLimit:  50 * 48,
 		NumCounters:        100,
 		SketchMinCacheSize: 5,
 		AllocatorConfig: allocator.Config{
@@ -312,11 +348,11 @@ func TestPartition_Evict_From_Probation(t *testing.T) {
 			LRUEntrySize: lruEntrySize,
 			Slabs: []allocator.SlabConfig{
 				{
-					ElemSize:     40,
+					ElemSize:     48,
 					ChunkSizeLog: 12,
 				},
 				{
-					ElemSize:     80,
+					ElemSize:     96,
 					ChunkSizeLog: 12,
 				},
 			},
@@ -326,21 +362,21 @@ func TestPartition_Evict_From_Probation(t *testing.T) {
 
 	p.evict()
 
-	ok := p.putLease(1100, []byte{1, 2, 3}, 111)
+	err := p.putLease(1100, []byte{1, 2, 3}, 111, 0)
 	p.sketch.Increase(1100)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
-	ok = p.putLease(2200, []byte{2, 3, 4}, 222)
+	err = p.putLease(2200, []byte{2, 3, 4}, 222, 0)
 	p.sketch.Increase(2200)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
-	ok = p.putLease(3300, []byte{3, 4, 5}, 333)
+	err = p.putLease(3300, []byte{3, 4, 5}, 333, 0)
 	p.sketch.Increase(3300)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
-	ok = p.putLease(4400, []byte{4, 5, 6}, 444)
+	err = p.putLease(4400, []byte{4, 5, 6}, 444, 0)
 	p.sketch.Increase(4400)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
 	assert.Equal(t, []uint64{4400, 3300, 2200}, p.admission.GetLRUList())
 	assert.Equal(t, []uint64{1100}, p.probation.GetLRUList())
@@ -358,18 +394,18 @@ func TestPartition_Evict_From_Probation(t *testing.T) {
 	assert.Equal(t, []uint64{4400, 3300, 2200}, p.admission.GetLRUList())
 	assert.Equal(t, []uint64(nil), p.probation.GetLRUList())
 	content := map[uint64]uint32{
-		2200: 1<<12 + 40,
-		3300: 1<<12 + 2*40,
-		4400: 1 << 12,
+		2200: 48,
+		3300: 2 * 48,
+		4400: 0,
 	}
 	assert.Equal(t, content, p.contentMap)
 }
 
 func TestPartition_Evict_From_Admission(t *testing.T) {
 	conf := PartitionConfig{
-		InitAdmissionLimit: 3,
+		InitAdmissionLimit: 3 * 48,
 		ProtectedRatio:     NewRational(80, 100),
-		MinProtectedLimit:  50,
+		MinProtectedLimit:  50 * 48,
 		NumCounters:        100,
 		SketchMinCacheSize: 5,
 		AllocatorConfig: allocator.Config{
@@ -377,11 +413,11 @@ func TestPartition_Evict_From_Admission(t *testing.T) {
 			LRUEntrySize: lruEntrySize,
 			Slabs: []allocator.SlabConfig{
 				{
-					ElemSize:     40,
+					ElemSize:     48,
 					ChunkSizeLog: 12,
 				},
 				{
-					ElemSize:     80,
+					ElemSize:     96,
 					ChunkSizeLog: 12,
 				},
 			},
@@ -391,21 +427,21 @@ func TestPartition_Evict_From_Admission(t *testing.T) {
 
 	p.evict()
 
-	ok := p.putLease(1100, []byte{1, 2, 3}, 111)
+	err := p.putLease(1100, []byte{1, 2, 3}, 111, 0)
 	p.sketch.Increase(1100)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
-	ok = p.putLease(2200, []byte{2, 3, 4}, 222)
+	err = p.putLease(2200, []byte{2, 3, 4}, 222, 0)
 	p.sketch.Increase(2200)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
-	ok = p.putLease(3300, []byte{3, 4, 5}, 333)
+	err = p.putLease(3300, []byte{3, 4, 5}, 333, 0)
 	p.sketch.Increase(3300)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
-	ok = p.putLease(4400, []byte{4, 5, 6}, 444)
+	err = p.putLease(4400, []byte{4, 5, 6}, 444, 0)
 	p.sketch.Increase(4400)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
 	assert.Equal(t, []uint64{4400, 3300, 2200}, p.admission.GetLRUList())
 	assert.Equal(t, []uint64{1100}, p.probation.GetLRUList())
@@ -419,13 +455,13 @@ func TestPartition_Evict_From_Admission(t *testing.T) {
 	assert.Equal(t, []uint64{4400, 3300}, p.admission.GetLRUList())
 	assert.Equal(t, []uint64{1100}, p.probation.GetLRUList())
 	content := map[uint64]uint32{
-		1100: 1 << 12,
-		3300: 1<<12 + 2*40,
-		4400: 1<<12 + 1*40,
+		1100: 0,
+		3300: 2 * 48,
+		4400: 48,
 	}
 	assert.Equal(t, content, p.contentMap)
 
-	getResult, _ := p.get(4400)
+	getResult, _ := p.get(4400, []byte{4, 5, 6})
 	assert.Equal(t, uint64(4400), getResult.hash)
 	assert.Equal(t, uint64(444), getResult.leaseID)
 	assert.Equal(t, []byte{4, 5, 6}, getResult.key)
@@ -436,8 +472,8 @@ func TestPartition_Evict_From_Admission(t *testing.T) {
 	assert.Equal(t, []uint64{4400}, p.admission.GetLRUList())
 	assert.Equal(t, []uint64{1100}, p.probation.GetLRUList())
 	content = map[uint64]uint32{
-		1100: 1 << 12,
-		4400: 1<<12 + 1*40,
+		1100: 0,
+		4400: 48,
 	}
 	assert.Equal(t, content, p.contentMap)
 
@@ -445,7 +481,7 @@ func TestPartition_Evict_From_Admission(t *testing.T) {
 	assert.Equal(t, []uint64(nil), p.admission.GetLRUList())
 	assert.Equal(t, []uint64{1100}, p.probation.GetLRUList())
 	content = map[uint64]uint32{
-		1100: 1 << 12,
+		1100: 0,
 	}
 	assert.Equal(t, content, p.contentMap)
 
@@ -458,9 +494,9 @@ func TestPartition_Evict_From_Admission(t *testing.T) {
 
 func TestPartition_Evict_Only_Admission(t *testing.T) {
 	conf := PartitionConfig{
-		InitAdmissionLimit: 3,
+		InitAdmissionLimit: 3 * 48,
 		ProtectedRatio:     NewRational(80, 100),
-		MinProtectedLimit:  50,
+		MinProtectedLimit:  50 * 48,
 		NumCounters:        100,
 		SketchMinCacheSize: 5,
 		AllocatorConfig: allocator.Config{
@@ -468,11 +504,11 @@ func TestPartition_Evict_Only_Admission(t *testing.T) {
 			LRUEntrySize: lruEntrySize,
 			Slabs: []allocator.SlabConfig{
 				{
-					ElemSize:     40,
+					ElemSize:     48,
 					ChunkSizeLog: 12,
 				},
 				{
-					ElemSize:     80,
+					ElemSize:     96,
 					ChunkSizeLog: 12,
 				},
 			},
@@ -482,17 +518,17 @@ func TestPartition_Evict_Only_Admission(t *testing.T) {
 
 	p.evict()
 
-	ok := p.putLease(1100, []byte{1, 2, 3}, 111)
+	err := p.putLease(1100, []byte{1, 2, 3}, 111, 0)
 	p.sketch.Increase(1100)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
-	ok = p.putLease(2200, []byte{2, 3, 4}, 222)
+	err = p.putLease(2200, []byte{2, 3, 4}, 222, 0)
 	p.sketch.Increase(2200)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
-	ok = p.putLease(3300, []byte{3, 4, 5}, 333)
+	err = p.putLease(3300, []byte{3, 4, 5}, 333, 0)
 	p.sketch.Increase(3300)
-	assert.True(t, ok)
+	assert.Equal(t, nil, err)
 
 	assert.Equal(t, []uint64{3300, 2200, 1100}, p.admission.GetLRUList())
 	assert.Equal(t, []uint64(nil), p.probation.GetLRUList())
@@ -505,8 +541,1380 @@ func TestPartition_Evict_Only_Admission(t *testing.T) {
 	assert.Equal(t, []uint64{3300, 2200}, p.admission.GetLRUList())
 	assert.Equal(t, []uint64(nil), p.probation.GetLRUList())
 	content := map[uint64]uint32{
-		2200: 1<<12 + 1*40,
-		3300: 1 << 12,
+		2200: 48,
+		3300: 0,
+	}
+	assert.Equal(t, content, p.contentMap)
+}
+
+func TestPartition_Remove(t *testing.T) {
+	conf := PartitionConfig{
+		InitAdmissionLimit: 3 * 48,
+		ProtectedRatio:     NewRational(80, 100),
+		MinProtectedLimit:  50 * 48,
+		NumCounters:        100,
+		SketchMinCacheSize: 5,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     16 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     48,
+					ChunkSizeLog: 12,
+				},
+				{
+					ElemSize:     96,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+	}
+	p := NewPartition(conf)
+
+	assert.False(t, p.remove(1100, []byte{1, 2, 3}))
+
+	p.putLease(1100, []byte{1, 2, 3}, 111, 0)
+	p.putLease(2200, []byte{2, 3, 4}, 222, 0)
+	p.putLease(3300, []byte{3, 4, 5}, 333, 0)
+	p.putLease(4400, []byte{4, 5, 6}, 444, 0)
+
+	assert.Equal(t, []uint64{4400, 3300, 2200}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64{1100}, p.probation.GetLRUList())
+
+	// key not equal
+	assert.False(t, p.remove(2200, []byte{1, 2, 3}))
+
+	assert.True(t, p.remove(2200, []byte{2, 3, 4}))
+	assert.Equal(t, []uint64{4400, 3300}, p.admission.GetLRUList())
+	content := map[uint64]uint32{
+		1100: 0,
+		3300: 2 * 48,
+		4400: 48,
+	}
+	assert.Equal(t, content, p.contentMap)
+
+	assert.True(t, p.remove(1100, []byte{1, 2, 3}))
+	assert.Equal(t, []uint64(nil), p.probation.GetLRUList())
+	content = map[uint64]uint32{
+		3300: 0,
+		4400: 48,
 	}
 	assert.Equal(t, content, p.contentMap)
+
+	result, ok := p.get(3300, []byte{3, 4, 5})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{3, 4, 5}, result.key)
+	assert.Equal(t, uint64(333), result.leaseID)
+
+	assert.True(t, p.remove(4400, []byte{4, 5, 6}))
+	assert.True(t, p.remove(3300, []byte{3, 4, 5}))
+	assert.Equal(t, []uint64(nil), p.admission.GetLRUList())
+	assert.Equal(t, map[uint64]uint32{}, p.contentMap)
+	assert.Equal(t, uint64(0), p.allocator.GetMemUsage())
+}
+
+func TestPartition_GetValue(t *testing.T) {
+	conf := PartitionConfig{
+		InitAdmissionLimit: 3 * 48,
+		ProtectedRatio:     NewRational(80, 100),
+		MinProtectedLimit:  50 * 48,
+		NumCounters:        100,
+		SketchMinCacheSize: 5,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     16 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     48,
+					ChunkSizeLog: 12,
+				},
+				{
+					ElemSize:     96,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+	}
+	p := NewPartition(conf)
+
+	_, ok := p.getValue(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+	assert.Equal(t, uint32(1), p.sketch.Frequency(1100))
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+
+	_, ok = p.getValue(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+
+	p.leaseSet(1100, []byte{1, 2, 3}, result.LeaseID, 101, []byte{10, 20}, 0)
+
+	value, ok := p.getValue(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{10, 20}, value)
+
+	_, ok = p.getValue(1100, []byte{5, 6, 7})
+	assert.False(t, ok)
+}
+
+func TestPartition_LeaseSet_After_Remove(t *testing.T) {
+	conf := PartitionConfig{
+		InitAdmissionLimit: 3 * 48,
+		ProtectedRatio:     NewRational(80, 100),
+		MinProtectedLimit:  50 * 48,
+		NumCounters:        100,
+		SketchMinCacheSize: 5,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     16 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     48,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+	}
+	p := NewPartition(conf)
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	p.remove(1100, []byte{1, 2, 3})
+
+	status, _ := p.leaseSet(1100, []byte{1, 2, 3}, result.LeaseID, 101, []byte{10, 20}, 0)
+	assert.Equal(t, LeaseSetStatusEntryGone, status)
+	_, ok := p.get(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+}
+
+func newCollisionTestPartition() *Partition {
+	return NewPartition(PartitionConfig{
+		InitAdmissionLimit: 3 * 48,
+		ProtectedRatio:     NewRational(80, 100),
+		MinProtectedLimit:  50 * 48,
+		NumCounters:        100,
+		SketchMinCacheSize: 5,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     16 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     48,
+					ChunkSizeLog: 12,
+				},
+				{
+					ElemSize:     96,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+	})
+}
+
+func TestPartition_LeaseGet_Hash_Collision(t *testing.T) {
+	p := newCollisionTestPartition()
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, uint64(1), result.LeaseID)
+
+	result, _ = p.leaseGet(1100, []byte{4, 5, 6})
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, uint64(2), result.LeaseID)
+
+	assert.Equal(t, map[uint64]uint32{1100: 0}, p.contentMap)
+	assert.Equal(t, map[uint64][]uint32{1100: {48}}, p.collisions)
+
+	p.leaseSet(1100, []byte{4, 5, 6}, 2, 202, []byte{40, 50}, 0)
+	p.leaseSet(1100, []byte{1, 2, 3}, 1, 101, []byte{10, 20, 30}, 0)
+
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusExisted, result.Status)
+	assert.Equal(t, []byte{10, 20, 30}, result.Value)
+
+	result, _ = p.leaseGet(1100, []byte{4, 5, 6})
+	assert.Equal(t, LeaseGetStatusExisted, result.Status)
+	assert.Equal(t, []byte{40, 50}, result.Value)
+
+	result, _ = p.leaseGet(1100, []byte{7, 8, 9})
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, []uint64{1100, 1100, 1100}, p.admission.GetLRUList())
+}
+
+func TestPartition_Remove_Hash_Collision(t *testing.T) {
+	p := newCollisionTestPartition()
+
+	p.putLease(1100, []byte{1, 2, 3}, 111, 0)
+	p.putLease(1100, []byte{4, 5, 6}, 222, 0)
+	p.putLease(1100, []byte{7, 8, 9}, 333, 0)
+
+	assert.Equal(t, map[uint64]uint32{1100: 0}, p.contentMap)
+	assert.Equal(t, map[uint64][]uint32{1100: {48, 2 * 48}}, p.collisions)
+
+	assert.True(t, p.remove(1100, []byte{1, 2, 3}))
+	assert.Equal(t, map[uint64]uint32{1100: 0}, p.contentMap)
+	assert.Equal(t, map[uint64][]uint32{1100: {48}}, p.collisions)
+
+	_, ok := p.get(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+
+	result, ok := p.get(1100, []byte{4, 5, 6})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(222), result.leaseID)
+
+	result, ok = p.get(1100, []byte{7, 8, 9})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(333), result.leaseID)
+
+	assert.True(t, p.remove(1100, []byte{4, 5, 6}))
+	assert.Equal(t, map[uint64]uint32{1100: 0}, p.contentMap)
+	assert.Equal(t, map[uint64][]uint32{}, p.collisions)
+
+	result, ok = p.get(1100, []byte{7, 8, 9})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(333), result.leaseID)
+	assert.Equal(t, []uint64{1100}, p.admission.GetLRUList())
+
+	assert.True(t, p.remove(1100, []byte{7, 8, 9}))
+	assert.Equal(t, map[uint64]uint32{}, p.contentMap)
+}
+
+func TestPartition_Evict_Hash_Collision(t *testing.T) {
+	p := newCollisionTestPartition()
+
+	p.putLease(1100, []byte{1, 2, 3}, 111, 0)
+	p.putLease(2200, []byte{2, 3, 4}, 222, 0)
+	p.putLease(1100, []byte{4, 5, 6}, 333, 0)
+	p.putLease(1100, []byte{7, 8, 9}, 444, 0)
+
+	assert.Equal(t, []uint64{1100, 1100, 2200}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64{1100}, p.probation.GetLRUList())
+
+	result, ok := p.get(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, lruListProbation, result.lruList)
+
+	p.evict()
+	assert.Equal(t, []uint64{1100, 1100}, p.admission.GetLRUList())
+	_, ok = p.get(2200, []byte{2, 3, 4})
+	assert.False(t, ok)
+
+	p.evict()
+	assert.Equal(t, []uint64{1100}, p.admission.GetLRUList())
+	_, ok = p.get(1100, []byte{4, 5, 6})
+	assert.False(t, ok)
+
+	result, ok = p.get(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(111), result.leaseID)
+
+	result, ok = p.get(1100, []byte{7, 8, 9})
+	assert.True(t, ok)
+	assert.Equal(t, uint64(444), result.leaseID)
+	assert.Equal(t, lruListAdmission, result.lruList)
+}
+
+func TestPartition_LeaseSet_Lease_Mismatch(t *testing.T) {
+	p := newCollisionTestPartition()
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, uint64(1), result.LeaseID)
+
+	// foreign writer
+	status, _ := p.leaseSet(1100, []byte{1, 2, 3}, 2, 101, []byte{10, 20, 30}, 0)
+	assert.Equal(t, LeaseSetStatusLeaseMismatch, status)
+
+	r, ok := p.get(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, entryStatusLeasing, r.status)
+	assert.Equal(t, uint64(1), r.leaseID)
+
+	status, _ = p.leaseSet(1100, []byte{1, 2, 3}, 1, 101, []byte{10, 20, 30}, 0)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
+
+	// stale writer, the lease had already been used
+	status, _ = p.leaseSet(1100, []byte{1, 2, 3}, 1, 102, []byte{40, 50}, 0)
+	assert.Equal(t, LeaseSetStatusLeaseMismatch, status)
+
+	r, ok = p.get(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, entryStatusValid, r.status)
+	assert.Equal(t, uint64(101), r.leaseID)
+	assert.Equal(t, []byte{10, 20, 30}, r.value)
+
+	// other key with the same hash
+	status, _ = p.leaseSet(1100, []byte{4, 5, 6}, 1, 101, []byte{10, 20, 30}, 0)
+	assert.Equal(t, LeaseSetStatusEntryGone, status)
+}
+
+func TestPartition_LeaseGet_Lease_Expired(t *testing.T) {
+	clock := newFakeClock()
+	p := NewPartition(PartitionConfig{
+		InitAdmissionLimit: 3 * 48,
+		ProtectedRatio:     NewRational(80, 100),
+		MinProtectedLimit:  50 * 48,
+		NumCounters:        100,
+		SketchMinCacheSize: 5,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     16 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     48,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+		LeaseTimeout: 5 * time.Second,
+		Clock:        clock,
+	})
+	assert.Equal(t, 5*time.Second, p.leaseTimeout)
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, uint64(1), result.LeaseID)
+
+	clock.Advance(5*time.Second - 1)
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusLeaseRejected, result.Status)
+
+	clock.Advance(1)
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, uint64(2), result.LeaseID)
+
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusLeaseRejected, result.Status)
+
+	// the abandoned lease can NOT be used anymore
+	status, _ := p.leaseSet(1100, []byte{1, 2, 3}, 1, 101, []byte{10, 20}, 0)
+	assert.Equal(t, LeaseSetStatusLeaseMismatch, status)
+
+	status, _ = p.leaseSet(1100, []byte{1, 2, 3}, 2, 102, []byte{30, 40}, 0)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
+
+	// valid entries never expire with the lease timeout
+	clock.Advance(10 * time.Second)
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusExisted, result.Status)
+	assert.Equal(t, []byte{30, 40}, result.Value)
+
+	assert.Equal(t, []uint64{1100}, p.admission.GetLRUList())
+}
+
+func TestPartition_Invalidate(t *testing.T) {
+	clock := newFakeClock()
+	p := NewPartition(PartitionConfig{
+		InitAdmissionLimit: 3 * 48,
+		ProtectedRatio:     NewRational(80, 100),
+		MinProtectedLimit:  50 * 48,
+		NumCounters:        100,
+		SketchMinCacheSize: 5,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     16 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     48,
+					ChunkSizeLog: 12,
+				},
+				{
+					ElemSize:     96,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+		LeaseTimeout: 5 * time.Second,
+		Clock:        clock,
+	})
+
+	assert.False(t, p.invalidate(1100, []byte{1, 2, 3}))
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	p.leaseSet(1100, []byte{1, 2, 3}, result.LeaseID, 101, []byte{10, 20}, 0)
+
+	assert.True(t, p.invalidate(1100, []byte{1, 2, 3}))
+
+	r, ok := p.get(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, entryStatusInvalid, r.status)
+	assert.Equal(t, []byte{10, 20}, r.value)
+
+	_, ok = p.getValue(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetResult{
+		Status:  LeaseGetStatusLeaseGranted,
+		LeaseID: 2,
+		Value:   []byte{10, 20},
+		Stale:   true,
+	}, result)
+
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetResult{
+		Status: LeaseGetStatusLeaseRejected,
+		Value:  []byte{10, 20},
+		Stale:  true,
+	}, result)
+
+	// invalidate again while the loader is running
+	assert.True(t, p.invalidate(1100, []byte{1, 2, 3}))
+
+	status, _ := p.leaseSet(1100, []byte{1, 2, 3}, 2, 102, []byte{30, 40}, 0)
+	assert.Equal(t, LeaseSetStatusLeaseMismatch, status)
+
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetResult{
+		Status:  LeaseGetStatusLeaseGranted,
+		LeaseID: 3,
+		Value:   []byte{10, 20},
+		Stale:   true,
+	}, result)
+
+	// the stale value is kept when the lease is abandoned
+	clock.Advance(5 * time.Second)
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetResult{
+		Status:  LeaseGetStatusLeaseGranted,
+		LeaseID: 4,
+		Value:   []byte{10, 20},
+		Stale:   true,
+	}, result)
+
+	status, _ = p.leaseSet(1100, []byte{1, 2, 3}, 4, 104, []byte{50, 60, 70}, 0)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
+
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetResult{
+		Status: LeaseGetStatusExisted,
+		Value:  []byte{50, 60, 70},
+	}, result)
+}
+
+func TestPartition_Invalidate_Leasing(t *testing.T) {
+	p := newCollisionTestPartition()
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, uint64(1), result.LeaseID)
+
+	assert.True(t, p.invalidate(1100, []byte{1, 2, 3}))
+
+	r, ok := p.get(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, entryStatusLeasing, r.status)
+
+	status, _ := p.leaseSet(1100, []byte{1, 2, 3}, 1, 101, []byte{10, 20}, 0)
+	assert.Equal(t, LeaseSetStatusLeaseMismatch, status)
+
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetResult{
+		Status:  LeaseGetStatusLeaseGranted,
+		LeaseID: 2,
+	}, result)
+}
+
+func newTTLTestPartition(clock Clock, timerTick time.Duration) *Partition {
+	return NewPartition(PartitionConfig{
+		InitAdmissionLimit: 10 * 48,
+		ProtectedRatio:     NewRational(80, 100),
+		MinProtectedLimit:  50 * 48,
+		NumCounters:        100,
+		SketchMinCacheSize: 5,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     16 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     48,
+					ChunkSizeLog: 12,
+				},
+				{
+					ElemSize:     96,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+		Clock:     clock,
+		TimerTick: timerTick,
+	})
+}
+
+func TestPartition_LeaseSet_TTL_Lazy_Expire(t *testing.T) {
+	clock := newFakeClock()
+	// the timing wheel never runs in this test
+	p := newTTLTestPartition(clock, time.Hour)
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	status, err := p.leaseSet(1100, []byte{1, 2, 3}, result.LeaseID, 101, []byte{10, 20}, 5*time.Second)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
+
+	r, _ := p.get(1100, []byte{1, 2, 3})
+	assert.Equal(t, entryStatusValid, r.status)
+	assert.Equal(t, uint32(1), p.timer.Size())
+
+	clock.Advance(5*time.Second - 1)
+	value, ok := p.getValue(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{10, 20}, value)
+
+	clock.Advance(1)
+	value, ok = p.getValue(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+	assert.Nil(t, value)
+
+	assert.Equal(t, map[uint64]uint32{}, p.contentMap)
+	assert.Equal(t, []uint64(nil), p.admission.GetLRUList())
+	assert.Equal(t, uint32(0), p.timer.Size())
+
+	result, _ = p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+}
+
+func TestPartition_TTL_Timer_Free_Entries(t *testing.T) {
+	clock := newFakeClock()
+	p := newTTLTestPartition(clock, time.Second)
+
+	assert.Equal(t, nil, p.set(1100, []byte{1, 2, 3}, []byte{10, 20}, 3*time.Second))
+	assert.Equal(t, nil, p.set(2200, []byte{2, 3, 4}, []byte{30, 40}, 10*time.Second))
+	assert.Equal(t, nil, p.set(3300, []byte{3, 4, 5}, []byte{50, 60}, 0))
+	assert.Equal(t, uint32(2), p.timer.Size())
+	assert.Equal(t, []uint64{3300, 2200, 1100}, p.admission.GetLRUList())
+
+	clock.Advance(3 * time.Second)
+	assert.False(t, p.remove(4400, []byte{4, 5, 6}))
+
+	_, ok := p.get(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+	assert.Equal(t, []uint64{3300, 2200}, p.admission.GetLRUList())
+	assert.Equal(t, uint32(1), p.timer.Size())
+
+	clock.Advance(7 * time.Second)
+	assert.False(t, p.invalidate(4400, []byte{4, 5, 6}))
+
+	_, ok = p.get(2200, []byte{2, 3, 4})
+	assert.False(t, ok)
+	assert.Equal(t, []uint64{3300}, p.admission.GetLRUList())
+	assert.Equal(t, uint32(0), p.timer.Size())
+
+	// entries without TTL never expire
+	clock.Advance(1000 * time.Hour)
+	value, ok := p.getValue(3300, []byte{3, 4, 5})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{50, 60}, value)
+}
+
+func TestPartition_TTL_Timer_Free_Entries_Hash_Collision(t *testing.T) {
+	clock := newFakeClock()
+	p := newTTLTestPartition(clock, time.Second)
+
+	p.set(1100, []byte{1, 2, 3}, []byte{10, 20}, 3*time.Second)
+	p.set(1100, []byte{2, 3, 4}, []byte{30, 40}, 5*time.Second)
+	p.set(1100, []byte{3, 4, 5}, []byte{50, 60}, 3*time.Second)
+
+	clock.Advance(3 * time.Second)
+	p.remove(4400, []byte{4, 5, 6})
+
+	_, ok := p.get(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+	_, ok = p.get(1100, []byte{3, 4, 5})
+	assert.False(t, ok)
+
+	r, ok := p.get(1100, []byte{2, 3, 4})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{30, 40}, r.value)
+	assert.Equal(t, 0, len(p.collisions))
+	assert.Equal(t, uint32(1), p.timer.Size())
+}
+
+func TestPartition_Set(t *testing.T) {
+	clock := newFakeClock()
+	p := newTTLTestPartition(clock, time.Second)
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+
+	// set revokes the outstanding lease
+	assert.Equal(t, nil, p.set(1100, []byte{1, 2, 3}, []byte{10, 20}, 0))
+	status, err := p.leaseSet(1100, []byte{1, 2, 3}, result.LeaseID, 101, []byte{30, 40}, 0)
+	assert.Equal(t, ErrLeaseMismatch, err)
+	assert.Equal(t, LeaseSetStatusLeaseMismatch, status)
+
+	value, ok := p.getValue(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{10, 20}, value)
+
+	// set a value of another slab
+	assert.Equal(t, nil, p.set(1100, []byte{1, 2, 3}, make([]byte, 30), 5*time.Second))
+	value, _ = p.getValue(1100, []byte{1, 2, 3})
+	assert.Equal(t, make([]byte, 30), value)
+	assert.Equal(t, uint32(1), p.timer.Size())
+
+	// set without TTL removes the timer
+	assert.Equal(t, nil, p.set(1100, []byte{1, 2, 3}, make([]byte, 31), 0))
+	assert.Equal(t, uint32(0), p.timer.Size())
+
+	clock.Advance(10 * time.Second)
+	value, ok = p.getValue(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, make([]byte, 31), value)
+
+	assert.Equal(t, ErrValueTooLarge, p.set(2200, []byte{2, 3, 4}, make([]byte, 1<<16), 0))
+	assert.Equal(t, 1, len(p.contentMap))
+}
+
+func TestPartition_Invalidate_Keep_TTL(t *testing.T) {
+	clock := newFakeClock()
+	p := newTTLTestPartition(clock, time.Second)
+
+	p.set(1100, []byte{1, 2, 3}, []byte{10, 20}, 3*time.Second)
+	assert.True(t, p.invalidate(1100, []byte{1, 2, 3}))
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, []byte{10, 20}, result.Value)
+	assert.True(t, result.Stale)
+
+	// the stale value is freed when its TTL passed
+	clock.Advance(3 * time.Second)
+	status, err := p.leaseSet(1100, []byte{1, 2, 3}, result.LeaseID, 101, []byte{30, 40}, 0)
+	assert.Equal(t, ErrLeaseMismatch, err)
+	assert.Equal(t, LeaseSetStatusEntryGone, status)
+	assert.Equal(t, uint32(0), p.timer.Size())
+}
+
+func TestPartition_Hill_Climbing_Admission_Limit(t *testing.T) {
+	p := newTTLTestPartition(newFakeClock(), time.Second)
+	p.admission.UpdateLimit(20 * 48)
+
+	for i := uint64(0); i < 40; i++ {
+		assert.Equal(t, nil, p.set(i, []byte{1, 2, 3}, []byte{10, 20}, 0))
+	}
+	assert.Equal(t, uint32(20), p.admission.Size())
+	assert.Equal(t, uint32(20), p.probation.Size())
+
+	// sample period = 10 * 40 entries
+	for i := uint64(0); i < 399; i++ {
+		_, ok := p.getValue(i%20, []byte{1, 2, 3})
+		assert.True(t, ok)
+	}
+	assert.Equal(t, uint32(20*48), p.admission.Limit())
+
+	// the first climb shrinks the window by 6.25% of the total weight
+	p.getValue(0, []byte{1, 2, 3})
+	assert.Equal(t, uint32(20*48-40*48/16), p.admission.Limit())
+
+	assert.Equal(t, nil, p.set(100, []byte{1, 2, 3}, []byte{10, 20}, 0))
+	assert.Equal(t, uint32(17), p.admission.Size())
+	assert.Equal(t, uint32(20), p.protected.Size())
+	assert.Equal(t, uint32(4), p.probation.Size())
+
+	// the hit rate dropped, the window grows back
+	for i := uint64(0); i < 410; i++ {
+		p.getValue(1000+i, []byte{1, 2, 3})
+	}
+	assert.Equal(t, uint32(20*48), p.admission.Limit())
+}
+
+func newSizeAwareTestPartition() *Partition {
+	p := newTTLTestPartition(newFakeClock(), time.Second)
+	p.admission.UpdateLimit(2 * 48)
+
+	p.set(1, []byte{1, 2, 3}, []byte{10, 20}, 0)
+	p.set(2, []byte{1, 2, 3}, []byte{10, 20}, 0)
+	p.set(3, []byte{1, 2, 3}, []byte{10, 20}, 0)
+	return p
+}
+
+func TestPartition_Admission_Limit_In_Bytes(t *testing.T) {
+	p := newSizeAwareTestPartition()
+	assert.Equal(t, []uint64{3, 2}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64{1}, p.probation.GetLRUList())
+	assert.Equal(t, uint32(2*48), p.admission.Weight())
+
+	// the entry of 96 bytes takes the whole admission window
+	p.set(100, []byte{1, 2, 3}, make([]byte, 50), 0)
+	assert.Equal(t, []uint64{100}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64{3, 2, 1}, p.probation.GetLRUList())
+	assert.Equal(t, uint32(96), p.admission.Weight())
+	assert.Equal(t, uint32(3*48), p.probation.Weight())
+}
+
+func TestPartition_Evict_Large_Candidate_Rejected(t *testing.T) {
+	p := newSizeAwareTestPartition()
+	p.set(100, []byte{1, 2, 3}, make([]byte, 50), 0)
+
+	p.sketch.Increase(1)
+	p.sketch.Increase(1)
+	p.sketch.Increase(2)
+	p.sketch.Increase(2)
+	p.sketch.Increase(100)
+	p.sketch.Increase(100)
+	p.sketch.Increase(100)
+
+	// the frequency of the candidate must be higher than the total frequency of the 2 victims
+	assert.True(t, p.evict())
+	assert.Equal(t, []uint64(nil), p.admission.GetLRUList())
+	assert.Equal(t, []uint64{3, 2, 1}, p.probation.GetLRUList())
+}
+
+func TestPartition_Evict_Large_Candidate_Admitted(t *testing.T) {
+	p := newSizeAwareTestPartition()
+	p.set(100, []byte{1, 2, 3}, make([]byte, 50), 0)
+
+	p.sketch.Increase(1)
+	p.sketch.Increase(1)
+	p.sketch.Increase(2)
+	p.sketch.Increase(2)
+	for i := 0; i < 5; i++ {
+		p.sketch.Increase(100)
+	}
+
+	assert.True(t, p.evict())
+	assert.Equal(t, []uint64{100}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64{3}, p.probation.GetLRUList())
+
+	_, ok := p.get(1, []byte{1, 2, 3})
+	assert.False(t, ok)
+	_, ok = p.get(2, []byte{1, 2, 3})
+	assert.False(t, ok)
+}
+
+func newSegmentTestPartition() *Partition {
+	return NewPartition(PartitionConfig{
+		InitAdmissionLimit: 2 * 48,
+		ProtectedRatio:     NewRational(50, 100),
+		MinProtectedLimit:  2 * 48,
+		NumCounters:        100,
+		SketchMinCacheSize: 100,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     16 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     48,
+					ChunkSizeLog: 12,
+				},
+				{
+					ElemSize:     96,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+	})
+}
+
+func TestPartition_Touch_Admission(t *testing.T) {
+	p := newSegmentTestPartition()
+
+	p.leaseGet(1100, []byte{1, 2, 3})
+	p.leaseGet(2200, []byte{2, 3, 4})
+	assert.Equal(t, []uint64{2200, 1100}, p.admission.GetLRUList())
+
+	p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, []uint64{1100, 2200}, p.admission.GetLRUList())
+
+	p.getValue(2200, []byte{2, 3, 4})
+	assert.Equal(t, []uint64{2200, 1100}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64(nil), p.protected.GetLRUList())
+	assert.Equal(t, []uint64(nil), p.probation.GetLRUList())
+}
+
+func TestPartition_Promote_To_Protected(t *testing.T) {
+	p := newSegmentTestPartition()
+
+	p.leaseGet(1100, []byte{1, 2, 3})
+	p.leaseGet(2200, []byte{2, 3, 4})
+	p.leaseGet(3300, []byte{3, 4, 5})
+	p.leaseGet(4400, []byte{4, 5, 6})
+
+	assert.Equal(t, []uint64{4400, 3300}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64{2200, 1100}, p.probation.GetLRUList())
+
+	p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, []uint64{4400, 3300}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64{1100}, p.protected.GetLRUList())
+	assert.Equal(t, []uint64{2200}, p.probation.GetLRUList())
+
+	result, _ := p.get(1100, []byte{1, 2, 3})
+	assert.Equal(t, lruListProtected, result.lruList)
+
+	p.getValue(2200, []byte{2, 3, 4})
+	assert.Equal(t, []uint64{2200, 1100}, p.protected.GetLRUList())
+	assert.Equal(t, []uint64(nil), p.probation.GetLRUList())
+
+	result, _ = p.get(2200, []byte{2, 3, 4})
+	assert.Equal(t, lruListProtected, result.lruList)
+
+	// touch inside protected
+	p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, []uint64{1100, 2200}, p.protected.GetLRUList())
+
+	// still existed after promotion
+	p.leaseSet(1100, []byte{1, 2, 3}, 1, 101, []byte{10, 20}, 0)
+	value, ok := p.getValue(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, []byte{10, 20}, value)
+}
+
+func TestPartition_Protected_Overflow_Demote(t *testing.T) {
+	p := newSegmentTestPartition()
+
+	p.leaseGet(1100, []byte{1, 2, 3})
+	p.leaseGet(2200, []byte{2, 3, 4})
+	p.leaseGet(3300, []byte{3, 4, 5})
+	p.leaseGet(4400, []byte{4, 5, 6})
+	p.leaseGet(5500, []byte{5, 6, 7})
+	p.leaseGet(6600, []byte{6, 7, 8})
+
+	assert.Equal(t, []uint64{6600, 5500}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64{4400, 3300, 2200, 1100}, p.probation.GetLRUList())
+
+	p.leaseGet(1100, []byte{1, 2, 3})
+	p.leaseGet(2200, []byte{2, 3, 4})
+	assert.Equal(t, []uint64{2200, 1100}, p.protected.GetLRUList())
+	assert.Equal(t, []uint64{4400, 3300}, p.probation.GetLRUList())
+	assert.Equal(t, uint32(2*48), p.protected.Limit())
+
+	p.leaseGet(3300, []byte{3, 4, 5})
+	assert.Equal(t, []uint64{3300, 2200}, p.protected.GetLRUList())
+	assert.Equal(t, []uint64{1100, 4400}, p.probation.GetLRUList())
+
+	result, _ := p.get(1100, []byte{1, 2, 3})
+	assert.Equal(t, lruListProbation, result.lruList)
+	result, _ = p.get(3300, []byte{3, 4, 5})
+	assert.Equal(t, lruListProtected, result.lruList)
+
+	p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, []uint64{1100, 3300}, p.protected.GetLRUList())
+	assert.Equal(t, []uint64{2200, 4400}, p.probation.GetLRUList())
+}
+
+func TestPartition_Evict_From_Protected(t *testing.T) {
+	p := newSegmentTestPartition()
+
+	p.leaseGet(1100, []byte{1, 2, 3})
+	p.leaseGet(2200, []byte{2, 3, 4})
+	p.leaseGet(3300, []byte{3, 4, 5})
+	p.leaseGet(1100, []byte{1, 2, 3})
+
+	assert.Equal(t, []uint64{3300, 2200}, p.admission.GetLRUList())
+	assert.Equal(t, []uint64{1100}, p.protected.GetLRUList())
+
+	p.evict()
+	p.evict()
+	assert.Equal(t, []uint64(nil), p.admission.GetLRUList())
+	assert.Equal(t, []uint64{1100}, p.protected.GetLRUList())
+
+	p.evict()
+	assert.Equal(t, []uint64(nil), p.protected.GetLRUList())
+	assert.Equal(t, map[uint64]uint32{}, p.contentMap)
+	assert.Equal(t, uint64(0), p.allocator.GetMemUsage())
+}
+
+func newEvictTestPartitionConfig() PartitionConfig {
+	return PartitionConfig{
+		InitAdmissionLimit: 3 * 48,
+		ProtectedRatio:     NewRational(80, 100),
+		MinProtectedLimit:  50 * 48,
+		NumCounters:        1000,
+		SketchMinCacheSize: 100,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     3 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     48,
+					ChunkSizeLog: 12,
+				},
+				{
+					ElemSize:     96,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+	}
+}
+
+func newEvictTestPartition() *Partition {
+	return NewPartition(newEvictTestPartitionConfig())
+}
+
+func TestPartition_LeaseGet_Evict_Until_Fit(t *testing.T) {
+	p := newEvictTestPartition()
+
+	const numElemPerChunk = (1 << 12) / 48
+	for i := uint64(1); i <= 2*numElemPerChunk; i++ {
+		result, err := p.leaseGet(i, []byte{1, 2, 3})
+		assert.Equal(t, nil, err)
+		assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	}
+
+	// the 2 chunks for entries are full
+	assert.Equal(t, 2*numElemPerChunk, len(p.contentMap))
+	assert.Equal(t, uint64(2<<12), p.allocator.GetMemUsage())
+
+	result, err := p.leaseGet(1000, []byte{1, 2, 3})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LeaseGetStatusLeaseGranted, result.Status)
+	assert.Equal(t, 2*numElemPerChunk, len(p.contentMap))
+
+	_, ok := p.get(1000, []byte{1, 2, 3})
+	assert.True(t, ok)
+
+	// the last entry of admission has the same frequency as the last entry of probation
+	_, ok = p.get(2*numElemPerChunk-1, []byte{1, 2, 3})
+	assert.False(t, ok)
+	assert.Equal(t, []uint64{1000, 2 * numElemPerChunk}, p.admission.GetLRUList())
+}
+
+func TestPartition_Evict_Prefer_Same_Slab(t *testing.T) {
+	conf := newEvictTestPartitionConfig()
+	// the entry of the slab of 96 bytes takes the room of 2 entries
+	conf.InitAdmissionLimit = 4 * 48
+	p := NewPartition(conf)
+
+	const numElemPerChunk = (1 << 12) / 48
+	for i := uint64(1); i <= numElemPerChunk; i++ {
+		err := p.putLease(i, []byte{1, 2, 3}, i, 0)
+		assert.Equal(t, nil, err)
+	}
+
+	// move the entry to the slab of 96 bytes, the last chunk is used
+	status, err := p.leaseSet(numElemPerChunk, []byte{1, 2, 3}, numElemPerChunk, 1, make([]byte, 20), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
+	assert.Equal(t, []uint64{numElemPerChunk, numElemPerChunk - 1, numElemPerChunk - 2}, p.admission.GetLRUList())
+
+	err = p.putLease(1000, []byte{1, 2, 3}, 1000, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []uint64{1000, numElemPerChunk, numElemPerChunk - 1}, p.admission.GetLRUList())
+
+	// the last entry of admission is in the other slab
+	err = p.putLease(2000, []byte{1, 2, 3}, 2000, 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []uint64{2000, numElemPerChunk}, p.admission.GetLRUList())
+
+	_, ok := p.get(1000, []byte{1, 2, 3})
+	assert.False(t, ok)
+
+	value, ok := p.getValue(numElemPerChunk, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, make([]byte, 20), value)
+}
+
+func TestPartition_LeaseSet_Evict_Until_Fit(t *testing.T) {
+	p := newEvictTestPartition()
+
+	const numElemPerChunk = (1 << 12) / 48
+	for i := uint64(1); i <= 2*numElemPerChunk; i++ {
+		err := p.putLease(i, []byte{1, 2, 3}, i, 0)
+		assert.Equal(t, nil, err)
+	}
+
+	// a whole chunk of the slab of 48 bytes must be evicted for having a chunk for the slab of 96 bytes
+	status, err := p.leaseSet(1, []byte{1, 2, 3}, 1, 101, make([]byte, 20), 0)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, LeaseSetStatusAccepted, status)
+
+	value, ok := p.getValue(1, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, make([]byte, 20), value)
+	assert.Equal(t, numElemPerChunk+1, len(p.contentMap))
+}
+
+func TestPartition_No_Space(t *testing.T) {
+	p := newEvictTestPartition()
+
+	// larger than the largest buddy block
+	_, err := p.leaseGet(1100, make([]byte, 8192))
+	assert.Equal(t, ErrValueTooLarge, err)
+	assert.Equal(t, map[uint64]uint32{}, p.contentMap)
+	assert.Equal(t, []uint64(nil), p.admission.GetLRUList())
+
+	result, err := p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, nil, err)
+
+	status, err := p.leaseSet(1100, []byte{1, 2, 3}, result.LeaseID, 101, make([]byte, 8192), 0)
+	assert.Equal(t, ErrValueTooLarge, err)
+	assert.Equal(t, LeaseSetStatusEntryGone, status)
+
+	_, ok := p.get(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+	assert.Equal(t, map[uint64]uint32{}, p.contentMap)
+	assert.Equal(t, []uint64(nil), p.admission.GetLRUList())
+	assert.Equal(t, uint64(0), p.allocator.GetMemUsage())
+}
+
+func largeTestValue(size int, seed byte) []byte {
+	value := make([]byte, size)
+	for i := range value {
+		value[i] = seed + byte(i)
+	}
+	return value
+}
+
+func TestPartition_Large_Value(t *testing.T) {
+	p := newTTLTestPartition(newFakeClock(), time.Second)
+
+	value := largeTestValue(10000, 1)
+	assert.Equal(t, nil, p.set(1100, []byte{1, 2, 3}, value, 0))
+
+	result, ok := p.getValue(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, value, result)
+	assert.Equal(t, uint32(1<<14), p.totalWeight())
+
+	// the same buddy block is reused
+	addr := p.contentMap[1100]
+	value = largeTestValue(12000, 2)
+	assert.Equal(t, nil, p.set(1100, []byte{1, 2, 3}, value, 0))
+	assert.Equal(t, addr, p.contentMap[1100])
+
+	result, ok = p.getValue(1100, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, value, result)
+
+	assert.True(t, p.remove(1100, []byte{1, 2, 3}))
+	assert.Equal(t, uint32(0), p.totalWeight())
+	validatePartitionInvariants(t, p)
+}
+
+func TestPartition_Large_Value_Evict(t *testing.T) {
+	p := newTTLTestPartition(newFakeClock(), time.Second)
+
+	for i := uint64(1); i <= 10; i++ {
+		err := p.set(i, []byte{byte(i)}, largeTestValue(10000, byte(i)), 0)
+		assert.Equal(t, nil, err)
+		validatePartitionInvariants(t, p)
+	}
+	assert.Less(t, len(p.contentMap), 10)
+
+	result, ok := p.getValue(10, []byte{10})
+	assert.True(t, ok)
+	assert.Equal(t, largeTestValue(10000, 10), result)
+
+	// small values evict the large ones when the memory is full
+	for i := uint64(100); i < 1000; i++ {
+		err := p.set(i, []byte{1, 2, 3}, []byte{10, 20}, 0)
+		assert.Equal(t, nil, err)
+	}
+	validatePartitionInvariants(t, p)
+}
+
+func newSlabAutomoveTestPartition(t *testing.T, automove bool) *Partition {
+	conf := newEvictTestPartitionConfig()
+	conf.AllocatorConfig.MemLimit = 8 << 12
+	conf.SlabAutomove = automove
+	p := NewPartition(conf)
+
+	// the frequently accessed entries fill the slab of 48 bytes
+	for i := uint64(1); i <= 1000; i++ {
+		p.set(i, []byte{1, 2, 3}, []byte{1}, 0)
+	}
+	for k := 0; k < 3; k++ {
+		for i := uint64(1); i <= 1000; i++ {
+			p.getValue(i, []byte{1, 2, 3})
+		}
+	}
+
+	// the workload shifts to the slab of 96 bytes
+	for i := uint64(2001); i <= 4000; i++ {
+		p.set(i, []byte{1, 2, 3}, make([]byte, 50), 0)
+	}
+	validatePartitionInvariants(t, p)
+	return p
+}
+
+func TestPartition_Slab_Automove(t *testing.T) {
+	p := newSlabAutomoveTestPartition(t, false)
+	assert.Equal(t, uint32(1), p.allocator.GetSlab(1).GetNumChunks())
+	assert.Equal(t, uint64(0), p.counters.SlabMoves)
+
+	p = newSlabAutomoveTestPartition(t, true)
+	assert.Equal(t, uint32(0), p.allocator.GetSlab(0).GetNumChunks())
+	assert.Equal(t, uint32(6), p.allocator.GetSlab(1).GetNumChunks())
+	assert.Equal(t, uint64(5), p.counters.SlabMoves)
+
+	count := 0
+	for i := uint64(2001); i <= 4000; i++ {
+		if _, ok := p.getValue(i, []byte{1, 2, 3}); ok {
+			count++
+		}
+	}
+	assert.Equal(t, 6*((1<<12)/96), count)
+}
+
+func TestPartition_Slab_Automove_Smaller_Donor_Chunk(t *testing.T) {
+	conf := newEvictTestPartitionConfig()
+	conf.AllocatorConfig.MemLimit = 16 << 12
+	conf.AllocatorConfig.Slabs = []allocator.SlabConfig{
+		{
+			ElemSize:     48,
+			ChunkSizeLog: 12,
+		},
+		{
+			ElemSize:     2048,
+			ChunkSizeLog: 14,
+		},
+	}
+	conf.SlabAutomove = true
+	p := NewPartition(conf)
+
+	// the LRU list heads fit into a single chunk
+	for i := uint64(1); i <= 200; i++ {
+		p.set(i, []byte{1, 2, 3}, []byte{1}, 0)
+	}
+
+	// the chunks of 4KB of the slab of 48 bytes can NOT make room for the chunks of 16KB
+	for i := uint64(2001); i <= 2040; i++ {
+		assert.Equal(t, nil, p.set(i, []byte{1, 2, 3}, make([]byte, 1500), 0))
+	}
+	assert.Equal(t, uint64(0), p.counters.SlabMoves)
+
+	_, ok := p.getValue(2040, []byte{1, 2, 3})
+	assert.True(t, ok)
+	validatePartitionInvariants(t, p)
+}
+
+func TestPartition_Slab_Automove_Scan_Limit(t *testing.T) {
+	conf := newEvictTestPartitionConfig()
+	conf.AllocatorConfig.MemLimit = 8 << 12
+	conf.SlabAutomove = true
+	p := NewPartition(conf)
+
+	for i := uint64(1); i <= 300; i++ {
+		p.set(i, []byte{1, 2, 3}, make([]byte, 50), 0)
+	}
+	// the entries of the slab of 48 bytes are the most recently used
+	for i := uint64(1001); i <= 1010; i++ {
+		p.set(i, []byte{1, 2, 3}, []byte{1}, 0)
+	}
+
+	// a chunk is moved to the slab of 48 bytes
+	assert.Equal(t, uint64(1), p.counters.SlabMoves)
+
+	// the victims of the donor are too far from the ends of the lists
+	for i := uint64(2001); i <= 2010; i++ {
+		p.set(i, []byte{1, 2, 3}, make([]byte, 50), 0)
+	}
+	assert.Equal(t, uint64(1), p.counters.SlabMoves)
+	assert.Equal(t, uint32(1), p.allocator.GetSlab(0).GetNumChunks())
+	validatePartitionInvariants(t, p)
+}
+
+func TestPartition_No_Space_For_LRU(t *testing.T) {
+	conf := newEvictTestPartitionConfig()
+	conf.AllocatorConfig.MemLimit = 1 << 12
+	p := NewPartition(conf)
+
+	// the only chunk is used by the entry, no chunk left for the LRU slab
+	_, err := p.leaseGet(1100, []byte{1, 2, 3})
+	assert.Equal(t, ErrNoSpace, err)
+	assert.Equal(t, map[uint64]uint32{}, p.contentMap)
+	assert.Equal(t, uint64(0), p.allocator.GetMemUsage())
+}
+
+func TestPartition_Relocate_Pinned(t *testing.T) {
+	p := newCollisionTestPartition()
+
+	p.putLease(1100, []byte{1, 2, 3}, 111, 0)
+	p.putLease(2200, []byte{2, 3, 4}, 222, 0)
+	p.putLease(3300, []byte{3, 4, 5}, 333, 0)
+
+	addr, _ := p.findEntry(3300, []byte{3, 4, 5})
+	assert.Equal(t, uint32(2*48), addr)
+
+	p.pin(&addr)
+	p.remove(1100, []byte{1, 2, 3})
+	assert.Equal(t, uint32(0), addr)
+	assert.Equal(t, map[uint64]uint32{2200: 48, 3300: 0}, p.contentMap)
+
+	p.unpin()
+	assert.Equal(t, 0, len(p.pinned))
+
+	p.remove(2200, []byte{2, 3, 4})
+	assert.Equal(t, uint32(0), addr)
+}
+
+func TestPartition_Relocate_While_Adding_Timer(t *testing.T) {
+	conf := newEvictTestPartitionConfig()
+	conf.Clock = newFakeClock()
+	conf.TimerTick = time.Second
+	p := NewPartition(conf)
+
+	// fills a chunk of entries and a chunk of LRU list heads
+	perChunk := (1 << 12) / 48
+	for i := 0; i < perChunk; i++ {
+		assert.Equal(t, nil, p.set(uint64(1000+i), []byte{1, 2, 3}, []byte{10, 20}, 0))
+	}
+
+	// the entry is the only one of the third chunk, no chunk left for the timing wheel.
+	// The eviction moves the entry to the first chunk, its old chunk is reused by the timing wheel
+	assert.Equal(t, nil, p.set(5000, []byte{1, 2, 3}, []byte{10, 20}, 10*time.Second))
+
+	_, ok := p.findEntry(5000, []byte{1, 2, 3})
+	assert.True(t, ok)
+	assert.Equal(t, perChunk, len(p.contentMap))
+	assert.Equal(t, uint32(1), p.timer.Size())
+	assert.Equal(t, uint32(perChunk*48), p.admission.Weight()+p.probation.Weight()+p.protected.Weight())
+	validatePartitionInvariants(t, p)
+}
+
+func validatePartitionInvariants(t *testing.T, p *Partition) {
+	entries := map[uint32]uint64{}
+	for hash, addr := range p.contentMap {
+		entries[addr] = hash
+	}
+	for hash, list := range p.collisions {
+		assert.Contains(t, p.contentMap, hash)
+		assert.NotEqual(t, 0, len(list))
+		for _, addr := range list {
+			_, existed := entries[addr]
+			assert.False(t, existed)
+			entries[addr] = hash
+		}
+	}
+
+	for addr, hash := range entries {
+		assert.Equal(t, hash, p.getHeader(addr).hash)
+	}
+
+	numListHeads := 0
+	lists := []lruListType{lruListAdmission, lruListProtected, lruListProbation}
+	for _, listType := range lists {
+		l := p.getLRU(listType)
+		if l.Size() == 0 {
+			continue
+		}
+
+		lruAddr, hash := l.Last()
+		for {
+			numListHeads++
+			header := p.getHeader(p.findEntryByLRU(hash, lruAddr))
+			assert.Equal(t, listType, header.lruList)
+
+			var ok bool
+			lruAddr, hash, ok = l.Prev(lruAddr)
+			if !ok {
+				break
+			}
+		}
+	}
+	assert.Equal(t, len(entries), numListHeads)
+
+	numTimers := uint32(0)
+	for addr := range entries {
+		if p.getHeader(addr).timerAddr != nullAddr {
+			numTimers++
+		}
+	}
+	assert.Equal(t, p.timer.Size(), numTimers)
+	assert.Equal(t, len(entries) == 0, p.allocator.GetMemUsage() == 0)
+}
+
+func TestPartition_Relocate_Stress(t *testing.T) {
+	clock := newFakeClock()
+	p := NewPartition(PartitionConfig{
+		InitAdmissionLimit: 5 * 64,
+		ProtectedRatio:     NewRational(80, 100),
+		MinProtectedLimit:  5 * 64,
+		NumCounters:        1000,
+		SketchMinCacheSize: 100,
+		AllocatorConfig: allocator.Config{
+			MemLimit:     6 << 12,
+			LRUEntrySize: lruEntrySize,
+			Slabs: []allocator.SlabConfig{
+				{
+					ElemSize:     64,
+					ChunkSizeLog: 12,
+				},
+				{
+					ElemSize:     128,
+					ChunkSizeLog: 12,
+				},
+				{
+					ElemSize:     192,
+					ChunkSizeLog: 12,
+				},
+			},
+		},
+		Clock:        clock,
+		TimerTick:    100 * time.Millisecond,
+		SlabAutomove: true,
+	})
+
+	const numKeys = 300
+	keyOf := func(k int) []byte {
+		return []byte(fmt.Sprintf("%04d", k))
+	}
+	// many keys have the same hash
+	hashOf := func(k int) uint64 {
+		return uint64(k % 32)
+	}
+
+	values := map[int][]byte{}
+	leases := map[int]uint64{}
+
+	r := rand.New(rand.NewSource(4321))
+	for i := 0; i < 50000; i++ {
+		k := r.Intn(numKeys)
+		key := keyOf(k)
+		hash := hashOf(k)
+
+		clock.Advance(time.Duration(r.Intn(20)) * time.Millisecond)
+		ttl := time.Duration(r.Intn(3)) * time.Second
+
+		switch r.Intn(11) {
+		case 0, 1, 2:
+			result, err := p.leaseGet(hash, key)
+			assert.Equal(t, nil, err)
+			if result.Status == LeaseGetStatusLeaseGranted {
+				leases[k] = result.LeaseID
+			}
+			if result.Status == LeaseGetStatusExisted {
+				assert.Equal(t, values[k], result.Value)
+			}
+
+		case 3, 4, 5:
+			value := make([]byte, r.Intn(140))
+			r.Read(value)
+
+			status, err := p.leaseSet(hash, key, leases[k], uint64(i), value, ttl)
+			if status == LeaseSetStatusAccepted {
+				assert.Equal(t, nil, err)
+			} else {
+				assert.Equal(t, ErrLeaseMismatch, err)
+			}
+			if status == LeaseSetStatusAccepted {
+				values[k] = value
+			}
+
+		case 6, 7:
+			value, ok := p.getValue(hash, key)
+			if ok {
+				assert.Equal(t, values[k], value)
+			}
+
+		case 8:
+			p.remove(hash, key)
+
+		case 9:
+			p.invalidate(hash, key)
+
+		default:
+			value := make([]byte, r.Intn(140))
+			r.Read(value)
+
+			err := p.set(hash, key, value, ttl)
+			assert.Equal(t, nil, err)
+			values[k] = value
+		}
+
+		if i%500 == 0 {
+			validatePartitionInvariants(t, p)
+		}
+	}
+	validatePartitionInvariants(t, p)
+	assert.Greater(t, len(p.contentMap), 0)
 }
@@ -0,0 +1,58 @@
+package espresso
+
+const (
+	// slabRebalanceDecayPeriod is the number of allocation failures after which the pressures are halved,
+	// old failures fade out when the workload changes
+	slabRebalanceDecayPeriod = 1024
+	// slabRebalanceRatio is the min ratio between the pressure of the receiving class and of the donor class
+	slabRebalanceRatio = 2
+)
+
+// slabRebalancer tracks the eviction pressure of every slab class, the number of allocations that
+// failed because the class had no free slot and the buddy had no free chunk.
+// A chunk is moved from the class having the lowest pressure to a class having much higher pressure,
+// similar to memcached's slab_automove
+type slabRebalancer struct {
+	pressures []uint32
+	total     uint32
+}
+
+func newSlabRebalancer(numSlabs int) slabRebalancer {
+	return slabRebalancer{
+		pressures: make([]uint32, numSlabs),
+	}
+}
+
+func (r *slabRebalancer) recordFailure(slabIndex int) {
+	r.pressures[slabIndex]++
+	r.total++
+
+	if r.total >= slabRebalanceDecayPeriod {
+		for i := range r.pressures {
+			r.pressures[i] /= 2
+		}
+		r.total /= 2
+	}
+}
+
+// findDonor returns the class having the lowest pressure among the classes that can give a chunk to *slabIndex*,
+// returns false if no such class has low enough pressure
+func (r *slabRebalancer) findDonor(slabIndex int, canDonate func(index int) bool) (int, bool) {
+	donor := -1
+	for i, pressure := range r.pressures {
+		if i == slabIndex || !canDonate(i) {
+			continue
+		}
+		if donor < 0 || pressure < r.pressures[donor] {
+			donor = i
+		}
+	}
+
+	if donor < 0 {
+		return 0, false
+	}
+	if r.pressures[slabIndex] <= slabRebalanceRatio*r.pressures[donor] {
+		return 0, false
+	}
+	return donor, true
+}
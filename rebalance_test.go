@@ -0,0 +1,56 @@
+package espresso
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func allHaveChunks(int) bool {
+	return true
+}
+
+func TestSlabRebalancer_Find_Donor(t *testing.T) {
+	r := newSlabRebalancer(3)
+
+	r.recordFailure(1)
+	donor, ok := r.findDonor(1, allHaveChunks)
+	assert.True(t, ok)
+	assert.Equal(t, 0, donor)
+
+	// class 0 has no chunk
+	donor, ok = r.findDonor(1, func(index int) bool { return index != 0 })
+	assert.True(t, ok)
+	assert.Equal(t, 2, donor)
+
+	// no other class has chunks
+	_, ok = r.findDonor(1, func(index int) bool { return index == 1 })
+	assert.False(t, ok)
+
+	// the pressure of class 1 is not much higher than the pressure of the donor
+	r.recordFailure(0)
+	r.recordFailure(2)
+	_, ok = r.findDonor(1, allHaveChunks)
+	assert.False(t, ok)
+
+	r.recordFailure(1)
+	r.recordFailure(1)
+	donor, ok = r.findDonor(1, allHaveChunks)
+	assert.True(t, ok)
+	assert.Equal(t, 0, donor)
+
+	// zero pressure
+	_, ok = r.findDonor(0, allHaveChunks)
+	assert.False(t, ok)
+}
+
+func TestSlabRebalancer_Decay(t *testing.T) {
+	r := newSlabRebalancer(2)
+
+	for i := 0; i < slabRebalanceDecayPeriod-1; i++ {
+		r.recordFailure(0)
+	}
+	r.recordFailure(1)
+
+	assert.Equal(t, []uint32{(slabRebalanceDecayPeriod - 1) / 2, 0}, r.pressures)
+	assert.Equal(t, uint32(slabRebalanceDecayPeriod/2), r.total)
+}
@@ -0,0 +1,513 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/QuangTung97/espresso"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// memcacheBufferSize is the size of the read and write buffers, also the max length of a command line
+	memcacheBufferSize = 16 << 10
+	// maxKeySize is the max length of a key, the same as memcached
+	maxKeySize = 250
+)
+
+var (
+	replyStored    = []byte("STORED\r\n")
+	replyNotStored = []byte("NOT_STORED\r\n")
+	replyExists    = []byte("EXISTS\r\n")
+	replyNotFound  = []byte("NOT_FOUND\r\n")
+	replyDeleted   = []byte("DELETED\r\n")
+	replyTouched   = []byte("TOUCHED\r\n")
+	replyOK        = []byte("OK\r\n")
+	replyEnd       = []byte("END\r\n")
+	replyError     = []byte("ERROR\r\n")
+	replyCRLF      = []byte("\r\n")
+
+	replyBadFormat    = []byte("CLIENT_ERROR bad command line format\r\n")
+	replyBadDataChunk = []byte("CLIENT_ERROR bad data chunk\r\n")
+	replyLineTooLong  = []byte("CLIENT_ERROR line too long\r\n")
+	replyInvalidDelta = []byte("CLIENT_ERROR invalid numeric delta argument\r\n")
+	replyNonNumeric   = []byte("CLIENT_ERROR " + errNonNumeric.Error() + "\r\n")
+	replyTooLarge     = []byte("SERVER_ERROR object too large for cache\r\n")
+	replyOutOfMemory  = []byte("SERVER_ERROR out of memory storing object\r\n")
+
+	noReplyArg = []byte("noreply")
+)
+
+var errLineTooLong = errors.New("line too long")
+
+type memcacheConn struct {
+	s *Server
+	r *bufio.Reader
+	w *bufio.Writer
+
+	// data is the buffer of the data blocks of storage commands
+	data []byte
+	// value is the buffer of the items copied out of the cache
+	value []byte
+}
+
+func (s *Server) serveMemcache(conn net.Conn) {
+	c := &memcacheConn{
+		s: s,
+		r: bufio.NewReaderSize(conn, memcacheBufferSize),
+		w: bufio.NewWriterSize(conn, memcacheBufferSize),
+	}
+
+	for {
+		line, err := c.readLine()
+		if err == errLineTooLong {
+			_, _ = c.w.Write(replyLineTooLong)
+			_ = c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		if !c.handle(line) {
+			_ = c.w.Flush()
+			return
+		}
+
+		// replies of pipelined commands are flushed together
+		if c.r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// readLine returns the line without the trailing \r\n, the line is valid until the next read
+func (c *memcacheConn) readLine() ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errLineTooLong
+	}
+	if err != nil {
+		return nil, err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
+
+// handle returns false if the connection must be closed
+func (c *memcacheConn) handle(line []byte) bool {
+	fields := bytes.Fields(line)
+	if len(fields) == 0 {
+		c.reply(replyError)
+		return true
+	}
+
+	atomic.AddUint64(&c.s.counters.totalCommands, 1)
+
+	args := fields[1:]
+	switch string(fields[0]) {
+	case "get":
+		c.processGet(args, false)
+	case "gets":
+		c.processGet(args, true)
+	case "set":
+		return c.processStorage(storeModeSet, args)
+	case "add":
+		return c.processStorage(storeModeAdd, args)
+	case "replace":
+		return c.processStorage(storeModeReplace, args)
+	case "cas":
+		return c.processStorage(storeModeCAS, args)
+	case "delete":
+		c.processDelete(args)
+	case "touch":
+		c.processTouch(args)
+	case "incr":
+		c.processIncrDecr(args, true)
+	case "decr":
+		c.processIncrDecr(args, false)
+	case "flush_all":
+		c.processFlushAll(args)
+	case "mg":
+		c.processMetaGet(args)
+	case "ms":
+		return c.processMetaSet(args)
+	case "md":
+		c.processMetaDelete(args)
+	case "mn":
+		c.reply(replyMetaNoOp)
+	case "stats":
+		c.processStats(args)
+	case "version":
+		c.reply([]byte("VERSION " + Version + "\r\n"))
+	case "verbosity":
+		c.reply(replyOK)
+	case "quit":
+		return false
+	default:
+		c.reply(replyError)
+	}
+	return true
+}
+
+func (c *memcacheConn) reply(msg []byte) {
+	_, _ = c.w.Write(msg)
+}
+
+// replyUnlessNoReply writes *msg* if the last argument is NOT noreply
+func (c *memcacheConn) replyUnlessNoReply(noReply bool, msg []byte) {
+	if !noReply {
+		c.reply(msg)
+	}
+}
+
+// splitNoReply removes the optional noreply from the end of *args*
+func splitNoReply(args [][]byte) ([][]byte, bool) {
+	if len(args) > 0 && bytes.Equal(args[len(args)-1], noReplyArg) {
+		return args[:len(args)-1], true
+	}
+	return args, false
+}
+
+func validKey(key []byte) bool {
+	return len(key) <= maxKeySize
+}
+
+func (c *memcacheConn) replyStoreError(err error) {
+	if err == espresso.ErrValueTooLarge {
+		c.reply(replyTooLarge)
+		return
+	}
+	c.reply(replyOutOfMemory)
+}
+
+func (c *memcacheConn) processGet(keys [][]byte, withCAS bool) {
+	if len(keys) == 0 {
+		c.reply(replyError)
+		return
+	}
+	for _, key := range keys {
+		if !validKey(key) {
+			c.reply(replyBadFormat)
+			return
+		}
+	}
+
+	counters := &c.s.counters
+	for _, key := range keys {
+		atomic.AddUint64(&counters.cmdGet, 1)
+
+		it, value, ok := c.s.store.get(key, c.value)
+		c.value = value
+		countHit(ok, &counters.getHits, &counters.getMisses)
+		if !ok {
+			continue
+		}
+
+		_, _ = c.w.WriteString("VALUE ")
+		_, _ = c.w.Write(key)
+		_, _ = fmt.Fprintf(c.w, " %d %d", it.flags, len(it.data))
+		if withCAS {
+			_, _ = fmt.Fprintf(c.w, " %d", it.cas)
+		}
+		c.reply(replyCRLF)
+		c.reply(it.data)
+		c.reply(replyCRLF)
+	}
+	c.reply(replyEnd)
+}
+
+// readDataBlock reads the data block of a storage command and replies the errors of the block.
+// Returns false for *ok* if the command must NOT be executed, and false for *alive* if the connection must be closed
+func (c *memcacheConn) readDataBlock(size int64) (data []byte, ok bool, alive bool) {
+	if size > int64(c.s.maxItemSize) {
+		// the data block is swallowed
+		if _, err := c.r.Discard(int(size) + 2); err != nil {
+			return nil, false, false
+		}
+		c.reply(replyTooLarge)
+		return nil, false, true
+	}
+
+	data, ok, err := c.readData(int(size))
+	if err != nil {
+		return nil, false, false
+	}
+	if !ok {
+		c.reply(replyBadDataChunk)
+		return nil, false, true
+	}
+	return data, true, true
+}
+
+// readData reads the data block of a storage command, returns false if the block does NOT end with \r\n
+func (c *memcacheConn) readData(size int) ([]byte, bool, error) {
+	if cap(c.data) < size+2 {
+		c.data = make([]byte, size+2)
+	}
+	data := c.data[:size+2]
+
+	if _, err := io.ReadFull(c.r, data); err != nil {
+		return nil, false, err
+	}
+	if data[size] != '\r' || data[size+1] != '\n' {
+		return nil, false, nil
+	}
+	return data[:size], true, nil
+}
+
+// processStorage handles set, add, replace and cas, returns false if the connection must be closed
+func (c *memcacheConn) processStorage(mode storeMode, args [][]byte) bool {
+	args, noReply := splitNoReply(args)
+
+	numArgs := 4
+	if mode == storeModeCAS {
+		numArgs = 5
+	}
+	if len(args) != numArgs || !validKey(args[0]) {
+		c.reply(replyBadFormat)
+		return true
+	}
+
+	key := args[0]
+	flags, err1 := strconv.ParseUint(string(args[1]), 10, 32)
+	exptime, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	size, err3 := strconv.ParseInt(string(args[3]), 10, 32)
+	if err1 != nil || err2 != nil || err3 != nil || size < 0 {
+		c.reply(replyBadFormat)
+		return true
+	}
+	var cas uint64
+	if mode == storeModeCAS {
+		var err error
+		cas, err = strconv.ParseUint(string(args[4]), 10, 64)
+		if err != nil {
+			c.reply(replyBadFormat)
+			return true
+		}
+	}
+
+	// the key is only valid until the next read
+	key = append([]byte(nil), key...)
+	data, ok, alive := c.readDataBlock(size)
+	if !ok {
+		return alive
+	}
+
+	counters := &c.s.counters
+	atomic.AddUint64(&counters.cmdSet, 1)
+
+	expire := memcacheExpire(c.s.store.now(), exptime)
+	result, _, err := c.s.store.store(mode, key, uint32(flags), expire, data, cas)
+	if err != nil {
+		c.replyStoreError(err)
+		return true
+	}
+
+	switch result {
+	case storeResultStored:
+		if mode == storeModeCAS {
+			atomic.AddUint64(&counters.casHits, 1)
+		}
+		c.replyUnlessNoReply(noReply, replyStored)
+	case storeResultNotStored:
+		c.replyUnlessNoReply(noReply, replyNotStored)
+	case storeResultExists:
+		atomic.AddUint64(&counters.casBadval, 1)
+		c.replyUnlessNoReply(noReply, replyExists)
+	default:
+		atomic.AddUint64(&counters.casMisses, 1)
+		c.replyUnlessNoReply(noReply, replyNotFound)
+	}
+	return true
+}
+
+func (c *memcacheConn) processDelete(args [][]byte) {
+	args, noReply := splitNoReply(args)
+
+	// the legacy form "delete <key> 0" is accepted
+	if len(args) == 2 && bytes.Equal(args[1], []byte("0")) {
+		args = args[:1]
+	}
+	if len(args) != 1 || !validKey(args[0]) {
+		c.reply(replyBadFormat)
+		return
+	}
+
+	ok := c.s.store.delete(args[0])
+	countHit(ok, &c.s.counters.deleteHits, &c.s.counters.deleteMisses)
+	if ok {
+		c.replyUnlessNoReply(noReply, replyDeleted)
+	} else {
+		c.replyUnlessNoReply(noReply, replyNotFound)
+	}
+}
+
+func (c *memcacheConn) processTouch(args [][]byte) {
+	args, noReply := splitNoReply(args)
+	if len(args) != 2 || !validKey(args[0]) {
+		c.reply(replyBadFormat)
+		return
+	}
+	exptime, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		c.reply(replyBadFormat)
+		return
+	}
+
+	atomic.AddUint64(&c.s.counters.cmdTouch, 1)
+	ok, err := c.s.store.touch(args[0], memcacheExpire(c.s.store.now(), exptime))
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+
+	countHit(ok, &c.s.counters.touchHits, &c.s.counters.touchMisses)
+	if ok {
+		c.replyUnlessNoReply(noReply, replyTouched)
+	} else {
+		c.replyUnlessNoReply(noReply, replyNotFound)
+	}
+}
+
+func (c *memcacheConn) processIncrDecr(args [][]byte, incr bool) {
+	args, noReply := splitNoReply(args)
+	if len(args) != 2 || !validKey(args[0]) {
+		c.reply(replyBadFormat)
+		return
+	}
+	delta, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		c.reply(replyInvalidDelta)
+		return
+	}
+
+	value, ok, err := c.s.store.incrDecr(args[0], delta, incr)
+	if err == errNonNumeric {
+		c.reply(replyNonNumeric)
+		return
+	}
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+
+	counters := &c.s.counters
+	if incr {
+		countHit(ok, &counters.incrHits, &counters.incrMisses)
+	} else {
+		countHit(ok, &counters.decrHits, &counters.decrMisses)
+	}
+
+	if !ok {
+		c.replyUnlessNoReply(noReply, replyNotFound)
+		return
+	}
+	c.replyUnlessNoReply(noReply, []byte(strconv.FormatUint(value, 10)+"\r\n"))
+}
+
+func (c *memcacheConn) processFlushAll(args [][]byte) {
+	args, noReply := splitNoReply(args)
+	if len(args) > 1 {
+		c.reply(replyBadFormat)
+		return
+	}
+
+	delay := int64(0)
+	if len(args) == 1 {
+		var err error
+		delay, err = strconv.ParseInt(string(args[0]), 10, 64)
+		if err != nil || delay < 0 {
+			c.reply(replyBadFormat)
+			return
+		}
+	}
+
+	atomic.AddUint64(&c.s.counters.cmdFlush, 1)
+	c.s.store.flushAll(time.Duration(delay) * time.Second)
+	c.replyUnlessNoReply(noReply, replyOK)
+}
+
+func (c *memcacheConn) processStats(args [][]byte) {
+	if len(args) > 0 {
+		// only the general-purpose statistics are supported
+		c.reply(replyError)
+		return
+	}
+
+	for _, stat := range c.s.stats() {
+		_, _ = fmt.Fprintf(c.w, "STAT %s %s\r\n", stat.name, stat.value)
+	}
+	c.reply(replyEnd)
+}
+
+type stat struct {
+	name  string
+	value string
+}
+
+// stats returns the statistics of the stats command, using the names of memcached where applicable
+func (s *Server) stats() []stat {
+	now := s.store.clock.Now()
+	cacheStats := s.cache.Stats()
+
+	limit := uint64(0)
+	for _, p := range cacheStats.Partitions {
+		limit += p.Allocator.TotalBytes
+	}
+	evictions := cacheStats.AdmissionEvictions + cacheStats.ProbationEvictions + cacheStats.ProtectedEvictions
+
+	counters := &s.counters
+	load := func(v *uint64) string {
+		return strconv.FormatUint(atomic.LoadUint64(v), 10)
+	}
+	formatUint := func(v uint64) string {
+		return strconv.FormatUint(v, 10)
+	}
+
+	return []stat{
+		{name: "pid", value: strconv.Itoa(os.Getpid())},
+		{name: "uptime", value: strconv.FormatInt(int64(now.Sub(s.startTime)/time.Second), 10)},
+		{name: "time", value: strconv.FormatInt(now.Unix(), 10)},
+		{name: "version", value: Version},
+		{name: "curr_connections", value: strconv.FormatInt(atomic.LoadInt64(&counters.currConnections), 10)},
+		{name: "total_connections", value: load(&counters.totalConnections)},
+		{name: "cmd_get", value: load(&counters.cmdGet)},
+		{name: "cmd_set", value: load(&counters.cmdSet)},
+		{name: "cmd_flush", value: load(&counters.cmdFlush)},
+		{name: "cmd_touch", value: load(&counters.cmdTouch)},
+		{name: "get_hits", value: load(&counters.getHits)},
+		{name: "get_misses", value: load(&counters.getMisses)},
+		{name: "delete_misses", value: load(&counters.deleteMisses)},
+		{name: "delete_hits", value: load(&counters.deleteHits)},
+		{name: "incr_misses", value: load(&counters.incrMisses)},
+		{name: "incr_hits", value: load(&counters.incrHits)},
+		{name: "decr_misses", value: load(&counters.decrMisses)},
+		{name: "decr_hits", value: load(&counters.decrHits)},
+		{name: "cas_misses", value: load(&counters.casMisses)},
+		{name: "cas_hits", value: load(&counters.casHits)},
+		{name: "cas_badval", value: load(&counters.casBadval)},
+		{name: "touch_hits", value: load(&counters.touchHits)},
+		{name: "touch_misses", value: load(&counters.touchMisses)},
+		{name: "curr_items", value: formatUint(cacheStats.NumEntries)},
+		{name: "bytes", value: formatUint(cacheStats.MemUsage)},
+		{name: "limit_maxbytes", value: formatUint(limit)},
+		{name: "evictions", value: formatUint(evictions)},
+		{name: "lease_granted", value: formatUint(cacheStats.LeaseGranted)},
+		{name: "lease_rejected", value: formatUint(cacheStats.LeaseRejected)},
+		{name: "reclaimed", value: formatUint(cacheStats.Expirations)},
+		{name: "admission_rejections", value: formatUint(cacheStats.AdmissionRejections)},
+		{name: "slab_reassign_moves", value: formatUint(cacheStats.SlabMoves)},
+	}
+}
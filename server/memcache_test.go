@@ -0,0 +1,524 @@
+package server
+
+import (
+	"bufio"
+	"github.com/QuangTung97/espresso/espressotest"
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	mut sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now: time.Date(2021, 6, 10, 8, 0, 0, 0, time.UTC),
+	}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mut.Lock()
+	defer c.mut.Unlock()
+	c.now = c.now.Add(d)
+}
+
+type testServer struct {
+	server *Server
+	clock  *fakeClock
+	addr   string
+	// respAddr is the address of the RESP listener
+	respAddr string
+	done     chan error
+}
+
+func newTestServer(t *testing.T) *testServer {
+	clock := newFakeClock()
+	s := New(espressotest.NewCache(espressotest.Config{Clock: clock}), Config{Clock: clock, MaxItemSize: 64 << 10})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+
+	respListener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+
+	done := make(chan error, 2)
+	go func() {
+		done <- s.Serve(l)
+	}()
+	go func() {
+		done <- s.ServeRESP(respListener)
+	}()
+
+	ts := &testServer{
+		server:   s,
+		clock:    clock,
+		addr:     l.Addr().String(),
+		respAddr: respListener.Addr().String(),
+		done:     done,
+	}
+	t.Cleanup(ts.close)
+	return ts
+}
+
+func (ts *testServer) close() {
+	_ = ts.server.Close()
+	<-ts.done
+	<-ts.done
+}
+
+func (ts *testServer) client() *memcache.Client {
+	return memcache.New(ts.addr)
+}
+
+type rawConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (ts *testServer) dial(t *testing.T) *rawConn {
+	conn, err := net.Dial("tcp", ts.addr)
+	assert.Equal(t, nil, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return &rawConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *rawConn) send(s string) {
+	_, err := c.conn.Write([]byte(s))
+	assert.Equal(c.t, nil, err)
+}
+
+// expect reads len(*s*) bytes and compares them to *s*
+func (c *rawConn) expect(s string) {
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, len(s))
+	_, err := io.ReadFull(c.r, buf)
+	assert.Equal(c.t, nil, err)
+	assert.Equal(c.t, s, string(buf))
+}
+
+func TestMemcache_Set_Get(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	err := mc.Set(&memcache.Item{Key: "key01", Value: []byte("value01"), Flags: 12})
+	assert.Equal(t, nil, err)
+
+	item, err := mc.Get("key01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("value01"), item.Value)
+	assert.Equal(t, uint32(12), item.Flags)
+
+	_, err = mc.Get("key02")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	// an empty value
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key03"}))
+	item, err = mc.Get("key03")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(item.Value))
+}
+
+func TestMemcache_Get_Multi(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key01", Value: []byte("value01")}))
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key02", Value: []byte("value02")}))
+
+	items, err := mc.GetMulti([]string{"key01", "key02", "key03"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(items))
+	assert.Equal(t, []byte("value01"), items["key01"].Value)
+	assert.Equal(t, []byte("value02"), items["key02"].Value)
+}
+
+func TestMemcache_Add_Replace(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	err := mc.Replace(&memcache.Item{Key: "key01", Value: []byte("value01")})
+	assert.Equal(t, memcache.ErrNotStored, err)
+
+	err = mc.Add(&memcache.Item{Key: "key01", Value: []byte("value01")})
+	assert.Equal(t, nil, err)
+
+	err = mc.Add(&memcache.Item{Key: "key01", Value: []byte("value02")})
+	assert.Equal(t, memcache.ErrNotStored, err)
+
+	err = mc.Replace(&memcache.Item{Key: "key01", Value: []byte("value03")})
+	assert.Equal(t, nil, err)
+
+	item, err := mc.Get("key01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("value03"), item.Value)
+}
+
+func TestMemcache_CompareAndSwap(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key01", Value: []byte("value01")}))
+
+	item, err := mc.Get("key01")
+	assert.Equal(t, nil, err)
+
+	other, err := mc.Get("key01")
+	assert.Equal(t, nil, err)
+
+	item.Value = []byte("value02")
+	assert.Equal(t, nil, mc.CompareAndSwap(item))
+
+	other.Value = []byte("value03")
+	assert.Equal(t, memcache.ErrCASConflict, mc.CompareAndSwap(other))
+
+	item, err = mc.Get("key01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("value02"), item.Value)
+
+	assert.Equal(t, nil, mc.Delete("key01"))
+	assert.Equal(t, memcache.ErrCacheMiss, mc.CompareAndSwap(item))
+}
+
+func TestMemcache_Delete(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key01", Value: []byte("value01")}))
+	assert.Equal(t, nil, mc.Delete("key01"))
+	assert.Equal(t, memcache.ErrCacheMiss, mc.Delete("key01"))
+
+	_, err := mc.Get("key01")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+}
+
+func TestMemcache_Expiration(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key01", Value: []byte("value01"), Expiration: 10}))
+
+	// a unix timestamp
+	expire := ts.clock.Now().Add(20 * time.Second).Unix()
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key02", Value: []byte("value02"), Expiration: int32(expire)}))
+
+	ts.clock.Advance(9 * time.Second)
+	_, err := mc.Get("key01")
+	assert.Equal(t, nil, err)
+
+	ts.clock.Advance(time.Second)
+	_, err = mc.Get("key01")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	_, err = mc.Get("key02")
+	assert.Equal(t, nil, err)
+
+	ts.clock.Advance(10 * time.Second)
+	_, err = mc.Get("key02")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	// a negative expiration time expires immediately
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key03", Value: []byte("value03"), Expiration: -1}))
+	_, err = mc.Get("key03")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+}
+
+func TestMemcache_Touch(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key01", Value: []byte("value01"), Flags: 3, Expiration: 10}))
+
+	ts.clock.Advance(5 * time.Second)
+	assert.Equal(t, nil, mc.Touch("key01", 10))
+
+	ts.clock.Advance(9 * time.Second)
+	item, err := mc.Get("key01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("value01"), item.Value)
+	assert.Equal(t, uint32(3), item.Flags)
+
+	ts.clock.Advance(time.Second)
+	_, err = mc.Get("key01")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	assert.Equal(t, memcache.ErrCacheMiss, mc.Touch("key01", 10))
+}
+
+func TestMemcache_Incr_Decr(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	_, err := mc.Increment("counter", 1)
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "counter", Value: []byte("10"), Expiration: 10}))
+
+	value, err := mc.Increment("counter", 5)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(15), value)
+
+	value, err = mc.Decrement("counter", 3)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(12), value)
+
+	// decrementing below zero results in zero
+	value, err = mc.Decrement("counter", 100)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(0), value)
+
+	// incrementing wraps around at 64 bits
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "max", Value: []byte("18446744073709551615")}))
+	value, err = mc.Increment("max", 2)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, uint64(1), value)
+
+	// the expiration time is kept
+	ts.clock.Advance(10 * time.Second)
+	_, err = mc.Get("counter")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "text", Value: []byte("abc")}))
+	_, err = mc.Increment("text", 1)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "non-numeric value")
+}
+
+func TestMemcache_Flush_All(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key01", Value: []byte("value01")}))
+	assert.Equal(t, nil, mc.DeleteAll())
+
+	_, err := mc.Get("key01")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+	assert.Equal(t, nil, mc.Add(&memcache.Item{Key: "key01", Value: []byte("value02")}))
+
+	item, err := mc.Get("key01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []byte("value02"), item.Value)
+}
+
+func TestMemcache_Flush_All_Delay(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+	c := ts.dial(t)
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key01", Value: []byte("value01")}))
+
+	c.send("flush_all 10\r\n")
+	c.expect("OK\r\n")
+
+	ts.clock.Advance(9 * time.Second)
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key02", Value: []byte("value02")}))
+	_, err := mc.Get("key01")
+	assert.Equal(t, nil, err)
+
+	ts.clock.Advance(time.Second)
+	_, err = mc.Get("key01")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+	_, err = mc.Get("key02")
+	assert.Equal(t, memcache.ErrCacheMiss, err)
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key03", Value: []byte("value03")}))
+	_, err = mc.Get("key03")
+	assert.Equal(t, nil, err)
+}
+
+func TestMemcache_Large_Value(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+
+	value := []byte(strings.Repeat("a", 40<<10))
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key01", Value: value}))
+
+	item, err := mc.Get("key01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, value, item.Value)
+
+	// larger than Config.MaxItemSize
+	err = mc.Set(&memcache.Item{Key: "key02", Value: []byte(strings.Repeat("a", 64<<10+1))})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "object too large for cache")
+
+	// the connection is still usable
+	item, err = mc.Get("key01")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, value, item.Value)
+}
+
+func TestMemcache_Raw_Protocol(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("set key01 5 0 7\r\nvalue01\r\n")
+	c.expect("STORED\r\n")
+
+	c.send("gets key01 key02\r\n")
+	c.expect("VALUE key01 5 7 1\r\nvalue01\r\nEND\r\n")
+
+	c.send("cas key01 0 0 7 2\r\nvalue02\r\n")
+	c.expect("EXISTS\r\n")
+
+	c.send("cas key01 0 0 7 1\r\nvalue02\r\n")
+	c.expect("STORED\r\n")
+
+	c.send("delete key01 0\r\n")
+	c.expect("DELETED\r\n")
+
+	c.send("unknown\r\n")
+	c.expect("ERROR\r\n")
+
+	c.send("\r\n")
+	c.expect("ERROR\r\n")
+
+	c.send("get\r\n")
+	c.expect("ERROR\r\n")
+
+	c.send("set key01 0 0\r\n")
+	c.expect("CLIENT_ERROR bad command line format\r\n")
+
+	c.send("set key01 0 0 3\r\nabcde\r\n")
+	c.expect("CLIENT_ERROR bad data chunk\r\n")
+	c.expect("ERROR\r\n")
+
+	c.send("incr key01 abc\r\n")
+	c.expect("CLIENT_ERROR invalid numeric delta argument\r\n")
+
+	c.send("get " + strings.Repeat("k", maxKeySize+1) + "\r\n")
+	c.expect("CLIENT_ERROR bad command line format\r\n")
+
+	c.send("version\r\n")
+	c.expect("VERSION " + Version + "\r\n")
+
+	c.send("verbosity 1\r\n")
+	c.expect("OK\r\n")
+}
+
+func TestMemcache_Pipelining_And_No_Reply(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("set key01 0 0 2 noreply\r\nv1\r\n" +
+		"set key02 0 0 2\r\nv2\r\n" +
+		"add key01 0 0 2 noreply\r\nv3\r\n" +
+		"incr key03 1 noreply\r\n" +
+		"touch key01 10 noreply\r\n" +
+		"delete key02 noreply\r\n" +
+		"flush_all noreply\r\n" +
+		"set key04 0 0 2\r\nv4\r\n" +
+		"get key01 key02 key04\r\n",
+	)
+	c.expect("STORED\r\n")
+	c.expect("STORED\r\n")
+	c.expect("VALUE key04 0 2\r\nv4\r\nEND\r\n")
+}
+
+func TestMemcache_Quit(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("quit\r\n")
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.r.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestMemcache_Line_Too_Long(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("get " + strings.Repeat("k ", memcacheBufferSize) + "\r\n")
+	c.expect("CLIENT_ERROR line too long\r\n")
+}
+
+func TestMemcache_Stats(t *testing.T) {
+	ts := newTestServer(t)
+	mc := ts.client()
+	c := ts.dial(t)
+
+	assert.Equal(t, nil, mc.Set(&memcache.Item{Key: "key01", Value: []byte("value01")}))
+	_, _ = mc.Get("key01")
+	_, _ = mc.Get("key02")
+	_ = mc.Delete("key03")
+
+	ts.clock.Advance(3 * time.Second)
+
+	c.send("stats\r\n")
+	stats := map[string]string{}
+	for {
+		_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := c.r.ReadString('\n')
+		assert.Equal(t, nil, err)
+		if line == "END\r\n" {
+			break
+		}
+		fields := strings.Fields(line)
+		assert.Equal(t, 3, len(fields))
+		assert.Equal(t, "STAT", fields[0])
+		stats[fields[1]] = fields[2]
+	}
+
+	assert.Equal(t, "3", stats["uptime"])
+	assert.Equal(t, Version, stats["version"])
+	assert.Equal(t, "2", stats["curr_connections"])
+	assert.Equal(t, "2", stats["cmd_get"])
+	assert.Equal(t, "1", stats["cmd_set"])
+	assert.Equal(t, "1", stats["get_hits"])
+	assert.Equal(t, "1", stats["get_misses"])
+	assert.Equal(t, "1", stats["delete_misses"])
+	assert.Equal(t, "1", stats["curr_items"])
+	assert.Equal(t, "4194304", stats["limit_maxbytes"])
+
+	c.send("stats items\r\n")
+	c.expect("ERROR\r\n")
+}
+
+func TestServer_Close(t *testing.T) {
+	clock := newFakeClock()
+	s := New(espressotest.NewCache(espressotest.Config{Clock: clock}), Config{Clock: clock})
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.Serve(l)
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	assert.Equal(t, nil, err)
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("version\r\n"))
+	assert.Equal(t, nil, err)
+	_, err = bufio.NewReader(conn).ReadString('\n')
+	assert.Equal(t, nil, err)
+
+	assert.Equal(t, nil, s.Close())
+	assert.Equal(t, ErrServerClosed, <-done)
+
+	// the connection is closed by the server
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ErrServerClosed, s.Serve(l))
+}
@@ -0,0 +1,447 @@
+package server
+
+import (
+	"github.com/QuangTung97/espresso"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	replyMetaNoOp    = []byte("MN\r\n")
+	replyInvalidFlag = []byte("CLIENT_ERROR invalid flag\r\n")
+	replyInvalidMode = []byte("CLIENT_ERROR invalid mode for ms\r\n")
+)
+
+// leaseTokenBit is set in the CAS tokens of leases, separating them from the CAS uniques of items
+const leaseTokenBit = 1 << 63
+
+// metaFlag is a flag of a meta command: a single character optionally followed by a token
+type metaFlag struct {
+	name  byte
+	token []byte
+}
+
+// parseMetaFlags returns false if a flag is NOT in *allowed*
+func parseMetaFlags(args [][]byte, allowed string) ([]metaFlag, bool) {
+	flags := make([]metaFlag, 0, len(args))
+	for _, arg := range args {
+		if !isAllowedFlag(arg[0], allowed) {
+			return nil, false
+		}
+		flags = append(flags, metaFlag{name: arg[0], token: arg[1:]})
+	}
+	return flags, true
+}
+
+func isAllowedFlag(name byte, allowed string) bool {
+	for i := 0; i < len(allowed); i++ {
+		if allowed[i] == name {
+			return true
+		}
+	}
+	return false
+}
+
+// appendMetaFlag appends a returned flag with a numeric token
+func appendMetaFlag(buf []byte, name byte, value int64) []byte {
+	buf = append(buf, ' ', name)
+	return strconv.AppendInt(buf, value, 10)
+}
+
+// appendKeyOpaque appends the returned flags k and O, which are supported by all meta commands
+func appendKeyOpaque(buf []byte, f metaFlag, key []byte) []byte {
+	switch f.name {
+	case 'k':
+		buf = append(buf, ' ', 'k')
+		buf = append(buf, key...)
+	case 'O':
+		buf = append(buf, ' ', 'O')
+		buf = append(buf, f.token...)
+	}
+	return buf
+}
+
+// remainingTTL is the token of the flag t: the remaining seconds rounded up, or -1 if the item never expires
+func remainingTTL(now time.Time, expire int64) int64 {
+	if expire == 0 {
+		return -1
+	}
+	remaining := expire - now.UnixNano()
+	return (remaining + int64(time.Second) - 1) / int64(time.Second)
+}
+
+type metaGetResult struct {
+	it    item
+	found bool
+
+	leaseID uint64
+	// win is the flag W: a lease is granted, the caller must set the value with ms and the lease ID as its CAS token
+	win bool
+	// stale is the flag X: the item is the stale value of an invalidated key
+	stale bool
+	// alreadyWon is the flag Z: the lease is held by another caller
+	alreadyWon bool
+}
+
+// processMetaGet handles mg. The flag N turns the command into a LeaseGet:
+//   - LeaseGetStatusExisted is a normal hit.
+//   - LeaseGetStatusLeaseGranted has the flag W, the flag c returns the lease ID with leaseTokenBit set.
+//   - LeaseGetStatusLeaseRejected has the flag Z.
+//
+// The stale value of an invalidated key is returned with the flag X, a lease without any value results in EN.
+// The token of N is accepted for compatibility, leases expire after PartitionConfig.LeaseTimeout
+func (c *memcacheConn) processMetaGet(args [][]byte) {
+	if len(args) == 0 || !validKey(args[0]) {
+		c.reply(replyBadFormat)
+		return
+	}
+	key := args[0]
+
+	flags, ok := parseMetaFlags(args[1:], "cfkOqstvNT")
+	if !ok {
+		c.reply(replyInvalidFlag)
+		return
+	}
+
+	withValue := false
+	quiet := false
+	lease := false
+	touch := false
+	exptime := int64(0)
+	for _, f := range flags {
+		switch f.name {
+		case 'v':
+			withValue = true
+		case 'q':
+			quiet = true
+		case 'N':
+			lease = true
+		case 'T':
+			var err error
+			exptime, err = strconv.ParseInt(string(f.token), 10, 64)
+			if err != nil {
+				c.reply(replyBadFormat)
+				return
+			}
+			touch = true
+		}
+	}
+
+	if touch {
+		atomic.AddUint64(&c.s.counters.cmdTouch, 1)
+		if _, err := c.s.store.touch(key, memcacheExpire(c.s.store.now(), exptime)); err != nil {
+			c.replyStoreError(err)
+			return
+		}
+	}
+
+	result, err := c.metaGet(key, lease)
+	if err != nil {
+		c.replyStoreError(err)
+		return
+	}
+
+	counters := &c.s.counters
+	atomic.AddUint64(&counters.cmdGet, 1)
+	countHit(result.found && !result.stale, &counters.getHits, &counters.getMisses)
+
+	if !result.found && !result.win && !result.alreadyWon {
+		if !quiet {
+			c.reply([]byte("EN\r\n"))
+		}
+		return
+	}
+
+	it := result.it
+	var buf []byte
+	switch {
+	case result.found && withValue:
+		buf = append(buf, "VA "...)
+		buf = strconv.AppendInt(buf, int64(len(it.data)), 10)
+	case result.found:
+		buf = append(buf, "HD"...)
+	default:
+		buf = append(buf, "EN"...)
+	}
+
+	now := c.s.store.clock.Now()
+	for _, f := range flags {
+		switch {
+		case f.name == 'c' && result.win:
+			buf = append(buf, " c"...)
+			buf = strconv.AppendUint(buf, result.leaseID|leaseTokenBit, 10)
+		case f.name == 'c' && result.found:
+			buf = append(buf, " c"...)
+			buf = strconv.AppendUint(buf, it.cas, 10)
+		case f.name == 'f' && result.found:
+			buf = appendMetaFlag(buf, 'f', int64(it.flags))
+		case f.name == 's' && result.found:
+			buf = appendMetaFlag(buf, 's', int64(len(it.data)))
+		case f.name == 't' && result.found:
+			buf = appendMetaFlag(buf, 't', remainingTTL(now, it.expire))
+		default:
+			buf = appendKeyOpaque(buf, f, key)
+		}
+	}
+
+	if result.win {
+		buf = append(buf, " W"...)
+	}
+	if result.stale {
+		buf = append(buf, " X"...)
+	}
+	if result.alreadyWon {
+		buf = append(buf, " Z"...)
+	}
+	buf = append(buf, "\r\n"...)
+	c.reply(buf)
+
+	if result.found && withValue {
+		c.reply(it.data)
+		c.reply(replyCRLF)
+	}
+}
+
+func (c *memcacheConn) metaGet(key []byte, lease bool) (metaGetResult, error) {
+	if !lease {
+		it, value, ok := c.s.store.get(key, c.value)
+		c.value = value
+		return metaGetResult{it: it, found: ok}, nil
+	}
+
+	result, err := c.s.store.leaseGet(key)
+	if err != nil {
+		return metaGetResult{}, err
+	}
+	return metaGetResult{
+		it:    result.it,
+		found: result.hasItem,
+
+		leaseID:    result.leaseID,
+		win:        result.status == espresso.LeaseGetStatusLeaseGranted,
+		stale:      result.stale(),
+		alreadyWon: result.status == espresso.LeaseGetStatusLeaseRejected,
+	}, nil
+}
+
+// processMetaSet handles ms, returns false if the connection must be closed.
+// The CAS token of the flag C is compared with the CAS unique of the item, a token with leaseTokenBit set
+// is a lease won by mg with the flag N and the value is set with LeaseSet
+func (c *memcacheConn) processMetaSet(args [][]byte) bool {
+	if len(args) < 2 || !validKey(args[0]) {
+		c.reply(replyBadFormat)
+		return true
+	}
+	size, err := strconv.ParseInt(string(args[1]), 10, 32)
+	if err != nil || size < 0 {
+		c.reply(replyBadFormat)
+		return true
+	}
+
+	// the key and the flags are only valid until the next read
+	key := append([]byte(nil), args[0]...)
+	flags, flagsOK := parseMetaFlags(copyArgs(args[2:]), "cCFkOqTM")
+
+	data, ok, alive := c.readDataBlock(size)
+	if !ok {
+		return alive
+	}
+	if !flagsOK {
+		c.reply(replyInvalidFlag)
+		return true
+	}
+
+	mode := storeModeSet
+	quiet := false
+	hasCAS := false
+	var cas uint64
+	var clientFlags uint64
+	var exptime int64
+	for _, f := range flags {
+		var err error
+		switch f.name {
+		case 'q':
+			quiet = true
+		case 'C':
+			hasCAS = true
+			cas, err = strconv.ParseUint(string(f.token), 10, 64)
+		case 'F':
+			clientFlags, err = strconv.ParseUint(string(f.token), 10, 32)
+		case 'T':
+			exptime, err = strconv.ParseInt(string(f.token), 10, 64)
+		case 'M':
+			mode, ok = parseMetaSetMode(f.token)
+			if !ok {
+				c.reply(replyInvalidMode)
+				return true
+			}
+		}
+		if err != nil {
+			c.reply(replyBadFormat)
+			return true
+		}
+	}
+	if hasCAS {
+		if mode != storeModeSet {
+			c.reply(replyInvalidMode)
+			return true
+		}
+		mode = storeModeCAS
+	}
+
+	counters := &c.s.counters
+	atomic.AddUint64(&counters.cmdSet, 1)
+
+	expire := memcacheExpire(c.s.store.now(), exptime)
+	var result storeResult
+	var newCAS uint64
+	if mode == storeModeCAS && cas&leaseTokenBit != 0 {
+		result, newCAS, err = c.s.store.leaseSet(key, cas&^leaseTokenBit, uint32(clientFlags), expire, data)
+	} else {
+		result, newCAS, err = c.s.store.store(mode, key, uint32(clientFlags), expire, data, cas)
+	}
+	if err != nil {
+		c.replyStoreError(err)
+		return true
+	}
+
+	var buf []byte
+	switch result {
+	case storeResultStored:
+		if mode == storeModeCAS {
+			atomic.AddUint64(&counters.casHits, 1)
+		}
+		if quiet {
+			return true
+		}
+		buf = append(buf, "HD"...)
+	case storeResultNotStored:
+		buf = append(buf, "NS"...)
+	case storeResultExists:
+		atomic.AddUint64(&counters.casBadval, 1)
+		buf = append(buf, "EX"...)
+	default:
+		atomic.AddUint64(&counters.casMisses, 1)
+		buf = append(buf, "NF"...)
+	}
+
+	for _, f := range flags {
+		if f.name == 'c' && result == storeResultStored {
+			buf = append(buf, " c"...)
+			buf = strconv.AppendUint(buf, newCAS, 10)
+		} else {
+			buf = appendKeyOpaque(buf, f, key)
+		}
+	}
+	buf = append(buf, "\r\n"...)
+	c.reply(buf)
+	return true
+}
+
+func copyArgs(args [][]byte) [][]byte {
+	result := make([][]byte, 0, len(args))
+	for _, arg := range args {
+		result = append(result, append([]byte(nil), arg...))
+	}
+	return result
+}
+
+// parseMetaSetMode supports the modes E (add), R (replace) and S (set)
+func parseMetaSetMode(token []byte) (storeMode, bool) {
+	if len(token) != 1 {
+		return 0, false
+	}
+	switch token[0] {
+	case 'E', 'e':
+		return storeModeAdd, true
+	case 'R', 'r':
+		return storeModeReplace, true
+	case 'S', 's':
+		return storeModeSet, true
+	default:
+		return 0, false
+	}
+}
+
+// processMetaDelete handles md. The flag I invalidates the key instead of deleting it, see espresso.Cache.Invalidate:
+// the next mg with the flag N wins a lease together with the stale value.
+// The flag C deletes the item only if its CAS unique matches, it can NOT be combined with I
+func (c *memcacheConn) processMetaDelete(args [][]byte) {
+	if len(args) == 0 || !validKey(args[0]) {
+		c.reply(replyBadFormat)
+		return
+	}
+	key := args[0]
+
+	flags, ok := parseMetaFlags(args[1:], "CIkOq")
+	if !ok {
+		c.reply(replyInvalidFlag)
+		return
+	}
+
+	quiet := false
+	invalidate := false
+	hasCAS := false
+	var cas uint64
+	for _, f := range flags {
+		var err error
+		switch f.name {
+		case 'q':
+			quiet = true
+		case 'I':
+			invalidate = true
+		case 'C':
+			hasCAS = true
+			cas, err = strconv.ParseUint(string(f.token), 10, 64)
+		}
+		if err != nil {
+			c.reply(replyBadFormat)
+			return
+		}
+	}
+	if invalidate && hasCAS {
+		c.reply(replyBadFormat)
+		return
+	}
+
+	result := storeResultNotFound
+	switch {
+	case invalidate:
+		if c.s.store.invalidate(key) {
+			result = storeResultStored
+		}
+	case hasCAS:
+		var err error
+		result, err = c.s.store.deleteCAS(key, cas)
+		if err != nil {
+			c.replyStoreError(err)
+			return
+		}
+	default:
+		if c.s.store.delete(key) {
+			result = storeResultStored
+		}
+	}
+
+	counters := &c.s.counters
+	countHit(result == storeResultStored, &counters.deleteHits, &counters.deleteMisses)
+
+	var buf []byte
+	switch result {
+	case storeResultStored:
+		if quiet {
+			return
+		}
+		buf = append(buf, "HD"...)
+	case storeResultExists:
+		buf = append(buf, "EX"...)
+	default:
+		buf = append(buf, "NF"...)
+	}
+	for _, f := range flags {
+		buf = appendKeyOpaque(buf, f, key)
+	}
+	buf = append(buf, "\r\n"...)
+	c.reply(buf)
+}
@@ -0,0 +1,309 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readLine reads a line without the trailing \r\n
+func (c *rawConn) readLine() string {
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := c.r.ReadString('\n')
+	assert.Equal(c.t, nil, err)
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// leaseToken returns the token of the flag c in a reply line
+func leaseToken(t *testing.T, line string) string {
+	for _, field := range strings.Fields(line) {
+		if strings.HasPrefix(field, "c") {
+			return field[1:]
+		}
+	}
+	t.Fatalf("no CAS token in %q", line)
+	return ""
+}
+
+func TestMeta_Get_Set(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("mg key01 v\r\n")
+	c.expect("EN\r\n")
+
+	c.send("ms key01 7 F5 T10 c\r\nvalue01\r\n")
+	c.expect("HD c1\r\n")
+
+	c.send("mg key01 v c f s t k Oabc\r\n")
+	c.expect("VA 7 c1 f5 s7 t10 kkey01 Oabc\r\nvalue01\r\n")
+
+	c.send("mg key01\r\n")
+	c.expect("HD\r\n")
+
+	ts.clock.Advance(3*time.Second + time.Millisecond)
+	c.send("mg key01 t\r\n")
+	c.expect("HD t7\r\n")
+
+	// the flag T updates the TTL
+	c.send("mg key01 T30 t\r\n")
+	c.expect("HD t30\r\n")
+
+	c.send("ms key02 2\r\nv2\r\n")
+	c.expect("HD\r\n")
+	c.send("mg key02 t\r\n")
+	c.expect("HD t-1\r\n")
+}
+
+func TestMeta_Quiet_And_No_Op(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("mg key01 v q\r\n" +
+		"ms key01 2 q\r\nv1\r\n" +
+		"mg key01 v q\r\n" +
+		"md key02 q\r\n" +
+		"md key01 q\r\n" +
+		"mn\r\n",
+	)
+	c.expect("VA 2\r\nv1\r\n")
+	c.expect("NF\r\n")
+	c.expect("MN\r\n")
+}
+
+func TestMeta_Set_Modes(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("ms key01 2 MR\r\nv1\r\n")
+	c.expect("NS\r\n")
+
+	c.send("ms key01 2 ME\r\nv1\r\n")
+	c.expect("HD\r\n")
+
+	c.send("ms key01 2 ME\r\nv2\r\n")
+	c.expect("NS\r\n")
+
+	c.send("ms key01 2 MS\r\nv3\r\n")
+	c.expect("HD\r\n")
+
+	c.send("ms key01 2 MA\r\nv4\r\n")
+	c.expect("CLIENT_ERROR invalid mode for ms\r\n")
+
+	c.send("ms key01 2 Z\r\nv5\r\n")
+	c.expect("CLIENT_ERROR invalid flag\r\n")
+
+	c.send("mg key01 v\r\n")
+	c.expect("VA 2\r\nv3\r\n")
+
+	c.send("mg key01 x\r\n")
+	c.expect("CLIENT_ERROR invalid flag\r\n")
+
+	c.send("ms key01 abc\r\n")
+	c.expect("CLIENT_ERROR bad command line format\r\n")
+}
+
+func TestMeta_Set_CAS(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("ms key01 2 c\r\nv1\r\n")
+	c.expect("HD c1\r\n")
+
+	c.send("ms key01 2 C2\r\nv2\r\n")
+	c.expect("EX\r\n")
+
+	c.send("ms key01 2 C1 c\r\nv2\r\n")
+	c.expect("HD c2\r\n")
+
+	c.send("ms key02 2 C1\r\nv2\r\n")
+	c.expect("NF\r\n")
+}
+
+func TestMeta_Delete(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("md key01 kkey01 O123\r\n")
+	c.expect("NF kkey01 O123\r\n")
+
+	c.send("ms key01 2\r\nv1\r\n")
+	c.expect("HD\r\n")
+
+	c.send("md key01 C5\r\n")
+	c.expect("EX\r\n")
+
+	c.send("md key01 C1 O9\r\n")
+	c.expect("HD O9\r\n")
+
+	c.send("mg key01 v\r\n")
+	c.expect("EN\r\n")
+
+	c.send("ms key01 2\r\nv1\r\n")
+	c.expect("HD\r\n")
+
+	c.send("md key01\r\n")
+	c.expect("HD\r\n")
+
+	c.send("md key01\r\n")
+	c.expect("NF\r\n")
+
+	c.send("md key01 I C1\r\n")
+	c.expect("CLIENT_ERROR bad command line format\r\n")
+}
+
+func TestMeta_Lease_Miss(t *testing.T) {
+	ts := newTestServer(t)
+	c1 := ts.dial(t)
+	c2 := ts.dial(t)
+
+	// the first caller wins the lease
+	c1.send("mg key01 N30 v c\r\n")
+	line := c1.readLine()
+	assert.True(t, strings.HasPrefix(line, "EN c"))
+	assert.True(t, strings.HasSuffix(line, " W"))
+	token := leaseToken(t, line)
+
+	// the others are rejected
+	c2.send("mg key01 N30 v c\r\n")
+	c2.expect("EN Z\r\n")
+
+	// a plain get is a miss
+	c2.send("mg key01 v\r\n")
+	c2.expect("EN\r\n")
+
+	c1.send("ms key01 7 T10 F3 C" + token + "\r\nvalue01\r\n")
+	c1.expect("HD\r\n")
+
+	c2.send("mg key01 N30 v f\r\n")
+	c2.expect("VA 7 f3\r\nvalue01\r\n")
+
+	// the lease is used
+	c1.send("ms key01 7 C" + token + "\r\nvalue02\r\n")
+	c1.expect("EX\r\n")
+}
+
+func TestMeta_Lease_Stale(t *testing.T) {
+	ts := newTestServer(t)
+	c1 := ts.dial(t)
+	c2 := ts.dial(t)
+
+	c1.send("ms key01 7 F3\r\nvalue01\r\n")
+	c1.expect("HD\r\n")
+
+	c1.send("md key01 I\r\n")
+	c1.expect("HD\r\n")
+
+	// the winner gets the stale value
+	c1.send("mg key01 N30 v c f\r\n")
+	line := c1.readLine()
+	assert.True(t, strings.HasPrefix(line, "VA 7 c"))
+	assert.True(t, strings.HasSuffix(line, " f3 W X"))
+	c1.expect("value01\r\n")
+	token := leaseToken(t, line)
+
+	c2.send("mg key01 N30 v\r\n")
+	c2.expect("VA 7 X Z\r\nvalue01\r\n")
+
+	c1.send("ms key01 7 C" + token + "\r\nvalue02\r\n")
+	c1.expect("HD\r\n")
+
+	c2.send("mg key01 N30 v\r\n")
+	c2.expect("VA 7\r\nvalue02\r\n")
+
+	c2.send("md key02 I\r\n")
+	c2.expect("NF\r\n")
+}
+
+func TestMeta_Lease_Revoked_By_Set(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("mg key01 N30 c\r\n")
+	token := leaseToken(t, c.readLine())
+
+	c.send("ms key01 2\r\nv1\r\n")
+	c.expect("HD\r\n")
+
+	c.send("ms key01 2 C" + token + "\r\nv2\r\n")
+	c.expect("EX\r\n")
+
+	c.send("mg key01 v\r\n")
+	c.expect("VA 2\r\nv1\r\n")
+}
+
+func TestMeta_Lease_Deleted(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("mg key01 N30 c\r\n")
+	token := leaseToken(t, c.readLine())
+
+	// the lease is deleted with the key
+	c.send("md key01\r\n")
+	c.expect("NF\r\n")
+
+	c.send("ms key01 2 C" + token + "\r\nv2\r\n")
+	c.expect("NF\r\n")
+
+	c.send("mg key01 v\r\n")
+	c.expect("EN\r\n")
+}
+
+func TestMeta_Lease_Flushed(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("ms key01 2\r\nv1\r\n")
+	c.expect("HD\r\n")
+
+	c.send("flush_all\r\n")
+	c.expect("OK\r\n")
+
+	// the flushed item is neither a hit nor a stale value
+	c.send("mg key01 N30 v\r\n")
+	c.expect("EN W\r\n")
+}
+
+func TestMeta_Invalidate_Flushed(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("ms key01 2\r\nv1\r\n")
+	c.expect("HD\r\n")
+
+	c.send("flush_all\r\n")
+	c.expect("OK\r\n")
+
+	c.send("md key01 I\r\n")
+	c.expect("NF\r\n")
+
+	// the lease of the key is invalidated
+	c.send("mg key01 N30 c\r\n")
+	token := leaseToken(t, c.readLine())
+
+	c.send("md key01 I\r\n")
+	c.expect("HD\r\n")
+
+	c.send("ms key01 2 C" + token + "\r\nv2\r\n")
+	c.expect("EX\r\n")
+}
+
+func TestMeta_Stats(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dial(t)
+
+	c.send("mg key01 N30\r\n")
+	c.expect("EN W\r\n")
+	c.send("mg key01 N30\r\n")
+	c.expect("EN Z\r\n")
+
+	stats := map[string]string{}
+	for _, s := range ts.server.stats() {
+		stats[s.name] = s.value
+	}
+	assert.Equal(t, "1", stats["lease_granted"])
+	assert.Equal(t, "1", stats["lease_rejected"])
+	assert.Equal(t, "2", stats["get_misses"])
+}
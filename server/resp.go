@@ -0,0 +1,785 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/QuangTung97/espresso"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// respBufferSize is the size of the read and write buffers, also the max length of an inline command
+	respBufferSize = 16 << 10
+	// maxRESPArgs is the max number of arguments of a command, the same as Redis
+	maxRESPArgs = 1024 * 1024
+	// maxRESPKeySize is the max length of a key,
+	// deliberately lower than the 64KB accepted by the partitions to bound the memory taken by keys
+	maxRESPKeySize = 4 << 10
+	// respCommandOverhead is added to the max item size to bound the total size of the arguments of a command,
+	// it leaves room for the command name, the key and the options of an item
+	respCommandOverhead = 64 << 10
+)
+
+// respProtocolError closes the connection after being replied
+type respProtocolError string
+
+func (e respProtocolError) Error() string {
+	return "Protocol error: " + string(e)
+}
+
+var (
+	errRESPSyntax     = errors.New("ERR syntax error")
+	errRESPNotInteger = errors.New("ERR value is not an integer or out of range")
+	errRESPKeyTooLong = errors.New("ERR key is too long")
+)
+
+type respConn struct {
+	s  *Server
+	id uint64
+	r  *bufio.Reader
+	w  *bufio.Writer
+
+	// proto is the protocol version selected by HELLO, 2 or 3
+	proto int
+
+	args [][]byte
+	// buf is the buffer of the arguments of the current command
+	buf []byte
+	// value is the buffer of the items copied out of the cache
+	value []byte
+}
+
+func (s *Server) serveRESP(conn net.Conn) {
+	c := &respConn{
+		s:     s,
+		id:    atomic.AddUint64(&s.respConnSeq, 1),
+		r:     bufio.NewReaderSize(conn, respBufferSize),
+		w:     bufio.NewWriterSize(conn, respBufferSize),
+		proto: 2,
+	}
+
+	for {
+		args, err := c.readCommand()
+		var protoErr respProtocolError
+		if errors.As(err, &protoErr) {
+			c.writeError("ERR " + protoErr.Error())
+			_ = c.w.Flush()
+			return
+		}
+		if err != nil {
+			return
+		}
+
+		if len(args) > 0 && !c.handle(args) {
+			_ = c.w.Flush()
+			return
+		}
+
+		// replies of pipelined commands are flushed together
+		if c.r.Buffered() == 0 {
+			if err := c.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// ServeRESP accepts connections speaking the Redis protocol RESP2 or RESP3 on the listener,
+// always returns a non-nil error, ErrServerClosed after Close is called
+func (s *Server) ServeRESP(l net.Listener) error {
+	return s.serve(l, s.serveRESP)
+}
+
+// ListenAndServeRESP listens on the TCP address and calls ServeRESP
+func (s *Server) ListenAndServeRESP(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.ServeRESP(l)
+}
+
+// readLine returns the line without the trailing \r\n, the line is valid until the next read
+func (c *respConn) readLine(tooLong string) ([]byte, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, respProtocolError(tooLong)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+func (c *respConn) readLength(prefix byte, invalid string) (int64, error) {
+	line, err := c.readLine(invalid)
+	if err != nil {
+		return 0, err
+	}
+	if len(line) == 0 || line[0] != prefix {
+		got := "\\r\\n"
+		if len(line) > 0 {
+			got = string(line[:1])
+		}
+		return 0, respProtocolError(fmt.Sprintf("expected '%c', got '%s'", prefix, got))
+	}
+
+	n, err := strconv.ParseInt(string(line[1:]), 10, 64)
+	if err != nil {
+		return 0, respProtocolError(invalid)
+	}
+	return n, nil
+}
+
+// readCommand reads a command, either an array of bulk strings or an inline command.
+// The total size of the bulk strings is bounded by the max item size plus respCommandOverhead.
+// The arguments are valid until the next call
+func (c *respConn) readCommand() ([][]byte, error) {
+	c.args = c.args[:0]
+	c.buf = c.buf[:0]
+
+	first, err := c.r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	if first[0] != '*' {
+		line, err := c.readLine("too big inline request")
+		if err != nil {
+			return nil, err
+		}
+		return append(c.args, bytes.Fields(line)...), nil
+	}
+
+	n, err := c.readLength('*', "invalid multibulk length")
+	if err != nil {
+		return nil, err
+	}
+	if n > maxRESPArgs {
+		return nil, respProtocolError("invalid multibulk length")
+	}
+
+	maxTotal := int64(c.s.maxItemSize) + respCommandOverhead
+	total := int64(0)
+	for i := int64(0); i < n; i++ {
+		size, err := c.readLength('$', "invalid bulk length")
+		if err != nil {
+			return nil, err
+		}
+		if size < 0 || size > int64(c.s.maxItemSize) {
+			return nil, respProtocolError("invalid bulk length")
+		}
+		total += size
+		if total > maxTotal {
+			return nil, respProtocolError("too big multibulk request")
+		}
+
+		// the arguments read before keep pointing to the old buffer if it is grown
+		start := len(c.buf)
+		end := start + int(size)
+		if cap(c.buf) < end+2 {
+			newBuf := make([]byte, start, 2*cap(c.buf)+int(size)+2)
+			copy(newBuf, c.buf)
+			c.buf = newBuf
+		}
+		c.buf = c.buf[:end+2]
+
+		if _, err := io.ReadFull(c.r, c.buf[start:]); err != nil {
+			return nil, err
+		}
+		if c.buf[end] != '\r' || c.buf[end+1] != '\n' {
+			return nil, respProtocolError("expected CRLF after bulk string")
+		}
+		c.buf = c.buf[:end]
+		c.args = append(c.args, c.buf[start:end:end])
+	}
+	return c.args, nil
+}
+
+func (c *respConn) writeLine(prefix byte, s string) {
+	_ = c.w.WriteByte(prefix)
+	_, _ = c.w.WriteString(s)
+	_, _ = c.w.WriteString("\r\n")
+}
+
+func (c *respConn) writeSimple(s string) {
+	c.writeLine('+', s)
+}
+
+func (c *respConn) writeError(msg string) {
+	c.writeLine('-', msg)
+}
+
+func (c *respConn) writeInt(n int64) {
+	c.writeLine(':', strconv.FormatInt(n, 10))
+}
+
+func (c *respConn) writeBulk(data []byte) {
+	c.writeLine('$', strconv.Itoa(len(data)))
+	_, _ = c.w.Write(data)
+	_, _ = c.w.WriteString("\r\n")
+}
+
+// writeNull writes the null bulk string of RESP2, or the null of RESP3
+func (c *respConn) writeNull() {
+	if c.proto == 3 {
+		_, _ = c.w.WriteString("_\r\n")
+		return
+	}
+	_, _ = c.w.WriteString("$-1\r\n")
+}
+
+func (c *respConn) writeArrayHeader(n int) {
+	c.writeLine('*', strconv.Itoa(n))
+}
+
+// writeMapHeader writes the map header of RESP3, or the header of an array of the keys and values for RESP2
+func (c *respConn) writeMapHeader(n int) {
+	if c.proto == 3 {
+		c.writeLine('%', strconv.Itoa(n))
+		return
+	}
+	c.writeArrayHeader(2 * n)
+}
+
+// writeText writes a verbatim string of RESP3, or a bulk string for RESP2
+func (c *respConn) writeText(text string) {
+	if c.proto == 3 {
+		c.writeLine('=', strconv.Itoa(len(text)+4))
+		_, _ = c.w.WriteString("txt:")
+		_, _ = c.w.WriteString(text)
+		_, _ = c.w.WriteString("\r\n")
+		return
+	}
+	c.writeBulk([]byte(text))
+}
+
+func (c *respConn) writeStoreError(err error) {
+	if err == espresso.ErrValueTooLarge {
+		c.writeError("ERR value is too large")
+		return
+	}
+	c.writeError("OOM not enough space for the value")
+}
+
+func (c *respConn) checkKey(key []byte) bool {
+	if len(key) > maxRESPKeySize {
+		c.writeError(errRESPKeyTooLong.Error())
+		return false
+	}
+	return true
+}
+
+// respCommand is a command of the RESP front end, *args* excludes the command name
+type respCommand struct {
+	// minArgs and maxArgs bound the number of arguments, negative maxArgs means no limit
+	minArgs int
+	maxArgs int
+	handle  func(c *respConn, args [][]byte) bool
+}
+
+var respCommands = map[string]respCommand{
+	"ping":    {minArgs: 0, maxArgs: 1, handle: (*respConn).cmdPing},
+	"echo":    {minArgs: 1, maxArgs: 1, handle: (*respConn).cmdEcho},
+	"quit":    {minArgs: 0, maxArgs: 0, handle: (*respConn).cmdQuit},
+	"hello":   {minArgs: 0, maxArgs: -1, handle: (*respConn).cmdHello},
+	"command": {minArgs: 0, maxArgs: -1, handle: (*respConn).cmdCommand},
+	"get":     {minArgs: 1, maxArgs: 1, handle: (*respConn).cmdGet},
+	"set":     {minArgs: 2, maxArgs: -1, handle: (*respConn).cmdSet},
+	"del":     {minArgs: 1, maxArgs: -1, handle: (*respConn).cmdDel},
+	"mget":    {minArgs: 1, maxArgs: -1, handle: (*respConn).cmdMGet},
+	"mset":    {minArgs: 2, maxArgs: -1, handle: (*respConn).cmdMSet},
+	"expire":  {minArgs: 2, maxArgs: 2, handle: (*respConn).cmdExpire},
+	"ttl":     {minArgs: 1, maxArgs: 1, handle: (*respConn).cmdTTL},
+	"pttl":    {minArgs: 1, maxArgs: 1, handle: (*respConn).cmdPTTL},
+	"info":    {minArgs: 0, maxArgs: -1, handle: (*respConn).cmdInfo},
+}
+
+// handle returns false if the connection must be closed
+func (c *respConn) handle(args [][]byte) bool {
+	atomic.AddUint64(&c.s.counters.totalCommands, 1)
+
+	name := strings.ToLower(string(args[0]))
+	args = args[1:]
+
+	cmd, ok := respCommands[name]
+	if !ok {
+		var buf strings.Builder
+		for _, arg := range args {
+			fmt.Fprintf(&buf, "'%s' ", arg)
+		}
+		c.writeError(fmt.Sprintf("ERR unknown command '%s', with args beginning with: %s", name, buf.String()))
+		return true
+	}
+	if len(args) < cmd.minArgs || (cmd.maxArgs >= 0 && len(args) > cmd.maxArgs) {
+		c.writeError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return true
+	}
+	return cmd.handle(c, args)
+}
+
+func (c *respConn) cmdPing(args [][]byte) bool {
+	if len(args) == 0 {
+		c.writeSimple("PONG")
+	} else {
+		c.writeBulk(args[0])
+	}
+	return true
+}
+
+func (c *respConn) cmdEcho(args [][]byte) bool {
+	c.writeBulk(args[0])
+	return true
+}
+
+func (c *respConn) cmdQuit([][]byte) bool {
+	c.writeSimple("OK")
+	return false
+}
+
+// cmdHello switches the protocol version, the options AUTH and SETNAME are parsed but NOT supported
+func (c *respConn) cmdHello(args [][]byte) bool {
+	proto := c.proto
+	if len(args) > 0 {
+		version, err := strconv.Atoi(string(args[0]))
+		if err != nil {
+			c.writeError("ERR Protocol version is not an integer or out of range")
+			return true
+		}
+		if version != 2 && version != 3 {
+			c.writeError("NOPROTO unsupported protocol version")
+			return true
+		}
+		proto = version
+	}
+
+	for i := 1; i < len(args); i++ {
+		switch strings.ToLower(string(args[i])) {
+		case "auth":
+			if i+2 >= len(args) {
+				c.writeError(errRESPSyntax.Error())
+				return true
+			}
+			c.writeError("ERR AUTH is not supported")
+			return true
+		case "setname":
+			if i+1 >= len(args) {
+				c.writeError(errRESPSyntax.Error())
+				return true
+			}
+			i++
+		default:
+			c.writeError(errRESPSyntax.Error())
+			return true
+		}
+	}
+	c.proto = proto
+
+	c.writeMapHeader(7)
+	c.writeBulk([]byte("server"))
+	c.writeBulk([]byte("espresso"))
+	c.writeBulk([]byte("version"))
+	c.writeBulk([]byte(Version))
+	c.writeBulk([]byte("proto"))
+	c.writeInt(int64(c.proto))
+	c.writeBulk([]byte("id"))
+	c.writeInt(int64(c.id))
+	c.writeBulk([]byte("mode"))
+	c.writeBulk([]byte("standalone"))
+	c.writeBulk([]byte("role"))
+	c.writeBulk([]byte("master"))
+	c.writeBulk([]byte("modules"))
+	c.writeArrayHeader(0)
+	return true
+}
+
+// cmdCommand replies an empty list, some clients call COMMAND when connecting
+func (c *respConn) cmdCommand([][]byte) bool {
+	c.writeArrayHeader(0)
+	return true
+}
+
+// getValue writes the value of the key or null
+func (c *respConn) getValue(key []byte) {
+	counters := &c.s.counters
+	atomic.AddUint64(&counters.cmdGet, 1)
+
+	it, value, ok := c.s.store.get(key, c.value)
+	c.value = value
+	countHit(ok, &counters.getHits, &counters.getMisses)
+
+	if !ok {
+		c.writeNull()
+		return
+	}
+	c.writeBulk(it.data)
+}
+
+func (c *respConn) cmdGet(args [][]byte) bool {
+	if c.checkKey(args[0]) {
+		c.getValue(args[0])
+	}
+	return true
+}
+
+func (c *respConn) cmdMGet(args [][]byte) bool {
+	for _, key := range args {
+		if !c.checkKey(key) {
+			return true
+		}
+	}
+
+	c.writeArrayHeader(len(args))
+	for _, key := range args {
+		c.getValue(key)
+	}
+	return true
+}
+
+// relativeExpire returns the expire time after *n* units, returns false if the time is NOT positive or overflows
+func relativeExpire(now time.Time, n int64, unit time.Duration) (int64, bool) {
+	if n <= 0 || n > (math.MaxInt64-now.UnixNano())/int64(unit) {
+		return 0, false
+	}
+	return now.UnixNano() + n*int64(unit), true
+}
+
+// parseSetOptions parses the options EX, PX, NX and XX of SET
+func (c *respConn) parseSetOptions(options [][]byte) (storeMode, int64, error) {
+	mode := storeModeSet
+	expire := int64(0)
+	for i := 0; i < len(options); i++ {
+		switch strings.ToLower(string(options[i])) {
+		case "nx":
+			if mode != storeModeSet {
+				return 0, 0, errRESPSyntax
+			}
+			mode = storeModeAdd
+		case "xx":
+			if mode != storeModeSet {
+				return 0, 0, errRESPSyntax
+			}
+			mode = storeModeReplace
+		case "ex", "px":
+			if expire != 0 || i+1 >= len(options) {
+				return 0, 0, errRESPSyntax
+			}
+			unit := time.Second
+			if options[i][0] == 'p' || options[i][0] == 'P' {
+				unit = time.Millisecond
+			}
+
+			n, err := strconv.ParseInt(string(options[i+1]), 10, 64)
+			if err != nil {
+				return 0, 0, errRESPNotInteger
+			}
+			var ok bool
+			expire, ok = relativeExpire(c.s.store.now(), n, unit)
+			if !ok {
+				return 0, 0, errors.New("ERR invalid expire time in 'set' command")
+			}
+			i++
+		default:
+			return 0, 0, errRESPSyntax
+		}
+	}
+	return mode, expire, nil
+}
+
+func (c *respConn) cmdSet(args [][]byte) bool {
+	key := args[0]
+	if !c.checkKey(key) {
+		return true
+	}
+
+	mode, expire, err := c.parseSetOptions(args[2:])
+	if err != nil {
+		c.writeError(err.Error())
+		return true
+	}
+
+	atomic.AddUint64(&c.s.counters.cmdSet, 1)
+	result, _, err := c.s.store.store(mode, key, 0, expire, args[1], 0)
+	if err != nil {
+		c.writeStoreError(err)
+		return true
+	}
+
+	if result != storeResultStored {
+		// the condition of NX or XX is NOT met
+		c.writeNull()
+		return true
+	}
+	c.writeSimple("OK")
+	return true
+}
+
+// cmdMSet is NOT atomic: the keys are set one by one, and the keys set before an error
+// (e.g. a value too large) are kept when the error is replied
+func (c *respConn) cmdMSet(args [][]byte) bool {
+	if len(args)%2 != 0 {
+		c.writeError("ERR wrong number of arguments for 'mset' command")
+		return true
+	}
+	for i := 0; i < len(args); i += 2 {
+		if !c.checkKey(args[i]) {
+			return true
+		}
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		atomic.AddUint64(&c.s.counters.cmdSet, 1)
+		if _, _, err := c.s.store.store(storeModeSet, args[i], 0, 0, args[i+1], 0); err != nil {
+			c.writeStoreError(err)
+			return true
+		}
+	}
+	c.writeSimple("OK")
+	return true
+}
+
+func (c *respConn) cmdDel(args [][]byte) bool {
+	counters := &c.s.counters
+
+	for _, key := range args {
+		if !c.checkKey(key) {
+			return true
+		}
+	}
+
+	deleted := int64(0)
+	for _, key := range args {
+		ok := c.s.store.delete(key)
+		countHit(ok, &counters.deleteHits, &counters.deleteMisses)
+		if ok {
+			deleted++
+		}
+	}
+	c.writeInt(deleted)
+	return true
+}
+
+// cmdExpire deletes the key if the TTL is NOT positive, the same as Redis
+func (c *respConn) cmdExpire(args [][]byte) bool {
+	key := args[0]
+	if !c.checkKey(key) {
+		return true
+	}
+	seconds, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		c.writeError(errRESPNotInteger.Error())
+		return true
+	}
+
+	atomic.AddUint64(&c.s.counters.cmdTouch, 1)
+
+	var ok bool
+	if expire, valid := relativeExpire(c.s.store.now(), seconds, time.Second); valid {
+		ok, err = c.s.store.touch(key, expire)
+	} else if seconds <= 0 {
+		ok = c.s.store.delete(key)
+	} else {
+		c.writeError("ERR invalid expire time in 'expire' command")
+		return true
+	}
+	if err != nil {
+		c.writeStoreError(err)
+		return true
+	}
+
+	countHit(ok, &c.s.counters.touchHits, &c.s.counters.touchMisses)
+	if ok {
+		c.writeInt(1)
+	} else {
+		c.writeInt(0)
+	}
+	return true
+}
+
+// remainingMillis returns -2 if the key has no live item and -1 if the item never expires
+func (c *respConn) remainingMillis(key []byte) int64 {
+	expire, ok := c.s.store.expireOf(key)
+	if !ok {
+		return -2
+	}
+	if expire == 0 {
+		return -1
+	}
+	remaining := (expire - c.s.store.clock.Now().UnixNano()) / int64(time.Millisecond)
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+func (c *respConn) cmdTTL(args [][]byte) bool {
+	if !c.checkKey(args[0]) {
+		return true
+	}
+	remaining := c.remainingMillis(args[0])
+	if remaining < 0 {
+		c.writeInt(remaining)
+		return true
+	}
+	// rounded the same as Redis
+	c.writeInt((remaining + 500) / 1000)
+	return true
+}
+
+func (c *respConn) cmdPTTL(args [][]byte) bool {
+	if !c.checkKey(args[0]) {
+		return true
+	}
+	c.writeInt(c.remainingMillis(args[0]))
+	return true
+}
+
+// infoSection is a section of the reply of INFO
+type infoSection struct {
+	name   string
+	fields []stat
+}
+
+// infoSections are the sections of INFO, populated from the statistics of the partitions and the allocators
+func (s *Server) infoSections() []infoSection {
+	now := s.store.clock.Now()
+	cacheStats := s.cache.Stats()
+	counters := &s.counters
+
+	formatUint := func(v uint64) string {
+		return strconv.FormatUint(v, 10)
+	}
+	load := func(v *uint64) string {
+		return formatUint(atomic.LoadUint64(v))
+	}
+
+	lruTotals := cacheStats.LRUTotals()
+	var limit, largeItems, largeBytes uint64
+	fragmentation := 0.0
+	for _, p := range cacheStats.Partitions {
+		limit += p.Allocator.TotalBytes
+		largeItems += p.Allocator.LargeItems
+		largeBytes += p.Allocator.LargeBytes
+		fragmentation += p.Allocator.Fragmentation
+	}
+	if len(cacheStats.Partitions) > 0 {
+		fragmentation /= float64(len(cacheStats.Partitions))
+	}
+	evictions := cacheStats.AdmissionEvictions + cacheStats.ProbationEvictions + cacheStats.ProtectedEvictions
+	uptime := int64(now.Sub(s.startTime) / time.Second)
+
+	sections := []infoSection{
+		{
+			name: "server",
+			fields: []stat{
+				{name: "espresso_version", value: Version},
+				{name: "redis_mode", value: "standalone"},
+				{name: "process_id", value: strconv.Itoa(os.Getpid())},
+				{name: "uptime_in_seconds", value: strconv.FormatInt(uptime, 10)},
+				{name: "uptime_in_days", value: strconv.FormatInt(uptime/(24*60*60), 10)},
+			},
+		},
+		{
+			name: "clients",
+			fields: []stat{
+				{
+					name:  "connected_clients",
+					value: strconv.FormatInt(atomic.LoadInt64(&counters.currConnections), 10),
+				},
+			},
+		},
+		{
+			name: "memory",
+			fields: []stat{
+				{name: "used_memory", value: formatUint(cacheStats.MemUsage)},
+				{name: "maxmemory", value: formatUint(limit)},
+				{name: "maxmemory_policy", value: "w-tinylfu"},
+				{name: "large_items", value: formatUint(largeItems)},
+				{name: "large_item_bytes", value: formatUint(largeBytes)},
+				{name: "allocator_fragmentation", value: strconv.FormatFloat(fragmentation, 'f', 4, 64)},
+			},
+		},
+		{
+			name: "stats",
+			fields: []stat{
+				{name: "total_connections_received", value: load(&counters.totalConnections)},
+				{name: "total_commands_processed", value: load(&counters.totalCommands)},
+				{name: "keyspace_hits", value: load(&counters.getHits)},
+				{name: "keyspace_misses", value: load(&counters.getMisses)},
+				{name: "evicted_keys", value: formatUint(evictions)},
+				{name: "expired_keys", value: formatUint(cacheStats.Expirations)},
+				{name: "lease_granted", value: formatUint(cacheStats.LeaseGranted)},
+				{name: "lease_rejected", value: formatUint(cacheStats.LeaseRejected)},
+				{name: "admission_rejections", value: formatUint(cacheStats.AdmissionRejections)},
+				{name: "slab_moves", value: formatUint(cacheStats.SlabMoves)},
+			},
+		},
+		{
+			name: "lru",
+			fields: []stat{
+				{name: "admission_entries", value: formatUint(lruTotals.Admission.Size)},
+				{name: "admission_weight", value: formatUint(lruTotals.Admission.Weight)},
+				{name: "admission_limit", value: formatUint(lruTotals.Admission.Limit)},
+				{name: "probation_entries", value: formatUint(lruTotals.Probation.Size)},
+				{name: "probation_weight", value: formatUint(lruTotals.Probation.Weight)},
+				{name: "protected_entries", value: formatUint(lruTotals.Protected.Size)},
+				{name: "protected_weight", value: formatUint(lruTotals.Protected.Weight)},
+				{name: "protected_limit", value: formatUint(lruTotals.Protected.Limit)},
+			},
+		},
+		{name: "slabs", fields: slabInfo(cacheStats.SlabTotals())},
+		{
+			name: "keyspace",
+			fields: []stat{
+				{name: "db0", value: "keys=" + formatUint(cacheStats.NumEntries)},
+			},
+		},
+	}
+	return sections
+}
+
+// slabInfo formats the slab classes summed over the partitions
+func slabInfo(slabs []espresso.SlabSum) []stat {
+	fields := make([]stat, 0, len(slabs))
+	for _, s := range slabs {
+		fields = append(fields, stat{
+			name: fmt.Sprintf("slab_%d", s.ElemSize),
+			value: fmt.Sprintf("items=%d,chunks=%d,item_bytes=%d,wasted_bytes=%d",
+				s.NumItems, s.NumChunks, s.ItemBytes, s.WastedBytes),
+		})
+	}
+	return fields
+}
+
+// cmdInfo accepts the section names, and all, default or everything for all sections
+func (c *respConn) cmdInfo(args [][]byte) bool {
+	selected := map[string]bool{}
+	for _, arg := range args {
+		selected[strings.ToLower(string(arg))] = true
+	}
+	all := len(args) == 0 || selected["all"] || selected["default"] || selected["everything"]
+
+	var buf strings.Builder
+	for _, section := range c.s.infoSections() {
+		if !all && !selected[section.name] {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString("\r\n")
+		}
+		fmt.Fprintf(&buf, "# %s\r\n", strings.Title(section.name))
+		for _, f := range section.fields {
+			fmt.Fprintf(&buf, "%s:%s\r\n", f.name, f.value)
+		}
+	}
+	c.writeText(buf.String())
+	return true
+}
@@ -0,0 +1,341 @@
+package server
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func (ts *testServer) dialRESP(t *testing.T) *rawConn {
+	conn, err := net.Dial("tcp", ts.respAddr)
+	assert.Equal(t, nil, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	return &rawConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// encodeRESP encodes the arguments as an array of bulk strings
+func encodeRESP(args ...string) string {
+	var buf strings.Builder
+	buf.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		buf.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n" + arg + "\r\n")
+	}
+	return buf.String()
+}
+
+func TestRESP_Set_Get(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dialRESP(t)
+
+	c.send(encodeRESP("GET", "key01"))
+	c.expect("$-1\r\n")
+
+	c.send(encodeRESP("SET", "key01", "value01"))
+	c.expect("+OK\r\n")
+
+	c.send(encodeRESP("get", "key01"))
+	c.expect("$7\r\nvalue01\r\n")
+
+	// the inline commands
+	c.send("SET key02 v2\r\nGET key02\r\nPING\r\n")
+	c.expect("+OK\r\n$2\r\nv2\r\n+PONG\r\n")
+
+	c.send(encodeRESP("PING", "hello"))
+	c.expect("$5\r\nhello\r\n")
+
+	c.send(encodeRESP("ECHO", "abc"))
+	c.expect("$3\r\nabc\r\n")
+
+	// the empty value
+	c.send(encodeRESP("SET", "key03", ""))
+	c.expect("+OK\r\n")
+	c.send(encodeRESP("GET", "key03"))
+	c.expect("$0\r\n\r\n")
+}
+
+func TestRESP_Set_Options(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dialRESP(t)
+
+	c.send(encodeRESP("SET", "key01", "v1", "XX"))
+	c.expect("$-1\r\n")
+
+	c.send(encodeRESP("SET", "key01", "v1", "NX"))
+	c.expect("+OK\r\n")
+
+	c.send(encodeRESP("SET", "key01", "v2", "NX"))
+	c.expect("$-1\r\n")
+
+	c.send(encodeRESP("SET", "key01", "v3", "xx", "ex", "10"))
+	c.expect("+OK\r\n")
+
+	c.send(encodeRESP("GET", "key01"))
+	c.expect("$2\r\nv3\r\n")
+
+	ts.clock.Advance(10 * time.Second)
+	c.send(encodeRESP("GET", "key01"))
+	c.expect("$-1\r\n")
+
+	c.send(encodeRESP("SET", "key02", "v1", "PX", "1500"))
+	c.expect("+OK\r\n")
+	ts.clock.Advance(1499 * time.Millisecond)
+	c.send(encodeRESP("GET", "key02"))
+	c.expect("$2\r\nv1\r\n")
+	ts.clock.Advance(time.Millisecond)
+	c.send(encodeRESP("GET", "key02"))
+	c.expect("$-1\r\n")
+
+	table := []struct {
+		name     string
+		options  []string
+		expected string
+	}{
+		{name: "nx-and-xx", options: []string{"NX", "XX"}, expected: "-ERR syntax error\r\n"},
+		{name: "ex-and-px", options: []string{"EX", "1", "PX", "1"}, expected: "-ERR syntax error\r\n"},
+		{name: "missing-ex", options: []string{"EX"}, expected: "-ERR syntax error\r\n"},
+		{name: "unknown", options: []string{"GET"}, expected: "-ERR syntax error\r\n"},
+		{
+			name:     "not-integer",
+			options:  []string{"EX", "abc"},
+			expected: "-ERR value is not an integer or out of range\r\n",
+		},
+		{
+			name:     "zero",
+			options:  []string{"EX", "0"},
+			expected: "-ERR invalid expire time in 'set' command\r\n",
+		},
+		{
+			name:     "overflow",
+			options:  []string{"EX", "9223372036854775807"},
+			expected: "-ERR invalid expire time in 'set' command\r\n",
+		},
+	}
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			c.send(encodeRESP(append([]string{"SET", "key03", "v"}, e.options...)...))
+			c.expect(e.expected)
+		})
+	}
+
+	c.send(encodeRESP("GET", "key03"))
+	c.expect("$-1\r\n")
+}
+
+func TestRESP_Multi_Keys(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dialRESP(t)
+
+	c.send(encodeRESP("MSET", "key01", "v1", "key02", "v2", "key03", "v3"))
+	c.expect("+OK\r\n")
+
+	c.send(encodeRESP("MGET", "key01", "key04", "key03"))
+	c.expect("*3\r\n$2\r\nv1\r\n$-1\r\n$2\r\nv3\r\n")
+
+	c.send(encodeRESP("DEL", "key01", "key04", "key03"))
+	c.expect(":2\r\n")
+
+	c.send(encodeRESP("MGET", "key01", "key02", "key03"))
+	c.expect("*3\r\n$-1\r\n$2\r\nv2\r\n$-1\r\n")
+
+	c.send(encodeRESP("MSET", "key01", "v1", "key02"))
+	c.expect("-ERR wrong number of arguments for 'mset' command\r\n")
+}
+
+func TestRESP_Expire_TTL(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dialRESP(t)
+
+	c.send(encodeRESP("TTL", "key01"))
+	c.expect(":-2\r\n")
+
+	c.send(encodeRESP("EXPIRE", "key01", "10"))
+	c.expect(":0\r\n")
+
+	c.send(encodeRESP("SET", "key01", "v1"))
+	c.expect("+OK\r\n")
+
+	c.send(encodeRESP("TTL", "key01"))
+	c.expect(":-1\r\n")
+
+	c.send(encodeRESP("EXPIRE", "key01", "10"))
+	c.expect(":1\r\n")
+
+	ts.clock.Advance(2400 * time.Millisecond)
+	c.send(encodeRESP("TTL", "key01"))
+	c.expect(":8\r\n")
+	c.send(encodeRESP("PTTL", "key01"))
+	c.expect(":7600\r\n")
+
+	ts.clock.Advance(7600 * time.Millisecond)
+	c.send(encodeRESP("TTL", "key01"))
+	c.expect(":-2\r\n")
+
+	// a TTL that is NOT positive deletes the key
+	c.send(encodeRESP("SET", "key02", "v2"))
+	c.expect("+OK\r\n")
+	c.send(encodeRESP("EXPIRE", "key02", "0"))
+	c.expect(":1\r\n")
+	c.send(encodeRESP("GET", "key02"))
+	c.expect("$-1\r\n")
+
+	c.send(encodeRESP("EXPIRE", "key02", "abc"))
+	c.expect("-ERR value is not an integer or out of range\r\n")
+}
+
+func TestRESP_Errors(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dialRESP(t)
+
+	c.send(encodeRESP("FOO", "a", "b"))
+	c.expect("-ERR unknown command 'foo', with args beginning with: 'a' 'b' \r\n")
+
+	c.send(encodeRESP("GET"))
+	c.expect("-ERR wrong number of arguments for 'get' command\r\n")
+
+	c.send(encodeRESP("GET", strings.Repeat("k", maxRESPKeySize+1)))
+	c.expect("-ERR key is too long\r\n")
+
+	// the connection is still usable
+	c.send(encodeRESP("PING"))
+	c.expect("+PONG\r\n")
+
+	c.send("*1\r\n+PING\r\n")
+	c.expect("-ERR Protocol error: expected '$', got '+'\r\n")
+	_ = c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err := c.r.ReadByte()
+	assert.NotEqual(t, nil, err)
+}
+
+func TestRESP_Too_Large(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dialRESP(t)
+
+	c.send("*3\r\n$3\r\nSET\r\n$5\r\nkey01\r\n$100000\r\n")
+	c.expect("-ERR Protocol error: invalid bulk length\r\n")
+}
+
+func TestRESP_Too_Large_Command(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dialRESP(t)
+
+	value := strings.Repeat("v", 60000)
+
+	// the total size is below the max item size plus the overhead
+	c.send(encodeRESP("MSET", "key01", value, "key02", value))
+	c.expect("+OK\r\n")
+
+	c.send(encodeRESP("GET", "key02"))
+	c.expect("$60000\r\n" + value + "\r\n")
+
+	// every bulk string is valid but not their total size
+	c.send("*7\r\n$4\r\nMSET\r\n" +
+		"$5\r\nkey01\r\n$60000\r\n" + value + "\r\n" +
+		"$5\r\nkey02\r\n$60000\r\n" + value + "\r\n" +
+		"$5\r\nkey03\r\n$60000\r\n")
+	c.expect("-ERR Protocol error: too big multibulk request\r\n")
+}
+
+func TestRESP_Hello_RESP3(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dialRESP(t)
+
+	c.send(encodeRESP("HELLO", "4"))
+	c.expect("-NOPROTO unsupported protocol version\r\n")
+
+	c.send(encodeRESP("HELLO", "3"))
+	c.expect("%7\r\n" +
+		"$6\r\nserver\r\n$8\r\nespresso\r\n" +
+		"$7\r\nversion\r\n$" + strconv.Itoa(len(Version)) + "\r\n" + Version + "\r\n" +
+		"$5\r\nproto\r\n:3\r\n" +
+		"$2\r\nid\r\n:1\r\n" +
+		"$4\r\nmode\r\n$10\r\nstandalone\r\n" +
+		"$4\r\nrole\r\n$6\r\nmaster\r\n" +
+		"$7\r\nmodules\r\n*0\r\n",
+	)
+
+	c.send(encodeRESP("GET", "key01"))
+	c.expect("_\r\n")
+
+	c.send(encodeRESP("MGET", "key01"))
+	c.expect("*1\r\n_\r\n")
+
+	c.send(encodeRESP("INFO", "keyspace"))
+	c.expect("=28\r\ntxt:# Keyspace\r\ndb0:keys=0\r\n\r\n")
+
+	c.send(encodeRESP("HELLO", "2", "AUTH", "user", "pass"))
+	c.expect("-ERR AUTH is not supported\r\n")
+
+	c.send(encodeRESP("HELLO", "2", "SETNAME", "conn01"))
+	c.expect("*14\r\n")
+	// the last field is the empty array of the modules
+	for c.readLine() != "*0" {
+	}
+
+	c.send(encodeRESP("INFO", "keyspace"))
+	c.expect("$24\r\n# Keyspace\r\ndb0:keys=0\r\n\r\n")
+}
+
+// readInfo returns the fields and the section names of the reply of INFO in RESP2
+func readInfo(c *rawConn) (map[string]string, []string) {
+	header := c.readLine()
+	assert.True(c.t, strings.HasPrefix(header, "$"))
+	size, err := strconv.Atoi(header[1:])
+	assert.Equal(c.t, nil, err)
+
+	body := make([]byte, size+2)
+	_, err = io.ReadFull(c.r, body)
+	assert.Equal(c.t, nil, err)
+
+	fields := map[string]string{}
+	var sections []string
+	for _, line := range strings.Split(string(body), "\r\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "# ") {
+			sections = append(sections, line[2:])
+			continue
+		}
+		index := strings.IndexByte(line, ':')
+		assert.True(c.t, index > 0)
+		fields[line[:index]] = line[index+1:]
+	}
+	return fields, sections
+}
+
+func TestRESP_Info(t *testing.T) {
+	ts := newTestServer(t)
+	c := ts.dialRESP(t)
+
+	c.send(encodeRESP("SET", "key01", "value01"))
+	c.expect("+OK\r\n")
+	c.send(encodeRESP("GET", "key01"))
+	c.expect("$7\r\nvalue01\r\n")
+	c.send(encodeRESP("GET", "key02"))
+	c.expect("$-1\r\n")
+
+	ts.clock.Advance(5 * time.Second)
+
+	c.send(encodeRESP("INFO"))
+	fields, sections := readInfo(c)
+	assert.Equal(t, []string{"Server", "Clients", "Memory", "Stats", "Lru", "Slabs", "Keyspace"}, sections)
+
+	assert.Equal(t, Version, fields["espresso_version"])
+	assert.Equal(t, "5", fields["uptime_in_seconds"])
+	assert.Equal(t, "1", fields["connected_clients"])
+	assert.Equal(t, "4", fields["total_commands_processed"])
+	assert.Equal(t, "1", fields["keyspace_hits"])
+	assert.Equal(t, "1", fields["keyspace_misses"])
+	assert.Equal(t, "keys=1", fields["db0"])
+	assert.Equal(t, "4194304", fields["maxmemory"])
+	assert.Equal(t, "1", fields["admission_entries"])
+
+	c.send(encodeRESP("INFO", "server", "CLIENTS"))
+	_, sections = readInfo(c)
+	assert.Equal(t, []string{"Server", "Clients"}, sections)
+}
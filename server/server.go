@@ -0,0 +1,224 @@
+package server
+
+import (
+	"errors"
+	"github.com/QuangTung97/espresso"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Version is reported by the version and stats commands
+const Version = "1.6.0-espresso"
+
+// DefaultMaxItemSize is used when Config.MaxItemSize is zero
+const DefaultMaxItemSize = 1 << 20
+
+// ErrServerClosed is returned by Serve after Close is called
+var ErrServerClosed = errors.New("server: closed")
+
+// Config ...
+type Config struct {
+	// MaxItemSize is the max size of the data of an item, default to DefaultMaxItemSize
+	MaxItemSize int
+	// Clock must be the clock of the partitions of the cache, default to espresso.SystemClock
+	Clock espresso.Clock
+}
+
+// counters are the command statistics reported by the stats command
+type counters struct {
+	currConnections  int64
+	totalConnections uint64
+	totalCommands    uint64
+
+	cmdGet   uint64
+	cmdSet   uint64
+	cmdTouch uint64
+	cmdFlush uint64
+
+	getHits      uint64
+	getMisses    uint64
+	deleteHits   uint64
+	deleteMisses uint64
+	incrHits     uint64
+	incrMisses   uint64
+	decrHits     uint64
+	decrMisses   uint64
+	casHits      uint64
+	casMisses    uint64
+	casBadval    uint64
+	touchHits    uint64
+	touchMisses  uint64
+}
+
+func countHit(hit bool, hits *uint64, misses *uint64) {
+	if hit {
+		atomic.AddUint64(hits, 1)
+	} else {
+		atomic.AddUint64(misses, 1)
+	}
+}
+
+// Server serves an espresso.Cache over the memcached ASCII protocol and the Redis protocol
+type Server struct {
+	maxItemSize int
+	cache       *espresso.Cache
+	store       *store
+	startTime   time.Time
+
+	counters counters
+	// respConnSeq is the last ID of the RESP connections, returned by HELLO
+	respConnSeq uint64
+
+	mut       sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// New ...
+func New(cache *espresso.Cache, conf Config) *Server {
+	maxItemSize := conf.MaxItemSize
+	if maxItemSize <= 0 {
+		maxItemSize = DefaultMaxItemSize
+	}
+	clock := conf.Clock
+	if clock == nil {
+		clock = espresso.SystemClock
+	}
+
+	return &Server{
+		maxItemSize: maxItemSize,
+		cache:       cache,
+		store:       newStore(cache, clock),
+		startTime:   clock.Now(),
+
+		listeners: map[net.Listener]struct{}{},
+		conns:     map[net.Conn]struct{}{},
+	}
+}
+
+func (s *Server) trackListener(l net.Listener, add bool) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if add {
+		if s.closed {
+			return false
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) trackConn(conn net.Conn, add bool) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+
+	if add {
+		if s.closed {
+			return false
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+	} else {
+		delete(s.conns, conn)
+		s.wg.Done()
+	}
+	return true
+}
+
+func (s *Server) isClosed() bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.closed
+}
+
+// serve accepts connections on the listener and serves them with *handle*, always returns a non-nil error.
+// The listener is closed when Serve returns
+func (s *Server) serve(l net.Listener, handle func(conn net.Conn)) error {
+	if !s.trackListener(l, true) {
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	defer func() {
+		s.trackListener(l, false)
+		_ = l.Close()
+	}()
+
+	delay := time.Duration(0)
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				// the same backoff as net/http
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else {
+					delay *= 2
+				}
+				if delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+
+		if !s.trackConn(conn, true) {
+			_ = conn.Close()
+			return ErrServerClosed
+		}
+		go func() {
+			defer s.trackConn(conn, false)
+			defer func() { _ = conn.Close() }()
+
+			atomic.AddInt64(&s.counters.currConnections, 1)
+			atomic.AddUint64(&s.counters.totalConnections, 1)
+			defer atomic.AddInt64(&s.counters.currConnections, -1)
+
+			handle(conn)
+		}()
+	}
+}
+
+// Serve accepts connections speaking the memcached ASCII protocol on the listener,
+// always returns a non-nil error, ErrServerClosed after Close is called
+func (s *Server) Serve(l net.Listener) error {
+	return s.serve(l, s.serveMemcache)
+}
+
+// ListenAndServe listens on the TCP address and calls Serve
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Close closes all listeners and connections, and waits for the connection handlers to return
+func (s *Server) Close() error {
+	s.mut.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		_ = l.Close()
+	}
+	for conn := range s.conns {
+		_ = conn.Close()
+	}
+	s.mut.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
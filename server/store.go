@@ -0,0 +1,406 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/QuangTung97/espresso"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// itemHeaderSize is the size of the flags, the CAS unique and the expire time stored in front of the data
+const itemHeaderSize = 4 + 8 + 8
+
+// maxRelativeExpire is the largest exptime interpreted as seconds from now, larger values are unix timestamps
+const maxRelativeExpire = 60 * 60 * 24 * 30
+
+// item is the value of a key as seen by the protocols, stored in the cache with encodeItem
+type item struct {
+	flags uint32
+	cas   uint64
+	// expire is in unix nanoseconds, zero means no expiration
+	expire int64
+	data   []byte
+}
+
+func encodeItem(it item) []byte {
+	value := make([]byte, itemHeaderSize+len(it.data))
+	binary.LittleEndian.PutUint32(value[0:], it.flags)
+	binary.LittleEndian.PutUint64(value[4:], it.cas)
+	binary.LittleEndian.PutUint64(value[12:], uint64(it.expire))
+	copy(value[itemHeaderSize:], it.data)
+	return value
+}
+
+// decodeItem returns false if the value was NOT stored by encodeItem, the data of the item aliases *value*
+func decodeItem(value []byte) (item, bool) {
+	if len(value) < itemHeaderSize {
+		return item{}, false
+	}
+	return item{
+		flags:  binary.LittleEndian.Uint32(value[0:]),
+		cas:    binary.LittleEndian.Uint64(value[4:]),
+		expire: int64(binary.LittleEndian.Uint64(value[12:])),
+		data:   value[itemHeaderSize:],
+	}, true
+}
+
+// memcacheExpire converts the exptime of the memcached protocol to unix nanoseconds.
+// Zero means no expiration, negative values expire immediately,
+// values up to 30 days are relative to now and larger values are unix timestamps
+func memcacheExpire(now time.Time, exptime int64) int64 {
+	switch {
+	case exptime == 0:
+		return 0
+	case exptime < 0:
+		return now.UnixNano()
+	case exptime <= maxRelativeExpire:
+		return now.Add(time.Duration(exptime) * time.Second).UnixNano()
+	default:
+		return exptime * int64(time.Second)
+	}
+}
+
+// cacheTTL is the TTL of an item in the cache, the expired item is kept for the shortest TTL and is never returned
+func cacheTTL(now time.Time, expire int64) time.Duration {
+	if expire == 0 {
+		return 0
+	}
+	ttl := time.Duration(expire - now.UnixNano())
+	if ttl <= 0 {
+		return time.Nanosecond
+	}
+	return ttl
+}
+
+type storeMode int
+
+const (
+	storeModeSet storeMode = iota
+	storeModeAdd
+	storeModeReplace
+	storeModeCAS
+)
+
+type storeResult int
+
+const (
+	storeResultStored storeResult = iota
+	storeResultNotStored
+	storeResultExists
+	storeResultNotFound
+)
+
+var errNonNumeric = errors.New("cannot increment or decrement non-numeric value")
+
+// store implements the commands of the protocols on top of the cache.
+// Every CAS unique is greater than the ones before, flush_all invalidates the items with smaller CAS uniques
+type store struct {
+	cache *espresso.Cache
+	clock espresso.Clock
+
+	casSeq   uint64
+	flushCAS uint64
+
+	flushMut sync.Mutex
+	// flushAt is the unix nanoseconds of a delayed flush_all, zero if there is none
+	flushAt int64
+}
+
+func newStore(cache *espresso.Cache, clock espresso.Clock) *store {
+	return &store{
+		cache: cache,
+		clock: clock,
+	}
+}
+
+func (s *store) nextCAS() uint64 {
+	return atomic.AddUint64(&s.casSeq, 1)
+}
+
+// now applies the delayed flush_all if its time has come
+func (s *store) now() time.Time {
+	now := s.clock.Now()
+	if atomic.LoadInt64(&s.flushAt) == 0 {
+		return now
+	}
+
+	s.flushMut.Lock()
+	if s.flushAt != 0 && now.UnixNano() >= s.flushAt {
+		atomic.StoreUint64(&s.flushCAS, atomic.LoadUint64(&s.casSeq))
+		atomic.StoreInt64(&s.flushAt, 0)
+	}
+	s.flushMut.Unlock()
+	return now
+}
+
+func (s *store) isLive(it item, now time.Time) bool {
+	if it.expire != 0 && now.UnixNano() >= it.expire {
+		return false
+	}
+	return it.cas > atomic.LoadUint64(&s.flushCAS)
+}
+
+// decodeLive returns false if the value is NOT a live item
+func (s *store) decodeLive(value []byte, found bool, now time.Time) (item, bool) {
+	if !found {
+		return item{}, false
+	}
+	it, ok := decodeItem(value)
+	if !ok || !s.isLive(it, now) {
+		return item{}, false
+	}
+	return it, true
+}
+
+// get copies the item into *dst*, the data of the returned item aliases *dst*
+func (s *store) get(key []byte, dst []byte) (item, []byte, bool) {
+	now := s.now()
+	value, found := s.cache.Get(key, dst)
+	it, ok := s.decodeLive(value, found, now)
+	if found {
+		dst = value
+	}
+	return it, dst, ok
+}
+
+// store returns the CAS unique of the stored item, *expire* is in unix nanoseconds and zero means no expiration
+func (s *store) store(mode storeMode, key []byte, flags uint32, expire int64, data []byte, cas uint64) (
+	storeResult, uint64, error,
+) {
+	now := s.now()
+
+	result := storeResultStored
+	newCAS := uint64(0)
+	_, err := s.cache.Update(key, func(value []byte, found bool) ([]byte, time.Duration, bool) {
+		old, ok := s.decodeLive(value, found, now)
+		switch {
+		case mode == storeModeAdd && ok:
+			result = storeResultNotStored
+		case mode == storeModeReplace && !ok:
+			result = storeResultNotStored
+		case mode == storeModeCAS && !ok:
+			result = storeResultNotFound
+		case mode == storeModeCAS && old.cas != cas:
+			result = storeResultExists
+		}
+		if result != storeResultStored {
+			return nil, 0, false
+		}
+
+		newCAS = s.nextCAS()
+		newValue := encodeItem(item{flags: flags, cas: newCAS, expire: expire, data: data})
+		return newValue, cacheTTL(now, expire), true
+	})
+	if err != nil {
+		return storeResultNotStored, 0, err
+	}
+	return result, newCAS, nil
+}
+
+// delete removes the key together with its lease, returns false if the key has no live item
+func (s *store) delete(key []byte) bool {
+	now := s.now()
+
+	live := false
+	s.cache.DeleteIf(key, func(value []byte, found bool) bool {
+		_, live = s.decodeLive(value, found, now)
+		return true
+	})
+	return live
+}
+
+// touch sets the expire time of the item, returns false if the key has no live item
+func (s *store) touch(key []byte, expire int64) (bool, error) {
+	now := s.now()
+
+	return s.cache.Update(key, func(value []byte, found bool) ([]byte, time.Duration, bool) {
+		it, ok := s.decodeLive(value, found, now)
+		if !ok {
+			return nil, 0, false
+		}
+		it.expire = expire
+		return encodeItem(it), cacheTTL(now, expire), true
+	})
+}
+
+// incrDecr adds *delta* to the decimal value of the item, decrementing below zero results in zero.
+// Returns false if the key has no live item, and errNonNumeric if the value is NOT a decimal number
+func (s *store) incrDecr(key []byte, delta uint64, incr bool) (uint64, bool, error) {
+	now := s.now()
+
+	var result uint64
+	var parseErr error
+	ok, err := s.cache.Update(key, func(value []byte, found bool) ([]byte, time.Duration, bool) {
+		it, ok := s.decodeLive(value, found, now)
+		if !ok {
+			return nil, 0, false
+		}
+
+		num, err := strconv.ParseUint(strings.TrimRight(string(it.data), " "), 10, 64)
+		if err != nil {
+			parseErr = errNonNumeric
+			return nil, 0, false
+		}
+
+		switch {
+		case incr:
+			num += delta
+		case delta > num:
+			num = 0
+		default:
+			num -= delta
+		}
+		result = num
+
+		it.cas = s.nextCAS()
+		it.data = strconv.AppendUint(nil, num, 10)
+		return encodeItem(it), cacheTTL(now, it.expire), true
+	})
+	if parseErr != nil {
+		return 0, false, parseErr
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return result, ok, nil
+}
+
+// flushAll invalidates all items immediately, or after *delay* if it is positive
+func (s *store) flushAll(delay time.Duration) {
+	s.flushMut.Lock()
+	defer s.flushMut.Unlock()
+
+	if delay > 0 {
+		atomic.StoreInt64(&s.flushAt, s.clock.Now().Add(delay).UnixNano())
+		return
+	}
+	atomic.StoreUint64(&s.flushCAS, atomic.LoadUint64(&s.casSeq))
+	atomic.StoreInt64(&s.flushAt, 0)
+}
+
+// leaseResult is the result of store.leaseGet
+type leaseResult struct {
+	status  espresso.LeaseGetStatus
+	leaseID uint64
+	// it is the live item if hasItem, the stale item of an invalidated key if status is NOT LeaseGetStatusExisted
+	it      item
+	hasItem bool
+}
+
+func (r leaseResult) stale() bool {
+	return r.hasItem && r.status != espresso.LeaseGetStatusExisted
+}
+
+// leaseGet is espresso.Cache.LeaseGet on items, the item of an existing key that is expired or flushed is deleted
+// and a lease is requested again.
+// Stale items that are expired or flushed are NOT returned
+func (s *store) leaseGet(key []byte) (leaseResult, error) {
+	now := s.now()
+
+	for {
+		result, err := s.cache.LeaseGet(key)
+		if err != nil {
+			return leaseResult{}, err
+		}
+
+		it, ok := s.decodeLive(result.Value, result.Value != nil, now)
+		if result.Status == espresso.LeaseGetStatusExisted && !ok {
+			// the key can be set again concurrently, only the dead item is deleted
+			s.cache.DeleteIf(key, func(value []byte, found bool) bool {
+				_, live := s.decodeLive(value, found, now)
+				return found && !live
+			})
+			continue
+		}
+
+		return leaseResult{
+			status:  result.Status,
+			leaseID: result.LeaseID,
+			it:      it,
+			hasItem: ok,
+		}, nil
+	}
+}
+
+// leaseSet stores the item with the lease granted by leaseGet, returns storeResultExists if the lease is
+// no longer held by the caller and storeResultNotFound if the key had been deleted or evicted
+func (s *store) leaseSet(key []byte, leaseID uint64, flags uint32, expire int64, data []byte) (
+	storeResult, uint64, error,
+) {
+	now := s.now()
+
+	cas := s.nextCAS()
+	value := encodeItem(item{flags: flags, cas: cas, expire: expire, data: data})
+
+	status, err := s.cache.LeaseSet(key, leaseID, 0, value, cacheTTL(now, expire))
+	switch {
+	case status == espresso.LeaseSetStatusAccepted:
+		return storeResultStored, cas, nil
+	case status == espresso.LeaseSetStatusLeaseMismatch:
+		return storeResultExists, 0, nil
+	case status == espresso.LeaseSetStatusEntryGone && errors.Is(err, espresso.ErrLeaseMismatch):
+		// the entry is also gone with ErrNoSpace or ErrValueTooLarge, those are returned as errors
+		return storeResultNotFound, 0, nil
+	default:
+		return storeResultNotStored, 0, err
+	}
+}
+
+// invalidate marks the item as stale, see espresso.Cache.Invalidate.
+// Returns false if the key has no live item or lease, the expired or flushed item is NOT invalidated
+func (s *store) invalidate(key []byte) bool {
+	now := s.now()
+
+	return s.cache.InvalidateIf(key, func(value []byte, found bool) bool {
+		if !found {
+			// a lease or an already stale item
+			return true
+		}
+		_, ok := s.decodeLive(value, found, now)
+		return ok
+	})
+}
+
+// deleteCAS deletes the item if its CAS unique is *cas*, by replacing it with an expired item
+func (s *store) deleteCAS(key []byte, cas uint64) (storeResult, error) {
+	now := s.now()
+
+	result := storeResultStored
+	_, err := s.cache.Update(key, func(value []byte, found bool) ([]byte, time.Duration, bool) {
+		it, ok := s.decodeLive(value, found, now)
+		switch {
+		case !ok:
+			result = storeResultNotFound
+			return nil, 0, false
+		case it.cas != cas:
+			result = storeResultExists
+			return nil, 0, false
+		}
+
+		it.expire = now.UnixNano()
+		it.data = nil
+		return encodeItem(it), cacheTTL(now, it.expire), true
+	})
+	if err != nil {
+		return storeResultNotStored, err
+	}
+	return result, nil
+}
+
+// expireOf returns the expire time of the live item of the key, zero means no expiration
+func (s *store) expireOf(key []byte) (int64, bool) {
+	now := s.now()
+
+	expire := int64(0)
+	live := false
+	s.cache.View(key, func(value []byte) {
+		var it item
+		it, live = s.decodeLive(value, true, now)
+		expire = it.expire
+	})
+	return expire, live
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestEncodeItem(t *testing.T) {
+	value := encodeItem(item{flags: 7, cas: 123, expire: 456, data: []byte("value01")})
+	assert.Equal(t, itemHeaderSize+7, len(value))
+
+	it, ok := decodeItem(value)
+	assert.True(t, ok)
+	assert.Equal(t, item{flags: 7, cas: 123, expire: 456, data: []byte("value01")}, it)
+
+	_, ok = decodeItem(value[:itemHeaderSize-1])
+	assert.False(t, ok)
+}
+
+func TestMemcacheExpire(t *testing.T) {
+	now := time.Date(2021, 6, 10, 8, 0, 0, 0, time.UTC)
+
+	table := []struct {
+		name     string
+		exptime  int64
+		expected int64
+	}{
+		{name: "no-expiration", exptime: 0, expected: 0},
+		{name: "negative", exptime: -1, expected: now.UnixNano()},
+		{name: "relative", exptime: 10, expected: now.Add(10 * time.Second).UnixNano()},
+		{
+			name:     "max-relative",
+			exptime:  maxRelativeExpire,
+			expected: now.Add(maxRelativeExpire * time.Second).UnixNano(),
+		},
+		{
+			name:     "unix-timestamp",
+			exptime:  now.Unix() + 20,
+			expected: now.Add(20 * time.Second).UnixNano(),
+		},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			assert.Equal(t, e.expected, memcacheExpire(now, e.exptime))
+		})
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	now := time.Date(2021, 6, 10, 8, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, time.Duration(0), cacheTTL(now, 0))
+	assert.Equal(t, 10*time.Second, cacheTTL(now, now.Add(10*time.Second).UnixNano()))
+	assert.Equal(t, time.Nanosecond, cacheTTL(now, now.UnixNano()))
+	assert.Equal(t, time.Nanosecond, cacheTTL(now, now.Add(-time.Second).UnixNano()))
+}
@@ -0,0 +1,200 @@
+package espresso
+
+import (
+	"github.com/QuangTung97/espresso/allocator"
+	"github.com/QuangTung97/espresso/lru"
+)
+
+// PartitionCounters are cumulative since the partition was created
+type PartitionCounters struct {
+	Hits          uint64
+	Misses        uint64
+	LeaseGranted  uint64
+	LeaseRejected uint64
+
+	// AdmissionEvictions is the number of entries evicted from the admission window,
+	// including the candidates rejected by TinyLFU
+	AdmissionEvictions uint64
+	ProbationEvictions uint64
+	ProtectedEvictions uint64
+	// AdmissionRejections is the number of candidates evicted because their frequencies were NOT higher
+	// than the frequencies of the victims in probation
+	AdmissionRejections uint64
+
+	// Expirations is the number of entries removed after their TTL
+	Expirations uint64
+	// SlabMoves is the number of chunks moved between slab classes by SlabAutomove
+	SlabMoves uint64
+}
+
+// LRUStats ...
+type LRUStats struct {
+	Size uint32
+	// Weight and Limit are in bytes
+	Weight uint32
+	Limit  uint32
+}
+
+// PartitionStats is a snapshot of a partition
+type PartitionStats struct {
+	PartitionCounters
+
+	NumEntries uint32
+	Admission  LRUStats
+	Protected  LRUStats
+	Probation  LRUStats
+
+	Allocator allocator.Stats
+}
+
+// CacheStats is a snapshot of all partitions of a cache, the counters are summed over the partitions
+type CacheStats struct {
+	PartitionCounters
+
+	NumEntries uint64
+	// MemUsage is the sum of allocator.Stats.MemUsage
+	MemUsage uint64
+
+	Partitions []PartitionStats
+}
+
+// LRUSum is LRUStats summed over the partitions
+type LRUSum struct {
+	Size uint64
+	// Weight and Limit are in bytes
+	Weight uint64
+	Limit  uint64
+}
+
+// LRUTotals are the LRU segments summed over the partitions
+type LRUTotals struct {
+	Admission LRUSum
+	Protected LRUSum
+	Probation LRUSum
+}
+
+// SlabSum is a slab class summed over the partitions
+type SlabSum struct {
+	ElemSize  uint32
+	NumItems  uint64
+	NumChunks uint64
+
+	ItemBytes   uint64
+	WastedBytes uint64
+}
+
+// HitRatio returns zero if there is no access
+func (c PartitionCounters) HitRatio() float64 {
+	total := c.Hits + c.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(c.Hits) / float64(total)
+}
+
+func (c *PartitionCounters) add(other PartitionCounters) {
+	c.Hits += other.Hits
+	c.Misses += other.Misses
+	c.LeaseGranted += other.LeaseGranted
+	c.LeaseRejected += other.LeaseRejected
+
+	c.AdmissionEvictions += other.AdmissionEvictions
+	c.ProbationEvictions += other.ProbationEvictions
+	c.ProtectedEvictions += other.ProtectedEvictions
+	c.AdmissionRejections += other.AdmissionRejections
+
+	c.Expirations += other.Expirations
+	c.SlabMoves += other.SlabMoves
+}
+
+func (c *PartitionCounters) recordEviction(lruList lruListType) {
+	switch lruList {
+	case lruListAdmission:
+		c.AdmissionEvictions++
+	case lruListProtected:
+		c.ProtectedEvictions++
+	default:
+		c.ProbationEvictions++
+	}
+}
+
+func lruStats(l *lru.LRU) LRUStats {
+	return LRUStats{
+		Size:   l.Size(),
+		Weight: l.Weight(),
+		Limit:  l.Limit(),
+	}
+}
+
+// Stats returns a snapshot of the partition
+func (p *Partition) Stats() PartitionStats {
+	return PartitionStats{
+		PartitionCounters: p.counters,
+
+		NumEntries: p.numEntries(),
+		Admission:  lruStats(p.admission),
+		Protected:  lruStats(p.protected),
+		Probation:  lruStats(p.probation),
+
+		Allocator: p.allocator.Stats(),
+	}
+}
+
+// Stats returns a snapshot of the cache, every partition is locked in turn
+func (c *Cache) Stats() CacheStats {
+	result := CacheStats{
+		Partitions: make([]PartitionStats, 0, len(c.partitions)),
+	}
+
+	for i := range c.partitions {
+		cp := &c.partitions[i]
+
+		cp.mut.Lock()
+		stats := cp.partition.Stats()
+		cp.mut.Unlock()
+
+		result.PartitionCounters.add(stats.PartitionCounters)
+		result.NumEntries += uint64(stats.NumEntries)
+		result.MemUsage += stats.Allocator.MemUsage
+		result.Partitions = append(result.Partitions, stats)
+	}
+	return result
+}
+
+func (s *LRUSum) add(stats LRUStats) {
+	s.Size += uint64(stats.Size)
+	s.Weight += uint64(stats.Weight)
+	s.Limit += uint64(stats.Limit)
+}
+
+// LRUTotals sums the LRU segments over the partitions
+func (c CacheStats) LRUTotals() LRUTotals {
+	var result LRUTotals
+	for _, p := range c.Partitions {
+		result.Admission.add(p.Admission)
+		result.Protected.add(p.Protected)
+		result.Probation.add(p.Probation)
+	}
+	return result
+}
+
+// SlabTotals sums the slab classes over the partitions, all partitions have the same slab classes
+func (c CacheStats) SlabTotals() []SlabSum {
+	if len(c.Partitions) == 0 {
+		return nil
+	}
+
+	result := make([]SlabSum, 0, len(c.Partitions[0].Allocator.Slabs))
+	for i, s := range c.Partitions[0].Allocator.Slabs {
+		sum := SlabSum{ElemSize: s.ElemSize}
+		for _, p := range c.Partitions {
+			slab := p.Allocator.Slabs[i]
+			sum.NumItems += uint64(slab.NumItems)
+			sum.NumChunks += uint64(slab.NumChunks)
+			sum.ItemBytes += slab.ItemBytes
+			sum.WastedBytes += slab.WastedBytes
+		}
+		result = append(result, sum)
+	}
+	return result
+}
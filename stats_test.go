@@ -0,0 +1,128 @@
+package espresso
+
+import (
+	"github.com/QuangTung97/espresso/allocator"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPartitionCounters_HitRatio(t *testing.T) {
+	assert.Equal(t, 0.0, PartitionCounters{}.HitRatio())
+	assert.Equal(t, 0.25, PartitionCounters{Hits: 1, Misses: 3}.HitRatio())
+}
+
+func TestPartition_Stats(t *testing.T) {
+	p := newEvictTestPartition()
+
+	result, _ := p.leaseGet(1100, []byte{1, 2, 3})
+	p.leaseGet(1100, []byte{1, 2, 3})
+	p.leaseSet(1100, []byte{1, 2, 3}, result.LeaseID, 101, []byte{10, 20}, 0)
+	p.getValue(1100, []byte{1, 2, 3})
+	p.getValue(2200, []byte{1, 2, 3})
+
+	stats := p.Stats()
+	assert.Equal(t, PartitionCounters{
+		Hits:          1,
+		Misses:        3,
+		LeaseGranted:  1,
+		LeaseRejected: 1,
+	}, stats.PartitionCounters)
+
+	assert.Equal(t, uint32(1), stats.NumEntries)
+	assert.Equal(t, LRUStats{Size: 1, Weight: 48, Limit: 3 * 48}, stats.Admission)
+	assert.Equal(t, LRUStats{Size: 0, Weight: 0, Limit: 50 * 48}, stats.Protected)
+	assert.Equal(t, LRUStats{Size: 0, Weight: 0, Limit: math.MaxUint32}, stats.Probation)
+
+	assert.Equal(t, uint32(1), stats.Allocator.Slabs[0].NumItems)
+	assert.Equal(t, uint64(48), stats.Allocator.Slabs[0].ItemBytes)
+}
+
+func TestPartition_Stats_Evictions(t *testing.T) {
+	p := newEvictTestPartition()
+
+	const numKeys = 1000
+	for i := uint64(1); i <= numKeys; i++ {
+		p.set(i, []byte{1, 2, 3}, []byte{10, 20}, 0)
+		if i%3 == 0 {
+			// frequently accessed entries are admitted
+			p.getValue(i, []byte{1, 2, 3})
+			p.getValue(i, []byte{1, 2, 3})
+		}
+	}
+
+	stats := p.Stats()
+	evictions := stats.AdmissionEvictions + stats.ProbationEvictions + stats.ProtectedEvictions
+	assert.Equal(t, uint64(numKeys-stats.NumEntries), evictions)
+	assert.Greater(t, stats.AdmissionRejections, uint64(0))
+	assert.LessOrEqual(t, stats.AdmissionRejections, stats.AdmissionEvictions)
+}
+
+func TestPartition_Stats_Expirations(t *testing.T) {
+	clock := newFakeClock()
+	p := newTTLTestPartition(clock, time.Second)
+
+	p.set(1100, []byte{1, 2, 3}, []byte{10, 20}, time.Second)
+	p.set(2200, []byte{1, 2, 3}, []byte{10, 20}, time.Hour)
+	clock.Advance(2 * time.Second)
+
+	_, ok := p.getValue(1100, []byte{1, 2, 3})
+	assert.False(t, ok)
+	assert.Equal(t, uint64(1), p.Stats().Expirations)
+	assert.Equal(t, uint32(1), p.Stats().NumEntries)
+}
+
+func TestCache_Stats(t *testing.T) {
+	c := NewCache(newTestCacheConfig(4))
+
+	for i := 0; i < 20; i++ {
+		key := []byte{byte(i)}
+		assert.Equal(t, nil, c.Set(key, []byte("value"), 0))
+		c.Get(key, nil)
+	}
+	c.Get([]byte("not-found"), nil)
+
+	stats := c.Stats()
+	assert.Equal(t, 4, len(stats.Partitions))
+	assert.Equal(t, uint64(20), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+	assert.Equal(t, uint64(20), stats.NumEntries)
+
+	memUsage := uint64(0)
+	numEntries := uint32(0)
+	for _, partitionStats := range stats.Partitions {
+		memUsage += partitionStats.Allocator.MemUsage
+		numEntries += partitionStats.NumEntries
+	}
+	assert.Equal(t, memUsage, stats.MemUsage)
+	assert.Equal(t, uint32(20), numEntries)
+}
+
+func TestCacheStats_Totals(t *testing.T) {
+	partition := PartitionStats{
+		Admission: LRUStats{Size: 1, Weight: 48, Limit: 480},
+		Protected: LRUStats{Size: 2, Weight: 96, Limit: 1<<32 - 1},
+		Probation: LRUStats{Size: 3, Weight: 144},
+		Allocator: allocator.Stats{
+			Slabs: []allocator.SlabStats{
+				{ElemSize: 48, NumItems: 3, NumChunks: 1, ItemBytes: 144, WastedBytes: 4096 - 144},
+				{ElemSize: 96, NumItems: 1, NumChunks: 1, ItemBytes: 96, WastedBytes: 4096 - 96},
+			},
+		},
+	}
+	stats := CacheStats{Partitions: []PartitionStats{partition, partition}}
+
+	assert.Equal(t, LRUTotals{
+		Admission: LRUSum{Size: 2, Weight: 96, Limit: 960},
+		Protected: LRUSum{Size: 4, Weight: 192, Limit: 2 * (1<<32 - 1)},
+		Probation: LRUSum{Size: 6, Weight: 288},
+	}, stats.LRUTotals())
+
+	assert.Equal(t, []SlabSum{
+		{ElemSize: 48, NumItems: 6, NumChunks: 2, ItemBytes: 288, WastedBytes: 2 * (4096 - 144)},
+		{ElemSize: 96, NumItems: 2, NumChunks: 2, ItemBytes: 192, WastedBytes: 2 * (4096 - 96)},
+	}, stats.SlabTotals())
+
+	assert.Nil(t, CacheStats{}.SlabTotals())
+}
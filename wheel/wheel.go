@@ -0,0 +1,217 @@
+package wheel
+
+import (
+	"github.com/QuangTung97/espresso/allocator"
+	"math"
+)
+
+const nullPtr uint32 = math.MaxUint32
+
+const (
+	levelBits = 6
+	numSlots  = 1 << levelBits
+	slotMask  = numSlots - 1
+	numLevels = 4
+
+	// maxDelay is the max number of ticks a node can be placed ahead of the current tick,
+	// nodes having longer delays are placed at the last slot and re-placed when cascaded
+	maxDelay = 1<<(levelBits*numLevels) - 1
+)
+
+// ExpireFunc is called for every expired node, the node is already freed when it is called
+type ExpireFunc func(addr uint32, hash uint64)
+
+// Wheel is a hierarchical timing wheel, nodes are allocated from a RealSlab.
+// Level *l* has 64 slots, each slot covers 64^l ticks.
+// Nodes in the higher levels are cascaded to the lower levels when the current tick reaches their slots
+type Wheel struct {
+	slab     *allocator.RealSlab
+	current  uint64
+	size     uint32
+	counts   [numLevels]uint32
+	buckets  [numLevels * numSlots]uint32
+	onExpire ExpireFunc
+}
+
+// Node ...
+type Node struct {
+	next   uint32
+	prev   uint32
+	bucket uint32
+	hash   uint64
+	expire uint64
+}
+
+// New creates a timing wheel starting at the tick *current*
+func New(slab *allocator.RealSlab, current uint64) *Wheel {
+	w := &Wheel{
+		slab:    slab,
+		current: current,
+	}
+	for i := range w.buckets {
+		w.buckets[i] = nullPtr
+	}
+	return w
+}
+
+// SetExpireFunc registers the function that will be called for expired nodes
+func (w *Wheel) SetExpireFunc(fn ExpireFunc) {
+	w.onExpire = fn
+}
+
+func (w *Wheel) getNode(addr uint32) *Node {
+	return (*Node)(w.slab.ToRealAddr(addr))
+}
+
+func findBucket(current uint64, expire uint64) uint32 {
+	delay := uint64(0)
+	if expire > current {
+		delay = expire - current
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+
+	tick := current + delay
+	level := uint32(0)
+	for delay >= numSlots && level < numLevels-1 {
+		delay >>= levelBits
+		level++
+	}
+	slot := uint32(tick>>(levelBits*level)) & slotMask
+	return level*numSlots + slot
+}
+
+func (w *Wheel) place(addr uint32) {
+	node := w.getNode(addr)
+	bucket := findBucket(w.current, node.expire)
+
+	node.bucket = bucket
+	node.prev = nullPtr
+	node.next = w.buckets[bucket]
+	if node.next != nullPtr {
+		w.getNode(node.next).prev = addr
+	}
+	w.buckets[bucket] = addr
+	w.counts[bucket/numSlots]++
+}
+
+// Add adds a node that expires at the tick *expire*, returns false if the node can NOT be allocated.
+// Nodes that already expired will be expired at the next tick
+func (w *Wheel) Add(hash uint64, expire uint64) (uint32, bool) {
+	addr, ok := w.slab.Allocate()
+	if !ok {
+		return 0, false
+	}
+
+	if expire <= w.current {
+		expire = w.current + 1
+	}
+
+	node := w.getNode(addr)
+	node.hash = hash
+	node.expire = expire
+	w.place(addr)
+	w.size++
+
+	return addr, true
+}
+
+// Delete ...
+func (w *Wheel) Delete(addr uint32) {
+	node := w.getNode(addr)
+
+	if node.next != nullPtr {
+		w.getNode(node.next).prev = node.prev
+	}
+	if node.prev != nullPtr {
+		w.getNode(node.prev).next = node.next
+	} else {
+		w.buckets[node.bucket] = node.next
+	}
+
+	w.counts[node.bucket/numSlots]--
+	w.size--
+	w.slab.Deallocate(addr)
+}
+
+// Expire returns the expire tick of the node
+func (w *Wheel) Expire(addr uint32) uint64 {
+	return w.getNode(addr).expire
+}
+
+func (w *Wheel) detach(bucket uint32) uint32 {
+	addr := w.buckets[bucket]
+	w.buckets[bucket] = nullPtr
+	return addr
+}
+
+func (w *Wheel) cascade(level uint32) {
+	bucket := level*numSlots + uint32(w.current>>(levelBits*level))&slotMask
+
+	addr := w.detach(bucket)
+	for addr != nullPtr {
+		next := w.getNode(addr).next
+		w.counts[level]--
+		w.place(addr)
+		addr = next
+	}
+}
+
+func (w *Wheel) expireCurrentSlot() {
+	addr := w.detach(uint32(w.current) & slotMask)
+	for addr != nullPtr {
+		node := w.getNode(addr)
+		next := node.next
+		hash := node.hash
+
+		w.counts[0]--
+		w.size--
+		w.slab.Deallocate(addr)
+
+		if w.onExpire != nil {
+			w.onExpire(addr, hash)
+		}
+		addr = next
+	}
+}
+
+// Advance moves the current tick to *now*, expiring all nodes having expire <= *now*
+func (w *Wheel) Advance(now uint64) {
+	for w.current < now {
+		if w.size == 0 {
+			w.current = now
+			return
+		}
+
+		// skip the ticks having nothing to expire or to cascade
+		step := uint64(1)
+		for level := 0; level < numLevels-1 && w.counts[level] == 0; level++ {
+			step <<= levelBits
+		}
+
+		next := (w.current | (step - 1)) + 1
+		if next > now {
+			w.current = now
+			return
+		}
+		w.current = next
+
+		for level := uint32(numLevels - 1); level > 0; level-- {
+			if w.current&(1<<(levelBits*level)-1) == 0 {
+				w.cascade(level)
+			}
+		}
+		w.expireCurrentSlot()
+	}
+}
+
+// Current returns the current tick
+func (w *Wheel) Current() uint64 {
+	return w.current
+}
+
+// Size returns the number of nodes
+func (w *Wheel) Size() uint32 {
+	return w.size
+}
@@ -0,0 +1,228 @@
+package wheel
+
+import (
+	"github.com/QuangTung97/espresso/allocator"
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"sort"
+	"testing"
+	"unsafe"
+)
+
+func newTestWheel(current uint64) *Wheel {
+	data := make([]uint64, 1<<14)
+	var buddy allocator.Buddy
+	allocator.BuddyInit(&buddy, 12, 8, unsafe.Pointer(&data[0]))
+
+	slab := allocator.NewRealSlab(&buddy, uint32(unsafe.Sizeof(Node{})), 12)
+	return New(slab, current)
+}
+
+type expiredNode struct {
+	addr uint32
+	hash uint64
+}
+
+func collectExpired(w *Wheel) *[]expiredNode {
+	var result []expiredNode
+	w.SetExpireFunc(func(addr uint32, hash uint64) {
+		result = append(result, expiredNode{addr: addr, hash: hash})
+	})
+	return &result
+}
+
+func TestSizeOfNode(t *testing.T) {
+	assert.Equal(t, uintptr(32), unsafe.Sizeof(Node{}))
+}
+
+func TestNew(t *testing.T) {
+	w := newTestWheel(1000)
+	assert.Equal(t, uint64(1000), w.Current())
+	assert.Equal(t, uint32(0), w.Size())
+	for _, b := range w.buckets {
+		assert.Equal(t, nullPtr, b)
+	}
+}
+
+func TestFindBucket(t *testing.T) {
+	table := []struct {
+		name     string
+		current  uint64
+		expire   uint64
+		expected uint32
+	}{
+		{
+			name:     "already-expired",
+			current:  100,
+			expire:   90,
+			expected: 100 & slotMask,
+		},
+		{
+			name:     "level-0",
+			current:  100,
+			expire:   110,
+			expected: 110 & slotMask,
+		},
+		{
+			name:     "level-0-max",
+			current:  100,
+			expire:   163,
+			expected: 163 & slotMask,
+		},
+		{
+			name:     "level-1",
+			current:  100,
+			expire:   164,
+			expected: numSlots + (164>>6)&slotMask,
+		},
+		{
+			name:     "level-2",
+			current:  100,
+			expire:   100 + 4096,
+			expected: 2*numSlots + ((100+4096)>>12)&slotMask,
+		},
+		{
+			name:     "level-3",
+			current:  0,
+			expire:   1 << 18,
+			expected: 3*numSlots + 1,
+		},
+		{
+			name:     "exceed-max-delay",
+			current:  0,
+			expire:   1 << 30,
+			expected: 3*numSlots + 63,
+		},
+	}
+
+	for _, e := range table {
+		t.Run(e.name, func(t *testing.T) {
+			assert.Equal(t, e.expected, findBucket(e.current, e.expire))
+		})
+	}
+}
+
+func TestWheel_Add_Advance(t *testing.T) {
+	w := newTestWheel(1000)
+	expired := collectExpired(w)
+
+	addr1, ok := w.Add(11, 1005)
+	assert.True(t, ok)
+	addr2, ok := w.Add(22, 1003)
+	assert.True(t, ok)
+	assert.Equal(t, uint32(2), w.Size())
+	assert.Equal(t, uint64(1005), w.Expire(addr1))
+
+	w.Advance(1002)
+	assert.Equal(t, 0, len(*expired))
+	assert.Equal(t, uint64(1002), w.Current())
+
+	w.Advance(1003)
+	assert.Equal(t, []expiredNode{{addr: addr2, hash: 22}}, *expired)
+	assert.Equal(t, uint32(1), w.Size())
+
+	w.Advance(1010)
+	assert.Equal(t, []expiredNode{{addr: addr2, hash: 22}, {addr: addr1, hash: 11}}, *expired)
+	assert.Equal(t, uint32(0), w.Size())
+	assert.Equal(t, uint64(1010), w.Current())
+}
+
+func TestWheel_Add_Already_Expired(t *testing.T) {
+	w := newTestWheel(1000)
+	expired := collectExpired(w)
+
+	addr, _ := w.Add(11, 990)
+	assert.Equal(t, uint64(1001), w.Expire(addr))
+
+	w.Advance(1000)
+	assert.Equal(t, 0, len(*expired))
+
+	w.Advance(1001)
+	assert.Equal(t, []expiredNode{{addr: addr, hash: 11}}, *expired)
+}
+
+func TestWheel_Delete(t *testing.T) {
+	w := newTestWheel(1000)
+	expired := collectExpired(w)
+
+	addr1, _ := w.Add(11, 1005)
+	addr2, _ := w.Add(22, 1005)
+	addr3, _ := w.Add(33, 1005)
+
+	w.Delete(addr2)
+	w.Delete(addr3)
+	assert.Equal(t, uint32(1), w.Size())
+
+	w.Advance(2000)
+	assert.Equal(t, []expiredNode{{addr: addr1, hash: 11}}, *expired)
+	assert.Equal(t, uint64(0), w.slab.GetMemUsage())
+}
+
+func TestWheel_Cascade(t *testing.T) {
+	w := newTestWheel(100)
+	expired := collectExpired(w)
+
+	w.Add(11, 100+5000)
+	w.Add(22, 100+300000)
+	w.Add(33, 100+50)
+	w.Add(44, 100+70)
+
+	w.Advance(100 + 4999)
+	assert.Equal(t, 2, len(*expired))
+
+	w.Advance(100 + 5000)
+	assert.Equal(t, 3, len(*expired))
+	assert.Equal(t, uint64(11), (*expired)[2].hash)
+
+	w.Advance(100 + 299999)
+	assert.Equal(t, 3, len(*expired))
+
+	w.Advance(100 + 300000)
+	assert.Equal(t, 4, len(*expired))
+	assert.Equal(t, uint64(22), (*expired)[3].hash)
+}
+
+func TestWheel_Exceed_Max_Delay(t *testing.T) {
+	w := newTestWheel(0)
+	expired := collectExpired(w)
+
+	w.Add(11, 3*maxDelay)
+
+	w.Advance(3*maxDelay - 1)
+	assert.Equal(t, 0, len(*expired))
+	assert.Equal(t, uint32(1), w.Size())
+
+	w.Advance(3 * maxDelay)
+	assert.Equal(t, 1, len(*expired))
+}
+
+func TestWheel_Random(t *testing.T) {
+	w := newTestWheel(12345)
+
+	var expiredAt []uint64
+	expires := map[uint64]uint64{}
+	w.SetExpireFunc(func(addr uint32, hash uint64) {
+		assert.Equal(t, expires[hash], w.Current())
+		expiredAt = append(expiredAt, w.Current())
+		delete(expires, hash)
+	})
+
+	r := rand.New(rand.NewSource(0))
+	for i := uint64(0); i < 400; i++ {
+		expire := w.Current() + uint64(r.Int63n(1<<r.Intn(22)))
+		if expire == w.Current() {
+			expire++
+		}
+		expires[i] = expire
+		w.Add(i, expire)
+
+		w.Advance(w.Current() + uint64(r.Intn(300)))
+	}
+
+	w.Advance(w.Current() + maxDelay)
+	assert.Equal(t, 0, len(expires))
+	assert.Equal(t, uint32(0), w.Size())
+	assert.True(t, sort.SliceIsSorted(expiredAt, func(i, j int) bool {
+		return expiredAt[i] < expiredAt[j]
+	}))
+}